@@ -2,25 +2,117 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
+	"github.com/poy/triple-c/internal/handlers"
 )
 
 type Config struct {
 	Port            uint16          `env:"PORT, required, report"`
-	VcapApplication VcapApplication `env:"VCAP_APPLICATION, required"`
+	VcapApplication VcapApplication `env:"VCAP_APPLICATION"`
 	DataDir         string          `env:"DATA_DIR"`
 
-	ClientID          string `env:"CLIENT_ID, required"`
-	RefreshToken      string `env:"REFRESH_TOKEN, required"`
+	// ArtifactDir is where task outputs are kept. Runs are removed, oldest
+	// first, once there are more than ArtifactMaxRuns, they are older than
+	// ArtifactMaxAge or the artifacts take up more than ArtifactMaxBytes.
+	ArtifactDir      string        `env:"ARTIFACT_DIR, report"`
+	ArtifactMaxRuns  int           `env:"ARTIFACT_MAX_RUNS, report"`
+	ArtifactMaxAge   time.Duration `env:"ARTIFACT_MAX_AGE, report"`
+	ArtifactMaxBytes int64         `env:"ARTIFACT_MAX_BYTES, report"`
+
+	// CacheDir is where task caches are kept. Caches are removed, oldest
+	// first, once they are older than CacheMaxAge or take up more than
+	// CacheMaxBytes. A single cache may be at most CacheMaxSize.
+	CacheDir      string        `env:"CACHE_DIR, report"`
+	CacheMaxAge   time.Duration `env:"CACHE_MAX_AGE, report"`
+	CacheMaxBytes int64         `env:"CACHE_MAX_BYTES, report"`
+	CacheMaxSize  int64         `env:"CACHE_MAX_SIZE, report"`
+
+	// ArtifactBackend is either "disk" or "s3". With "s3", tasks upload and
+	// download artifacts directly with presigned URLs and retention is left
+	// to the bucket's lifecycle rules.
+	ArtifactBackend   string        `env:"ARTIFACT_BACKEND, report"`
+	S3Endpoint        string        `env:"S3_ENDPOINT, report"`
+	S3Bucket          string        `env:"S3_BUCKET, report"`
+	S3Region          string        `env:"S3_REGION, report"`
+	S3AccessKeyID     string        `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string        `env:"S3_SECRET_ACCESS_KEY"`
+	S3PathStyle       bool          `env:"S3_PATH_STYLE, report"`
+	S3URLExpiry       time.Duration `env:"S3_URL_EXPIRY, report"`
+
+	// TransferWait is how long a task downloading an artifact waits for the
+	// task producing it to start uploading.
+	TransferWait time.Duration `env:"TRANSFER_WAIT, report"`
+
+	// ExternalAddr is the address tasks use to reach triple-c. It defaults
+	// to the first application URI when running on Cloud Foundry.
+	ExternalAddr string `env:"EXTERNAL_ADDR, report"`
+
+	// Executor is the executor plans use when they don't name one. It is
+	// either "capi", "local" or "docker".
+	Executor         string `env:"EXECUTOR, report"`
+	LocalConcurrency int    `env:"LOCAL_CONCURRENCY, report"`
+	DockerImage      string `env:"DOCKER_IMAGE, report"`
+
+	ClientID          string `env:"CLIENT_ID"`
+	RefreshToken      string `env:"REFRESH_TOKEN"`
 	SkipSSLValidation bool   `env:"SKIP_SSL_VALIDATION, report"`
 
-	RepoPath   string `env:"REPO_PATH, required, report"`
+	RepoPath string `env:"REPO_PATH, required, report"`
+
+	// LogLevel is the lowest level logged: "debug", "info", "warn" or
+	// "error".
+	LogLevel string `env:"LOG_LEVEL, report"`
+
+	// OTLPEndpoint is the OpenTelemetry collector (e.g.,
+	// http://localhost:4318) spans are exported to. Tracing is disabled
+	// without it.
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT, report"`
+	ServiceName  string `env:"OTEL_SERVICE_NAME, report"`
+
+	// HealthMaxFetchAge is how long a repo may go without being fetched
+	// before /readyz fails. HealthMaxBranchAge is the same for listing the
+	// config repo's branches, and /healthz fails once the branch watcher
+	// hasn't even tried for that long.
+	HealthMaxFetchAge  time.Duration `env:"HEALTH_MAX_FETCH_AGE, report"`
+	HealthMaxBranchAge time.Duration `env:"HEALTH_MAX_BRANCH_AGE, report"`
+
+	// APITokens are static tokens for the API. With AuthJWKSURL, JWTs
+	// signed by its keys (e.g., UAA's /token_keys) are accepted too, if
+	// they have AuthJWTIssuer and AuthJWTAudience (when set) and a scope
+	// starting with AuthScopePrefix. The API is open without either.
+	APITokens       APITokens `env:"API_TOKENS"`
+	AuthJWKSURL     string    `env:"AUTH_JWKS_URL, report"`
+	AuthJWTIssuer   string    `env:"AUTH_JWT_ISSUER, report"`
+	AuthJWTAudience string    `env:"AUTH_JWT_AUDIENCE, report"`
+	AuthScopePrefix string    `env:"AUTH_SCOPE_PREFIX, report"`
+
+	// TagBranch is the config branch (e.g., main or remotes/origin/main)
+	// whose plans are run for new tags.
+	// Plans triggered by tags on other branches are ignored, so that a tag
+	// is only built once.
+	TagBranch string `env:"TAG_BRANCH, report"`
+
+	// DashboardLogsURL links runs and tasks in the dashboard to their logs.
+	// {run_id} and {task} are replaced with the run ID and task name (e.g.,
+	// https://logs.example.com/?q=run_id:{run_id}).
+	DashboardLogsURL string `env:"DASHBOARD_LOGS_URL, report"`
+
+	// ConfigPath is the plans file within the config repo. It may also be a
+	// glob (e.g. pipelines/*.yml) or a directory of YAML files, in which case
+	// every matching file is merged.
 	ConfigPath string `env:"CONFIG_PATH, required, report"`
 
 	// Figured out via VcapApplication
 	UAAAddr string
+
+	// Figured out via LogLevel
+	Level slog.Level
 }
 
 type VcapApplication struct {
@@ -33,16 +125,102 @@ func (a *VcapApplication) UnmarshalEnv(data string) error {
 	return json.Unmarshal([]byte(data), a)
 }
 
+// APITokens is a comma separated list of <name>:<scope>:<token> (e.g.,
+// ci:trigger:s3cret), where scope is read, trigger or admin.
+type APITokens []handlers.StaticToken
+
+func (t *APITokens) UnmarshalEnv(data string) error {
+	for _, entry := range strings.Split(data, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return errors.New("API tokens must be <name>:<scope>:<token>")
+		}
+
+		scope, err := handlers.ParseScope(parts[1])
+		if err != nil {
+			return fmt.Errorf("API token %s: %s", parts[0], err)
+		}
+
+		*t = append(*t, handlers.StaticToken{
+			Name:   parts[0],
+			Token:  parts[2],
+			Scopes: []handlers.Scope{scope},
+		})
+	}
+	return nil
+}
+
 func LoadConfig() (Config, error) {
 	cfg := Config{
-		Port:    8080,
-		DataDir: "/dev/shm",
+		Port:               8080,
+		DataDir:            "/dev/shm",
+		ArtifactDir:        "artifacts",
+		ArtifactMaxRuns:    100,
+		ArtifactMaxAge:     7 * 24 * time.Hour,
+		ArtifactMaxBytes:   1 << 30,
+		CacheDir:           "caches",
+		CacheMaxAge:        7 * 24 * time.Hour,
+		CacheMaxBytes:      5 << 30,
+		CacheMaxSize:       1 << 30,
+		ArtifactBackend:    "disk",
+		TransferWait:       5 * time.Minute,
+		S3Region:           "us-east-1",
+		S3URLExpiry:        12 * time.Hour,
+		Executor:           "capi",
+		LocalConcurrency:   4,
+		LogLevel:           "info",
+		ServiceName:        "triple-c",
+		HealthMaxFetchAge:  5 * time.Minute,
+		HealthMaxBranchAge: 5 * time.Minute,
+		AuthScopePrefix:    "triple-c.",
+		TagBranch:          "main",
 	}
 
 	if err := envstruct.Load(&cfg); err != nil {
 		return Config{}, err
 	}
 
+	switch cfg.Executor {
+	case "capi":
+		if cfg.VcapApplication.CAPIAddr == "" || cfg.ClientID == "" || cfg.RefreshToken == "" {
+			return Config{}, errors.New("VCAP_APPLICATION, CLIENT_ID and REFRESH_TOKEN are required for the capi executor")
+		}
+	case "local":
+	case "docker":
+		if cfg.DockerImage == "" {
+			return Config{}, errors.New("DOCKER_IMAGE is required for the docker executor")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown EXECUTOR %q", cfg.Executor)
+	}
+
+	switch cfg.ArtifactBackend {
+	case "disk":
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+			return Config{}, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for the s3 artifact backend")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown ARTIFACT_BACKEND %q", cfg.ArtifactBackend)
+	}
+
+	if err := cfg.Level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return Config{}, fmt.Errorf("unknown LOG_LEVEL %q", cfg.LogLevel)
+	}
+
+	if cfg.ExternalAddr == "" && len(cfg.VcapApplication.ApplicationURIs) > 0 {
+		cfg.ExternalAddr = cfg.VcapApplication.ApplicationURIs[0]
+	}
+
+	if cfg.ExternalAddr == "" {
+		cfg.ExternalAddr = fmt.Sprintf("localhost:%d", cfg.Port)
+	}
+
 	cfg.UAAAddr = strings.Replace(cfg.VcapApplication.CAPIAddr, "api", "uaa", 1)
 
 	return cfg, nil
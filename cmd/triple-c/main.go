@@ -7,71 +7,68 @@ import (
 	"crypto/tls"
 	"expvar"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	envstruct "code.cloudfoundry.org/go-envstruct"
+	"github.com/cloudfoundry-incubator/uaago"
+	"github.com/poy/triple-c/internal/artifacts"
 	"github.com/poy/triple-c/internal/capi"
+	"github.com/poy/triple-c/internal/dashboard"
 	"github.com/poy/triple-c/internal/git"
 	"github.com/poy/triple-c/internal/handlers"
+	"github.com/poy/triple-c/internal/local"
 	"github.com/poy/triple-c/internal/metrics"
 	"github.com/poy/triple-c/internal/scheduler"
-	"github.com/cloudfoundry-incubator/uaago"
-	"gopkg.in/yaml.v2"
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 func main() {
-	log := log.New(os.Stderr, "", log.LstdFlags)
-	log.Println("Starting triple-c...")
-	defer log.Println("Closing triple-c...")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+			if err := run(os.Args[2:], log); err != nil {
+				fatal(log, "run failed", "err", err)
+			}
+			return
+		case "validate":
+			validate(os.Args[2:])
+			return
+		}
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 
 	cfg, err := LoadConfig()
 	if err != nil {
-		log.Fatalf("invalid configuration: %s", err)
+		fatal(log, "invalid configuration", "err", err)
 	}
 
-	envstruct.WriteReport(&cfg)
+	// Anything still logging through the log package (e.g., net/http) goes
+	// through the same handler.
+	log = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level}))
+	slog.SetDefault(log)
 
-	uaaClient, err := uaago.NewClient(cfg.UAAAddr)
-	if err != nil {
-		log.Fatalf("invalid configuration: %s", err)
-	}
+	log.Info("starting triple-c")
+	defer log.Info("closing triple-c")
 
-	capi := capi.NewClient(
-		cfg.VcapApplication.CAPIAddr,
-		time.Second,
-		capi.NewHTTPClient(
-			&http.Client{
-				Timeout: 5 * time.Second,
-				Transport: &http.Transport{
-					TLSHandshakeTimeout: 10 * time.Second,
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: cfg.SkipSSLValidation,
-					},
-				},
-			},
-			capi.TokenFetcherFunc(func() (string, error) {
-				rt, at, err := uaaClient.GetRefreshToken(cfg.ClientID, cfg.RefreshToken, cfg.SkipSSLValidation)
-				if err != nil {
-					return "", err
-				}
-				cfg.RefreshToken = rt
-				return at, nil
-			}),
-		),
-	)
+	envstruct.WriteReport(&cfg)
 
 	m := metrics.New(expvar.NewMap("TripleC"))
+	tracer := newTracer(cfg.OTLPEndpoint, cfg.ServiceName, log)
 
 	tmpDir, err := ioutil.TempDir("", "")
 	if err != nil {
-		log.Fatalf("failed to create temp dir: %s", err)
+		fatal(log, "failed to create temp dir", "err", err)
 	}
-	log.Printf("temp dir is at: %s", tmpDir)
+	log.Info("created temp dir", "dir", tmpDir)
 
 	execer := git.ExecutorFunc(func(path string, commands ...string) ([]string, error) {
 		cmd := exec.Command(commands[0], commands[1:]...)
@@ -97,57 +94,103 @@ func main() {
 	})
 
 	shaTracker := metrics.NewSHATracker()
+	configTracker := metrics.NewConfigTracker()
+	runHistory := metrics.NewRunHistory(1000)
 
 	repoRegistry := git.NewRepoRegistry(tmpDir, execer, m)
 	configRepo, err := repoRegistry.FetchRepo(cfg.RepoPath)
 	if err != nil {
-		log.Fatalf("failed to get config repo (%s): %s", cfg.RepoPath, err)
+		fatal(log, "failed to get config repo", "repo", cfg.RepoPath, "err", err)
 	}
 
 	dataDir, err := ioutil.TempDir(cfg.DataDir, "triple-c")
 	if err != nil {
-		log.Fatalf("failed to create data dir in %s: %s", cfg.DataDir, err)
+		fatal(log, "failed to create data dir", "dir", cfg.DataDir, "err", err)
+	}
+
+	store := artifactStore(cfg, log)
+	caches, err := artifacts.NewStore(cfg.CacheDir, artifacts.RetentionPolicy{
+		MaxAge:   cfg.CacheMaxAge,
+		MaxBytes: cfg.CacheMaxBytes,
+	}, log)
+	if err != nil {
+		fatal(log, "failed to open cache store", "dir", cfg.CacheDir, "err", err)
 	}
 
-	transfer := handlers.NewTransfer(cfg.VcapApplication.ApplicationURIs[0], dataDir, log)
+	go func() {
+		for range time.Tick(time.Minute) {
+			caches.Prune()
+		}
+	}()
+
+	transfer := handlers.NewTransfer(cfg.ExternalAddr, store, caches, cfg.TransferWait, cfg.CacheMaxSize, tracer, log)
+
+	executors := map[string]scheduler.TaskCreator{
+		"local": local.NewExecutor(dataDir, cfg.LocalConcurrency, local.Bash, log),
+	}
+
+	if cfg.DockerImage != "" {
+		executors["docker"] = local.NewExecutor(dataDir, cfg.LocalConcurrency, local.Docker(cfg.DockerImage), log)
+	}
+
+	// tokens is left nil (rather than a nil *capi.HTTPClient) without CAPI
+	// so the health checks skip it.
+	var tokens handlers.TokenStatuser
+	if cfg.VcapApplication.CAPIAddr != "" && cfg.ClientID != "" {
+		capiClient, httpClient := newCAPIClient(&cfg, tracer, log)
+		executors["capi"] = capiClient
+		tokens = httpClient
+	}
 
 	startBranch := func(ctx context.Context, branch string) {
 		go func() {
-			log.Printf("Watching branch %s", branch)
+			log.Info("watching branch", "branch", branch)
+
 			manager := scheduler.NewManager(
 				ctx,
 				cfg.VcapApplication.ApplicationID,
 				branch,
-				capi,
+				apiURL(cfg.ExternalAddr),
+				executors[cfg.Executor],
+				executors,
 				git.StartWatcher,
+				tagWatcher(branch, cfg.TagBranch),
 				repoRegistry,
 				os.LookupEnv,
 				shaTracker,
 				transfer,
+				runHistory,
 				m,
+				tracer,
 				log,
 			)
 			sched := scheduler.New(manager)
 
-			successfulConfig := m.NewCounter("SuccesssfulConifig")
-			failConfig := m.NewCounter("FailedConifig")
+			successfulConfig := m.NewCounter("SuccesssfulConifig", metrics.Labels{"branch": branch})
+			failConfig := m.NewCounter("FailedConifig", metrics.Labels{"branch": branch})
+			configStatus := configTracker.Register(ctx, branch)
 			git.StartWatcher(
 				ctx,
 				cfg.RepoPath,
 				branch,
 				func(sha string) {
-					var ts []scheduler.MetaPlan
-					for _, plan := range fetchConfigFile(sha, cfg.ConfigPath, configRepo, failConfig, successfulConfig, log).Plans {
-						if len(plan.RepoPaths) == 0 {
-							continue
-						}
-
-						for _, t := range plan.Tasks {
-							if t.Command == "" {
-								log.Fatalf("invalid task: %+v", t)
-							}
-						}
+					plans, err := fetchConfigFiles(sha, cfg.ConfigPath, configRepo)
+					planFiles := make(map[string]string)
+					for _, plan := range plans.Plans {
+						planFiles[plan.Name] = plan.File
+					}
+					configStatus(sha, planFiles, err)
+					if err != nil {
+						// Keep running the last config that loaded rather
+						// than stopping every plan on the branch.
+						log.Error("invalid config file", "sha", sha, "branch", branch, "err", err)
+						failConfig(1)
+						return
+					}
+					successfulConfig(1)
 
+					var ts []scheduler.MetaPlan
+					for _, plan := range plans.Plans {
 						var doOnce bool
 						for _, repoPath := range plan.RepoPaths {
 							if repoPath.Repo == cfg.RepoPath {
@@ -156,15 +199,16 @@ func main() {
 							}
 						}
 
-						ts = append(ts, scheduler.MetaPlan{
+						ts = append(ts, scheduler.ExpandMatrix(scheduler.MetaPlan{
 							Plan:      plan,
 							DoOnce:    doOnce,
 							ConfigSHA: sha,
-						})
+						})...)
 					}
 					sched.SetPlans(ts)
 				},
 				time.Minute,
+				0,
 				configRepo,
 				shaTracker,
 				log,
@@ -175,7 +219,7 @@ func main() {
 	branchManager := scheduler.NewBranchManager(startBranch)
 	branchSched := scheduler.NewBranchScheduler(branchManager)
 
-	git.StartBranchWatcher(
+	branchWatcher := git.StartBranchWatcher(
 		context.Background(),
 		configRepo,
 		func(branches []string) {
@@ -186,28 +230,216 @@ func main() {
 		log,
 	)
 
-	repoHandler := handlers.NewRepos(shaTracker, log)
-	http.Handle("/v1/repos", repoHandler)
-	http.Handle("/v1/transfer/", transfer)
+	auth := handlers.NewAuth(newAuthenticator(cfg, log), log)
+	read := func(h http.Handler) http.Handler {
+		return auth.Protect(h, handlers.ScopeRead, handlers.ScopeAdmin)
+	}
+
+	// Transfers are authorized by their own tokens, which tasks are handed,
+	// and health checks are left open for the platform.
+	mux := http.NewServeMux()
+	mux.Handle("/v1/repos", read(handlers.NewRepos(shaTracker, log)))
+	mux.Handle("/v1/configs", read(handlers.NewConfigs(configTracker, log)))
+	mux.Handle("/v1/transfer/", transfer)
+	runsHandler := read(handlers.NewRuns(runHistory, store, log))
+	mux.Handle("/v1/runs", runsHandler)
+	mux.Handle("/v1/runs/", runsHandler)
+	cachesHandler := read(handlers.NewCaches(caches, log))
+	mux.Handle("/v1/caches", cachesHandler)
+	mux.Handle("/v1/caches/", cachesHandler)
+	mux.Handle("/metrics", read(m))
+	mux.Handle("/debug/vars", read(expvar.Handler()))
+	mux.Handle("/ui/", read(dashboard.New(branchWatcher, configTracker, shaTracker, runHistory, store, cfg.DashboardLogsURL, log)))
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	}))
+	health := handlers.NewHealth(repoRegistry, branchWatcher, tokens, cfg.HealthMaxFetchAge, cfg.HealthMaxBranchAge, log)
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+
+	fatal(log, "failed to serve", "err", http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), mux))
+}
+
+// newCAPIClient returns a CAPI client along with the HTTP client it uses, so
+// that how fetching tokens has gone can be reported.
+func newCAPIClient(cfg *Config, tracer *tracing.Tracer, log *slog.Logger) (*capi.Client, *capi.HTTPClient) {
+	uaaClient, err := uaago.NewClient(cfg.UAAAddr)
+	if err != nil {
+		fatal(log, "invalid configuration", "err", err)
+	}
+
+	httpClient := capi.NewHTTPClient(
+		&http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				TLSHandshakeTimeout: 10 * time.Second,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: cfg.SkipSSLValidation,
+				},
+			},
+		},
+		capi.TokenFetcherFunc(func() (string, error) {
+			rt, at, err := uaaClient.GetRefreshToken(cfg.ClientID, cfg.RefreshToken, cfg.SkipSSLValidation)
+			if err != nil {
+				return "", err
+			}
+			cfg.RefreshToken = rt
+			return at, nil
+		}),
+	)
+
+	return capi.NewClient(
+		cfg.VcapApplication.CAPIAddr,
+		time.Second,
+		httpClient,
+		tracer,
+		log,
+	), httpClient
+}
+
+// newAuthenticator returns what authenticates API requests, or nil (which
+// leaves the API open) if neither static tokens nor a JWKS are configured.
+func newAuthenticator(cfg Config, log *slog.Logger) handlers.Authenticator {
+	var a handlers.Authenticators
+	if len(cfg.APITokens) > 0 {
+		a = append(a, handlers.StaticTokens(cfg.APITokens))
+	}
+
+	if cfg.AuthJWKSURL != "" {
+		a = append(a, handlers.NewJWTAuthenticator(handlers.JWTConfig{
+			JWKSURL:     cfg.AuthJWKSURL,
+			Issuer:      cfg.AuthJWTIssuer,
+			Audience:    cfg.AuthJWTAudience,
+			ScopePrefix: cfg.AuthScopePrefix,
+			KeysMaxAge:  time.Hour,
+		}, &http.Client{
+			Timeout: 5 * time.Second,
+			Transport: &http.Transport{
+				TLSHandshakeTimeout: 10 * time.Second,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: cfg.SkipSSLValidation,
+				},
+			},
+		}, log))
+	}
+
+	if len(a) == 0 {
+		log.Warn("API authentication is disabled; set API_TOKENS or AUTH_JWKS_URL")
+		return nil
+	}
+
+	return a
+}
+
+// newTracer returns a Tracer that exports spans to the OTLP endpoint, or
+// nil (which traces nothing) if there isn't one.
+func newTracer(endpoint, service string, log *slog.Logger) *tracing.Tracer {
+	if endpoint == "" {
+		return nil
+	}
+
+	return tracing.New(
+		service,
+		tracing.NewOTLPExporter(endpoint, &http.Client{Timeout: 10 * time.Second}),
+		5*time.Second,
+		log,
+	)
+}
+
+// fatal logs the message as an error and exits.
+func fatal(log *slog.Logger, msg string, args ...any) {
+	log.Error(msg, args...)
+	os.Exit(1)
+}
+
+// tagWatcher returns the tag watcher for the config branch. It is nil
+// unless the branch is the tag branch, which may be given with or without
+// the remotes/origin/ prefix branches are listed with.
+func tagWatcher(branch, tagBranch string) scheduler.TagWatcher {
+	if strings.TrimPrefix(branch, "remotes/origin/") != strings.TrimPrefix(tagBranch, "remotes/origin/") {
+		return nil
+	}
+	return git.StartTagWatcher
+}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), nil))
+// apiURL returns the URL tasks use to reach the triple-c API at addr.
+func apiURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	return "http://" + addr
 }
 
-func fetchConfigFile(SHA, filePath string, repo git.Repo, fail, succ func(uint64), log *log.Logger) scheduler.Plans {
-	data, err := repo.File(SHA, filePath)
+// fetchConfigFiles loads every config file in the repo at the SHA that
+// matches the config path.
+func fetchConfigFiles(SHA, configPath string, repo git.Repo) (scheduler.Plans, error) {
+	files, err := repo.ListFiles(SHA)
+	if err != nil {
+		return scheduler.Plans{}, fmt.Errorf("failed to list files: %s", err)
+	}
+
+	matches := scheduler.MatchFiles(files, configPath)
+	if len(matches) == 0 {
+		return scheduler.Plans{}, fmt.Errorf("no config files match %s", configPath)
+	}
+
+	t, err := scheduler.LoadPlans(func(filePath string) (string, error) {
+		return repo.File(SHA, filePath)
+	}, matches...)
 	if err != nil {
-		log.Printf("failed to find config file (%s): %s", SHA, err)
-		fail(1)
-		return scheduler.Plans{}
+		return scheduler.Plans{}, err
 	}
 
-	var t scheduler.Plans
-	if err := yaml.Unmarshal([]byte(data), &t); err != nil {
-		log.Printf("failed to find config file (%s): %s", SHA, err)
-		fail(1)
-		return scheduler.Plans{}
+	if err := scheduler.Validate(t); err != nil {
+		return scheduler.Plans{}, err
 	}
 
-	succ(1)
-	return t
+	return t, nil
+}
+
+// artifactsBackend is what both the transfer and runs handlers need from an
+// artifact store.
+type artifactsBackend interface {
+	Put(runID, name string, r io.Reader) (artifacts.Artifact, error)
+	Open(runID, name string) (io.ReadCloser, artifacts.Artifact, error)
+	List(runID string) []artifacts.Artifact
+}
+
+func artifactStore(cfg Config, log *slog.Logger) artifactsBackend {
+	if cfg.ArtifactBackend == "s3" {
+		store, err := artifacts.NewS3Store(artifacts.S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Bucket:          cfg.S3Bucket,
+			Region:          cfg.S3Region,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			PathStyle:       cfg.S3PathStyle,
+			URLExpiry:       cfg.S3URLExpiry,
+		}, http.DefaultClient, log)
+		if err != nil {
+			fatal(log, "failed to configure S3 artifact store", "err", err)
+		}
+		return store
+	}
+
+	store, err := artifacts.NewStore(cfg.ArtifactDir, artifacts.RetentionPolicy{
+		MaxRuns:  cfg.ArtifactMaxRuns,
+		MaxAge:   cfg.ArtifactMaxAge,
+		MaxBytes: cfg.ArtifactMaxBytes,
+	}, log)
+	if err != nil {
+		fatal(log, "failed to open artifact store", "dir", cfg.ArtifactDir, "err", err)
+	}
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			store.Prune()
+		}
+	}()
+
+	return store
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+)
+
+func TestTagWatcher(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it only watches tags on the tag branch", func(t *testing.T) {
+		Expect(t, tagWatcher("remotes/origin/main", "main") == nil).To(BeFalse())
+		Expect(t, tagWatcher("remotes/origin/main", "remotes/origin/main") == nil).To(BeFalse())
+		Expect(t, tagWatcher("remotes/origin/feature", "main") == nil).To(BeTrue())
+		Expect(t, tagWatcher("remotes/origin/main-2", "main") == nil).To(BeTrue())
+	})
+}
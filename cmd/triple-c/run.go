@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/handlers"
+	"github.com/poy/triple-c/internal/local"
+	"github.com/poy/triple-c/internal/metrics"
+	"github.com/poy/triple-c/internal/scheduler"
+	"gopkg.in/yaml.v2"
+)
+
+// run loads a plans file from disk and runs its plans on the local machine.
+// It is meant for iterating on a pipeline without pushing it to the config
+// repo. It returns an error rather than exiting so that its deferred cleanup
+// of the data dir runs on failure too.
+func run(args []string, log *slog.Logger) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	planName := flags.String("plan", "", "only run the plan with the given name")
+	paramsPath := flags.String("params", "", "YAML file of parameters used to resolve ((KEY)) values before the environment")
+	branch := flags.String("branch", "remotes/origin/master", "branch to check out and to compare branch guards against")
+	sha := flags.String("sha", "local", "SHA reported to the tasks")
+	dockerImage := flags.String("docker-image", "", "run each task in a container created from the given image")
+	concurrency := flags.Int("concurrency", 1, "maximum number of tasks to run at once")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: triple-c run [flags] <plans file>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	plans, err := loadPlansFiles(flags.Args()...)
+	if err != nil {
+		return fmt.Errorf("invalid plans file: %w", err)
+	}
+
+	params := map[string]string{}
+	if *paramsPath != "" {
+		data, err := ioutil.ReadFile(*paramsPath)
+		if err != nil {
+			return fmt.Errorf("failed to read params file: %w", err)
+		}
+
+		if err := yaml.Unmarshal(data, &params); err != nil {
+			return fmt.Errorf("failed to parse params file: %w", err)
+		}
+	}
+
+	dataDir, err := ioutil.TempDir("", "triple-c")
+	if err != nil {
+		return fmt.Errorf("failed to create data dir: %w", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen for transfers: %w", err)
+	}
+	defer lis.Close()
+
+	store, err := artifacts.NewStore(path.Join(dataDir, "artifacts"), artifacts.RetentionPolicy{}, log)
+	if err != nil {
+		return fmt.Errorf("failed to create artifact store: %w", err)
+	}
+
+	caches, err := artifacts.NewStore(path.Join(dataDir, "caches"), artifacts.RetentionPolicy{}, log)
+	if err != nil {
+		return fmt.Errorf("failed to create cache store: %w", err)
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "triple-c"
+	}
+	tracer := newTracer(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), serviceName, log)
+
+	transfer := handlers.NewTransfer(lis.Addr().String(), store, caches, 5*time.Minute, 0, tracer, log)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/transfer/", transfer)
+	go http.Serve(lis, mux)
+
+	builder := local.Bash
+	if *dockerImage != "" {
+		builder = local.Docker(*dockerImage)
+	}
+
+	manager := scheduler.NewManager(
+		context.Background(),
+		"local",
+		*branch,
+		apiURL(lis.Addr().String()),
+		local.NewExecutor(dataDir, *concurrency, builder, log),
+		nil,
+		nil,
+		nil,
+		nil,
+		func(key string) (string, bool) {
+			if v, ok := params[key]; ok {
+				return v, true
+			}
+			return os.LookupEnv(key)
+		},
+		nil,
+		transfer,
+		metrics.NewRunHistory(100),
+		metrics.New(nil),
+		tracer,
+		log,
+	)
+
+	var found, failed bool
+	for _, plan := range plans.Plans {
+		if *planName != "" && plan.Name != *planName {
+			continue
+		}
+		found = true
+
+		// Everything runs locally, regardless of the executor the plan
+		// asks for on the server.
+		plan.Executor = ""
+
+		for _, mp := range scheduler.ExpandMatrix(scheduler.MetaPlan{
+			Plan:      plan,
+			DoOnce:    true,
+			ConfigSHA: "local",
+		}) {
+			log.Info("running plan", "plan", plan.Name, "matrix", mp.MatrixValues)
+			if !manager.Run(*sha, *branch, mp) {
+				log.Error("plan failed", "plan", plan.Name, "matrix", mp.MatrixValues)
+				failed = true
+				continue
+			}
+			log.Info("plan succeeded", "plan", plan.Name, "matrix", mp.MatrixValues)
+		}
+	}
+
+	// Spans are otherwise exported periodically, so the last of them would
+	// be lost when the process exits.
+	tracer.Flush()
+
+	if !found {
+		return errors.New("no plans to run")
+	}
+
+	if failed {
+		return errors.New("one or more plans failed")
+	}
+
+	return nil
+}
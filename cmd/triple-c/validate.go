@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/poy/triple-c/internal/scheduler"
+)
+
+// validate checks a plans file and reports every problem it finds.
+func validate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: triple-c validate <plans file>...")
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	plans, err := loadPlansFiles(flags.Args()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d plan(s) are valid\n", len(plans.Plans))
+}
+
+// loadPlansFiles loads and validates plans files, and the files they
+// include, from the local disk.
+func loadPlansFiles(filePaths ...string) (scheduler.Plans, error) {
+	plans, err := scheduler.LoadPlans(func(filePath string) (string, error) {
+		data, err := ioutil.ReadFile(filePath)
+		return string(data), err
+	}, filePaths...)
+	if err != nil {
+		return scheduler.Plans{}, err
+	}
+
+	if err := scheduler.Validate(plans); err != nil {
+		return scheduler.Plans{}, fmt.Errorf("\n%s", err)
+	}
+
+	return plans, nil
+}
@@ -0,0 +1,24 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// CachePrefix returns the prefix of the run IDs the caches of the plan are
+// stored under.
+func CachePrefix(plan string) string {
+	return shortHash(plan) + "-"
+}
+
+// CacheID returns the run ID a cache of the plan is stored under. parts
+// identify the cache within the plan (e.g. branch, task and directory).
+func CacheID(plan string, parts ...string) string {
+	return CachePrefix(plan) + shortHash(strings.Join(parts, "\x00"))
+}
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,305 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket in S3 or an S3 compatible service (e.g. MinIO).
+type S3Config struct {
+	// Endpoint is the base URL of the service (e.g. https://s3.amazonaws.com
+	// or http://localhost:9000).
+	Endpoint string
+	Bucket   string
+	Region   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PathStyle addresses the bucket as <endpoint>/<bucket> instead of
+	// <bucket>.<endpoint host>. Most S3 compatible services need it.
+	PathStyle bool
+
+	// URLExpiry is how long presigned URLs work for.
+	URLExpiry time.Duration
+}
+
+// Doer sends HTTP requests. *http.Client is a Doer.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// S3Store keeps the artifacts of each run in a bucket at <run ID>/<name>.
+// Tasks are handed presigned URLs so artifacts don't pass through triple-c.
+// Retention is left to the bucket's lifecycle rules.
+type S3Store struct {
+	cfg    S3Config
+	signer Signer
+	doer   Doer
+	log    *slog.Logger
+}
+
+// NewS3Store returns an S3Store for the configured bucket.
+func NewS3Store(cfg S3Config, doer Doer, log *slog.Logger) (*S3Store, error) {
+	if _, err := url.Parse(cfg.Endpoint); err != nil || cfg.Endpoint == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("an S3 bucket is required")
+	}
+
+	if cfg.URLExpiry <= 0 {
+		cfg.URLExpiry = 12 * time.Hour
+	}
+
+	return &S3Store{
+		cfg: cfg,
+		signer: Signer{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Region:          cfg.Region,
+		},
+		doer: doer,
+		log:  log,
+	}, nil
+}
+
+// PresignUpload returns a URL a task can PUT the artifact to.
+func (s *S3Store) PresignUpload(runID, name string) (string, error) {
+	if err := validateNames(runID, name); err != nil {
+		return "", err
+	}
+
+	return s.presign(http.MethodPut, s.objectURL(runID+"/"+name), nil)
+}
+
+// PresignDownload returns a URL a task can GET the artifact from.
+func (s *S3Store) PresignDownload(runID, name string) (string, error) {
+	if err := validateNames(runID, name); err != nil {
+		return "", err
+	}
+
+	return s.presign(http.MethodGet, s.objectURL(runID+"/"+name), nil)
+}
+
+// Put uploads the artifact for the run, replacing any with the same name.
+// The checksum is kept in the object's metadata.
+func (s *S3Store) Put(runID, name string, r io.Reader) (Artifact, error) {
+	if err := validateNames(runID, name); err != nil {
+		return Artifact{}, err
+	}
+
+	// The size and checksum have to be known before the upload starts.
+	f, err := ioutil.TempFile("", "triple-c-artifact")
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Artifact{}, err
+	}
+
+	a := Artifact{
+		RunID:   runID,
+		Name:    name,
+		Size:    size,
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		Created: time.Now(),
+	}
+
+	headers := http.Header{}
+	headers.Set("X-Amz-Meta-Sha256", a.SHA256)
+
+	u, err := s.presign(http.MethodPut, s.objectURL(runID+"/"+name), headers)
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u, f)
+	if err != nil {
+		return Artifact{}, err
+	}
+	req.ContentLength = size
+	req.Header = headers
+
+	resp, err := s.doer.Do(req)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return Artifact{}, fmt.Errorf("unexpected status code uploading %s/%s: %d", runID, name, resp.StatusCode)
+	}
+
+	return a, nil
+}
+
+// Open returns the contents of the artifact. The caller must close it.
+func (s *S3Store) Open(runID, name string) (io.ReadCloser, Artifact, error) {
+	if err := validateNames(runID, name); err != nil {
+		return nil, Artifact{}, ErrNotFound
+	}
+
+	u, err := s.presign(http.MethodGet, s.objectURL(runID+"/"+name), nil)
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+
+	resp, err := s.doer.Do(req)
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		drain(resp)
+		return nil, Artifact{}, ErrNotFound
+	default:
+		drain(resp)
+		return nil, Artifact{}, fmt.Errorf("unexpected status code downloading %s/%s: %d", runID, name, resp.StatusCode)
+	}
+
+	created, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp.Body, Artifact{
+		RunID:   runID,
+		Name:    name,
+		Size:    resp.ContentLength,
+		SHA256:  resp.Header.Get("X-Amz-Meta-Sha256"),
+		Created: created,
+	}, nil
+}
+
+// List returns the artifacts of the run, sorted by name. Listing does not
+// return checksums.
+func (s *S3Store) List(runID string) []Artifact {
+	if !validName.MatchString(runID) {
+		return nil
+	}
+
+	var results []Artifact
+	var token string
+	for {
+		page, err := s.list(runID+"/", token)
+		if err != nil {
+			s.log.Error("failed to list artifacts", "run_id", runID, "err", err)
+			return results
+		}
+
+		for _, c := range page.Contents {
+			results = append(results, Artifact{
+				RunID:   runID,
+				Name:    path.Base(c.Key),
+				Size:    c.Size,
+				Created: c.LastModified,
+			})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return results
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) list(prefix, token string) (listBucketResult, error) {
+	u, err := url.Parse(s.objectURL(""))
+	if err != nil {
+		return listBucketResult{}, err
+	}
+
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("prefix", prefix)
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	u.RawQuery = q.Encode()
+
+	signed, err := s.presign(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, signed, nil)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+
+	resp, err := s.doer.Do(req)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return listBucketResult{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return listBucketResult{}, err
+	}
+
+	return result, nil
+}
+
+func (s *S3Store) presign(method, u string, headers http.Header) (string, error) {
+	return s.signer.Presign(method, u, headers, time.Now(), s.cfg.URLExpiry)
+}
+
+// objectURL returns the URL of the key within the bucket.
+func (s *S3Store) objectURL(key string) string {
+	u, _ := url.Parse(s.cfg.Endpoint)
+	base := strings.TrimSuffix(u.Path, "/")
+
+	if s.cfg.PathStyle {
+		u.Path = fmt.Sprintf("%s/%s/%s", base, s.cfg.Bucket, key)
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = fmt.Sprintf("%s/%s", base, key)
+	}
+
+	return u.String()
+}
+
+func drain(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
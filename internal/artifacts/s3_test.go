@@ -0,0 +1,201 @@
+package artifacts_test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
+)
+
+type TS3 struct {
+	*testing.T
+	fake  *fakeS3
+	store *artifacts.S3Store
+}
+
+func TestS3Store(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TS3 {
+		fake := newFakeS3()
+		store, err := artifacts.NewS3Store(artifacts.S3Config{
+			Endpoint:        fake.server.URL,
+			Bucket:          "some-bucket",
+			Region:          "us-east-1",
+			AccessKeyID:     "some-id",
+			SecretAccessKey: "some-secret",
+			PathStyle:       true,
+		}, http.DefaultClient, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		Expect(t, err).To(BeNil())
+
+		return TS3{
+			T:     t,
+			fake:  fake,
+			store: store,
+		}
+	})
+
+	o.AfterEach(func(t TS3) {
+		t.fake.server.Close()
+	})
+
+	o.Spec("it uploads and downloads artifacts with their checksum", func(t TS3) {
+		a, err := t.store.Put("run-1", "output", strings.NewReader("some-data"))
+		Expect(t, err).To(BeNil())
+		Expect(t, a.SHA256).To(Equal("9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1"))
+		Expect(t, t.fake.objects).To(HaveKey("/some-bucket/run-1/output"))
+
+		r, got, err := t.store.Open("run-1", "output")
+		Expect(t, err).To(BeNil())
+		defer r.Close()
+
+		data, err := ioutil.ReadAll(r)
+		Expect(t, err).To(BeNil())
+		Expect(t, string(data)).To(Equal("some-data"))
+		Expect(t, got.Size).To(Equal(int64(9)))
+		Expect(t, got.SHA256).To(Equal(a.SHA256))
+	})
+
+	o.Spec("it returns ErrNotFound for unknown artifacts", func(t TS3) {
+		_, _, err := t.store.Open("run-1", "output")
+		Expect(t, err).To(Equal(artifacts.ErrNotFound))
+	})
+
+	o.Spec("it lists the artifacts of a run", func(t TS3) {
+		t.store.Put("run-1", "b", strings.NewReader("some-data"))
+		t.store.Put("run-1", "a", strings.NewReader("data"))
+		t.store.Put("run-2", "c", strings.NewReader("data"))
+
+		var names []string
+		for _, a := range t.store.List("run-1") {
+			names = append(names, a.Name)
+		}
+		Expect(t, names).To(Equal([]string{"a", "b"}))
+	})
+
+	o.Spec("it presigns URLs tasks can use directly", func(t TS3) {
+		up, err := t.store.PresignUpload("run-1", "output")
+		Expect(t, err).To(BeNil())
+		Expect(t, up).To(StartWith(t.fake.server.URL + "/some-bucket/run-1/output?"))
+
+		req, err := http.NewRequest("PUT", up, strings.NewReader("some-data"))
+		Expect(t, err).To(BeNil())
+		resp, err := http.DefaultClient.Do(req)
+		Expect(t, err).To(BeNil())
+		Expect(t, resp.StatusCode).To(Equal(http.StatusOK))
+
+		down, err := t.store.PresignDownload("run-1", "output")
+		Expect(t, err).To(BeNil())
+		resp, err = http.Get(down)
+		Expect(t, err).To(BeNil())
+		data, _ := ioutil.ReadAll(resp.Body)
+		Expect(t, string(data)).To(Equal("some-data"))
+
+		_, err = t.store.PresignUpload("run-1", "../output")
+		Expect(t, err).To(Not(BeNil()))
+	})
+}
+
+// fakeS3 is a stand-in for an S3 compatible service using path style
+// addressing. It requires requests to be presigned but does not check the
+// signature.
+type fakeS3 struct {
+	mu      sync.Mutex
+	server  *httptest.Server
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data     []byte
+	sha256   string
+	modified time.Time
+}
+
+func newFakeS3() *fakeS3 {
+	f := &fakeS3{
+		objects: make(map[string]fakeObject),
+	}
+	f.server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	if q.Get("X-Amz-Signature") == "" || q.Get("X-Amz-Expires") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = fakeObject{
+			data:     data,
+			sha256:   r.Header.Get("X-Amz-Meta-Sha256"),
+			modified: time.Now(),
+		}
+
+	case r.Method == http.MethodGet && q.Get("list-type") == "2":
+		bucket := strings.TrimSuffix(r.URL.Path, "/") + "/"
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []struct {
+				Key          string
+				Size         int
+				LastModified time.Time
+			}
+		}
+
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, bucket+q.Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			result.Contents = append(result.Contents, struct {
+				Key          string
+				Size         int
+				LastModified time.Time
+			}{
+				Key:          strings.TrimPrefix(k, bucket),
+				Size:         len(f.objects[k].data),
+				LastModified: f.objects[k].modified,
+			})
+		}
+
+		xml.NewEncoder(w).Encode(result)
+
+	case r.Method == http.MethodGet:
+		o, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("X-Amz-Meta-Sha256", o.sha256)
+		w.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+		w.Write(o.data)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
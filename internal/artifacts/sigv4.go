@@ -0,0 +1,135 @@
+package artifacts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer presigns S3 requests with AWS Signature Version 4 so they can be
+// made without credentials until they expire.
+type Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+}
+
+// Presign returns the URL with the signature added to its query. Any
+// headers given are signed too and must be sent with the request.
+func (s Signer) Presign(method, rawURL string, headers http.Header, t time.Time, expires time.Duration) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+
+	signed := map[string]string{"host": u.Host}
+	for k := range headers {
+		signed[strings.ToLower(k)] = strings.TrimSpace(headers.Get(k))
+	}
+
+	var names []string
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + signed[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.AccessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", fmt.Sprint(int64(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", signedHeaders)
+	query := canonicalQuery(q)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		uriEncode(u.Path, false),
+		query,
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	u.RawQuery = query + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func canonicalQuery(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := q[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes s as SigV4 expects: every byte other than the unreserved
+// characters is percent encoded, and '/' is kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,350 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when an artifact does not exist.
+var ErrNotFound = errors.New("artifact not found")
+
+const checksumSuffix = ".sha256"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Artifact is a named output of a run.
+type Artifact struct {
+	RunID   string
+	Name    string
+	Size    int64
+	SHA256  string
+	Created time.Time
+}
+
+// RetentionPolicy limits what a Store keeps. Whole runs are removed, oldest
+// first, until every limit is met. A zero value disables that limit.
+type RetentionPolicy struct {
+	// MaxRuns is how many runs' artifacts are kept.
+	MaxRuns int
+
+	// MaxAge is how long a run's artifacts are kept after they were last
+	// written.
+	MaxAge time.Duration
+
+	// MaxBytes is the total size of every artifact kept.
+	MaxBytes int64
+}
+
+// Store keeps the artifacts of each run on disk at <dir>/<run ID>/<name>,
+// with the checksum alongside.
+type Store struct {
+	dir    string
+	policy RetentionPolicy
+	log    *slog.Logger
+
+	mu    sync.Mutex
+	runs  map[string]map[string]Artifact
+	bytes int64
+}
+
+// NewStore returns a Store in dir, loading any artifacts already there.
+func NewStore(dir string, p RetentionPolicy, log *slog.Logger) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:    dir,
+		policy: p,
+		log:    log,
+		runs:   make(map[string]map[string]Artifact),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.Prune()
+
+	return s, nil
+}
+
+func (s *Store) load() error {
+	runDirs, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, runDir := range runDirs {
+		if !runDir.IsDir() || !validName.MatchString(runDir.Name()) {
+			continue
+		}
+		runID := runDir.Name()
+
+		files, err := ioutil.ReadDir(path.Join(s.dir, runID))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			name := f.Name()
+			if strings.HasPrefix(name, ".tmp-") {
+				os.Remove(path.Join(s.dir, runID, name))
+				continue
+			}
+
+			if !validArtifactName(name) {
+				continue
+			}
+
+			sum, err := ioutil.ReadFile(s.filePath(runID, name) + checksumSuffix)
+			if err != nil {
+				s.log.Warn("ignoring artifact without a checksum", "run_id", runID, "artifact", name, "err", err)
+				continue
+			}
+
+			s.add(Artifact{
+				RunID:   runID,
+				Name:    name,
+				Size:    f.Size(),
+				SHA256:  strings.TrimSpace(string(sum)),
+				Created: f.ModTime(),
+			})
+		}
+	}
+
+	return nil
+}
+
+// Put writes the artifact for the run, replacing any with the same name,
+// and then applies the retention policy. The run being written to is never
+// removed by its own Put.
+func (s *Store) Put(runID, name string, r io.Reader) (Artifact, error) {
+	if err := validateNames(runID, name); err != nil {
+		return Artifact{}, err
+	}
+
+	runDir := path.Join(s.dir, runID)
+	if err := os.MkdirAll(runDir, 0700); err != nil {
+		return Artifact{}, err
+	}
+
+	f, err := ioutil.TempFile(runDir, ".tmp-")
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	a := Artifact{
+		RunID:   runID,
+		Name:    name,
+		Size:    size,
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		Created: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ioutil.WriteFile(s.filePath(runID, name)+checksumSuffix, []byte(a.SHA256), 0600); err != nil {
+		return Artifact{}, err
+	}
+
+	if err := os.Rename(f.Name(), s.filePath(runID, name)); err != nil {
+		return Artifact{}, err
+	}
+
+	s.add(a)
+	s.prune(runID)
+
+	return a, nil
+}
+
+// Open returns the contents of the artifact. The caller must close it.
+func (s *Store) Open(runID, name string) (io.ReadCloser, Artifact, error) {
+	s.mu.Lock()
+	a, ok := s.runs[runID][name]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, Artifact{}, ErrNotFound
+	}
+
+	f, err := os.Open(s.filePath(runID, name))
+	if os.IsNotExist(err) {
+		return nil, Artifact{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Artifact{}, err
+	}
+
+	return f, a, nil
+}
+
+// List returns the artifacts of the run, sorted by name.
+func (s *Store) List(runID string) []Artifact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []Artifact
+	for _, a := range s.runs[runID] {
+		results = append(results, a)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+// Runs returns the ID of every run with artifacts, sorted.
+func (s *Store) Runs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id := range s.runs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Remove deletes every artifact of the run.
+func (s *Store) Remove(runID string) error {
+	if !validName.MatchString(runID) {
+		return fmt.Errorf("invalid run ID %q", runID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remove(runID)
+}
+
+// Size returns the total size of every artifact in the store.
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bytes
+}
+
+// Prune removes runs until the retention policy is met. Put prunes as it
+// goes, but expired runs are only noticed by calling Prune.
+func (s *Store) Prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune("")
+}
+
+func (s *Store) prune(keep string) {
+	type runAge struct {
+		id   string
+		last time.Time
+	}
+
+	var runs []runAge
+	for id, artifacts := range s.runs {
+		var last time.Time
+		for _, a := range artifacts {
+			if a.Created.After(last) {
+				last = a.Created
+			}
+		}
+		runs = append(runs, runAge{id: id, last: last})
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].last.Before(runs[j].last)
+	})
+
+	for _, r := range runs {
+		if r.id == keep {
+			continue
+		}
+
+		expired := s.policy.MaxAge > 0 && time.Since(r.last) > s.policy.MaxAge
+		tooMany := s.policy.MaxRuns > 0 && len(s.runs) > s.policy.MaxRuns
+		tooBig := s.policy.MaxBytes > 0 && s.bytes > s.policy.MaxBytes
+		if !expired && !tooMany && !tooBig {
+			continue
+		}
+
+		if err := s.remove(r.id); err != nil {
+			s.log.Error("failed to remove artifacts", "run_id", r.id, "err", err)
+		}
+	}
+}
+
+// remove deletes the run's artifacts. It must be called with the lock held.
+func (s *Store) remove(runID string) error {
+	s.log.Info("removing artifacts", "run_id", runID)
+	if err := os.RemoveAll(path.Join(s.dir, runID)); err != nil {
+		return err
+	}
+
+	for _, a := range s.runs[runID] {
+		s.bytes -= a.Size
+	}
+	delete(s.runs, runID)
+
+	return nil
+}
+
+// add records the artifact. It must be called with the lock held or before
+// the Store is shared.
+func (s *Store) add(a Artifact) {
+	artifacts, ok := s.runs[a.RunID]
+	if !ok {
+		artifacts = make(map[string]Artifact)
+		s.runs[a.RunID] = artifacts
+	}
+
+	s.bytes += a.Size - artifacts[a.Name].Size
+	artifacts[a.Name] = a
+}
+
+func (s *Store) filePath(runID, name string) string {
+	return path.Join(s.dir, runID, name)
+}
+
+func validArtifactName(name string) bool {
+	return validName.MatchString(name) && !strings.HasSuffix(name, checksumSuffix)
+}
+
+func validateNames(runID, name string) error {
+	if !validName.MatchString(runID) {
+		return fmt.Errorf("invalid run ID %q", runID)
+	}
+
+	if !validArtifactName(name) {
+		return fmt.Errorf("invalid artifact name %q", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package artifacts_test
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
+)
+
+type TS struct {
+	*testing.T
+	dir string
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TS {
+		dir, err := ioutil.TempDir("", "")
+		Expect(t, err).To(BeNil())
+
+		return TS{
+			T:   t,
+			dir: dir,
+		}
+	})
+
+	o.AfterEach(func(t TS) {
+		os.RemoveAll(t.dir)
+	})
+
+	o.Spec("it stores and reads back an artifact with its checksum", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{})
+
+		a, err := s.Put("run-1", "output", strings.NewReader("some-data"))
+		Expect(t, err).To(BeNil())
+		Expect(t, a.Size).To(Equal(int64(9)))
+		Expect(t, a.SHA256).To(Equal("9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1"))
+
+		r, got, err := s.Open("run-1", "output")
+		Expect(t, err).To(BeNil())
+		defer r.Close()
+
+		data, err := ioutil.ReadAll(r)
+		Expect(t, err).To(BeNil())
+		Expect(t, string(data)).To(Equal("some-data"))
+		Expect(t, got).To(Equal(a))
+
+		Expect(t, s.List("run-1")).To(Equal([]artifacts.Artifact{a}))
+		Expect(t, s.Size()).To(Equal(int64(9)))
+	})
+
+	o.Spec("it returns ErrNotFound for unknown artifacts", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{})
+
+		_, _, err := s.Open("run-1", "output")
+		Expect(t, err).To(Equal(artifacts.ErrNotFound))
+	})
+
+	o.Spec("it rejects names that could escape the run", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{})
+
+		_, err := s.Put("run-1", "../output", strings.NewReader("some-data"))
+		Expect(t, err).To(Not(BeNil()))
+
+		_, err = s.Put("..", "output", strings.NewReader("some-data"))
+		Expect(t, err).To(Not(BeNil()))
+	})
+
+	o.Spec("it accounts for replaced artifacts", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{})
+
+		s.Put("run-1", "output", strings.NewReader("some-data"))
+		s.Put("run-1", "output", strings.NewReader("data"))
+		Expect(t, s.Size()).To(Equal(int64(4)))
+	})
+
+	o.Spec("it loads the artifacts already on disk", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{})
+		a, err := s.Put("run-1", "output", strings.NewReader("some-data"))
+		Expect(t, err).To(BeNil())
+
+		s = newStore(t, artifacts.RetentionPolicy{})
+		list := s.List("run-1")
+		Expect(t, list).To(HaveLen(1))
+		Expect(t, list[0].SHA256).To(Equal(a.SHA256))
+		Expect(t, list[0].Size).To(Equal(a.Size))
+	})
+
+	o.Spec("it removes the oldest runs beyond MaxRuns", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{MaxRuns: 2})
+
+		s.Put("run-1", "output", strings.NewReader("some-data"))
+		s.Put("run-2", "output", strings.NewReader("some-data"))
+		s.Put("run-3", "output", strings.NewReader("some-data"))
+
+		Expect(t, s.List("run-1")).To(HaveLen(0))
+		Expect(t, s.List("run-2")).To(HaveLen(1))
+		Expect(t, s.List("run-3")).To(HaveLen(1))
+
+		_, err := os.Stat(path.Join(t.dir, "run-1"))
+		Expect(t, os.IsNotExist(err)).To(BeTrue())
+	})
+
+	o.Spec("it removes runs beyond MaxBytes but not the one being written", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{MaxBytes: 10})
+
+		s.Put("run-1", "output", strings.NewReader("some-data"))
+		s.Put("run-2", "output", strings.NewReader("some-more-data"))
+
+		Expect(t, s.List("run-1")).To(HaveLen(0))
+		Expect(t, s.List("run-2")).To(HaveLen(1))
+	})
+
+	o.Spec("it removes expired runs when pruned", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{MaxAge: time.Millisecond})
+
+		s.Put("run-1", "output", strings.NewReader("some-data"))
+		time.Sleep(10 * time.Millisecond)
+		s.Prune()
+
+		Expect(t, s.List("run-1")).To(HaveLen(0))
+		Expect(t, s.Size()).To(Equal(int64(0)))
+	})
+
+	o.Spec("it lists and removes runs", func(t TS) {
+		s := newStore(t, artifacts.RetentionPolicy{})
+
+		s.Put("run-2", "output", strings.NewReader("some-data"))
+		s.Put("run-1", "output", strings.NewReader("some-data"))
+		Expect(t, s.Runs()).To(Equal([]string{"run-1", "run-2"}))
+
+		Expect(t, s.Remove("run-1")).To(BeNil())
+		Expect(t, s.Runs()).To(Equal([]string{"run-2"}))
+		Expect(t, s.Size()).To(Equal(int64(9)))
+
+		_, err := os.Stat(path.Join(t.dir, "run-1"))
+		Expect(t, os.IsNotExist(err)).To(BeTrue())
+
+		Expect(t, s.Remove("../run-2")).To(Not(BeNil()))
+	})
+}
+
+func TestCacheID(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it prefixes the IDs of a plan's caches", func(t *testing.T) {
+		id := artifacts.CacheID("some-plan", "some-branch", "some-dir")
+		Expect(t, id).To(StartWith(artifacts.CachePrefix("some-plan")))
+		Expect(t, id).To(Not(Equal(artifacts.CacheID("some-plan", "other-branch", "some-dir"))))
+		Expect(t, id).To(Not(StartWith(artifacts.CachePrefix("other-plan"))))
+		Expect(t, id).To(MatchRegexp(`^[0-9a-f]{16}-[0-9a-f]{16}$`))
+	})
+}
+
+func newStore(t TS, p artifacts.RetentionPolicy) *artifacts.Store {
+	s, err := artifacts.NewStore(t.dir, p, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+	Expect(t, err).To(BeNil())
+	return s
+}
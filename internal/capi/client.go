@@ -2,39 +2,55 @@ package capi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 type Client struct {
 	addr     string
 	doer     Doer
 	interval time.Duration
+	tracer   *tracing.Tracer
+	log      *slog.Logger
 }
 
 type Doer interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func NewClient(addr string, interval time.Duration, d Doer) *Client {
+func NewClient(addr string, interval time.Duration, d Doer, tracer *tracing.Tracer, log *slog.Logger) *Client {
 	return &Client{
 		doer:     d,
 		addr:     addr,
 		interval: interval,
+		tracer:   tracer,
+		log:      log,
 	}
 }
 
+// CreateTask creates the task and polls it until it is no longer running.
+// Creating and polling the task are each a child span of the one in the
+// context.
 func (c *Client) CreateTask(
+	ctx context.Context,
 	command string,
 	name string,
 	appGuid string,
 ) error {
+	_, span := c.tracer.Start(ctx, "capi.create_task")
+	span.SetAttributes("app_guid", appGuid)
+	defer span.End()
+
 	u, err := url.Parse(c.addr)
 	if err != nil {
 		return err
@@ -63,6 +79,7 @@ func (c *Client) CreateTask(
 
 	resp, err := c.doer.Do(req)
 	if err != nil {
+		span.RecordError(err)
 		return err
 	}
 
@@ -74,9 +91,21 @@ func (c *Client) CreateTask(
 
 	if resp.StatusCode != 202 {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+		c.log.Error("failed to create task", "app_guid", appGuid, "status", resp.StatusCode)
+		err := fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, data)
+		span.RecordError(err)
+		return err
 	}
+	span.End()
 
+	_, span = c.tracer.Start(ctx, "capi.poll_task")
+	span.SetAttributes("app_guid", appGuid)
+	defer span.End()
+
+	var polls int
+	defer func() { span.SetAttributes("polls", polls) }()
+
+	log := c.log.With("app_guid", appGuid)
 	for {
 		var results struct {
 			State string `json:"state"`
@@ -87,9 +116,13 @@ func (c *Client) CreateTask(
 			} `json:"links"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			span.RecordError(err)
 			return err
 		}
 		resp.Body.Close()
+		polls++
+		taskLog := log.With("task", results.Links.Self.Href)
+		taskLog.Debug("polled task", "state", results.State)
 
 		switch results.State {
 		case "RUNNING":
@@ -97,6 +130,7 @@ func (c *Client) CreateTask(
 
 			u, err := url.Parse(results.Links.Self.Href)
 			if err != nil {
+				span.RecordError(err)
 				return err
 			}
 
@@ -108,6 +142,7 @@ func (c *Client) CreateTask(
 
 			resp, err = c.doer.Do(req)
 			if err != nil {
+				span.RecordError(err)
 				return err
 			}
 
@@ -119,7 +154,10 @@ func (c *Client) CreateTask(
 
 			continue
 		case "FAILED":
-			return errors.New("task failed")
+			taskLog.Warn("task failed")
+			err := errors.New("task failed")
+			span.RecordError(err)
+			return err
 		default:
 			return nil
 		}
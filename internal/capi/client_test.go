@@ -2,9 +2,11 @@ package capi_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"strings"
 	"testing"
@@ -14,6 +16,7 @@ import (
 	. "github.com/poy/onpar/expect"
 	. "github.com/poy/onpar/matchers"
 	"github.com/poy/triple-c/internal/capi"
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 type TC struct {
@@ -32,12 +35,12 @@ func TestClientCreateTask(t *testing.T) {
 		return TC{
 			T:       t,
 			spyDoer: spyDoer,
-			c:       capi.NewClient("http://some-addr.com", time.Millisecond, spyDoer),
+			c:       capi.NewClient("http://some-addr.com", time.Millisecond, spyDoer, nil, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
 		}
 	})
 
 	o.Spec("it hits CAPI correct", func(t TC) {
-		err := t.c.CreateTask("some-command", "some-name", "some-guid")
+		err := t.c.CreateTask(context.Background(), "some-command", "some-name", "some-guid")
 		Expect(t, err).To(BeNil())
 
 		Expect(t, t.spyDoer.req.Method).To(Equal("POST"))
@@ -56,7 +59,7 @@ func TestClientCreateTask(t *testing.T) {
 			StatusCode: 200,
 			Body:       ioutil.NopCloser(strings.NewReader(`{"links":{"self":{"href":"http://xx.succeeded"}},"state":"SUCCEEDED"}`)),
 		}
-		err := t.c.CreateTask("some-command", "some-name", "some-guid")
+		err := t.c.CreateTask(context.Background(), "some-command", "some-name", "some-guid")
 		Expect(t, err).To(BeNil())
 
 		t.spyDoer.m["POST:http://some-addr.com/v3/apps/some-other-guid/tasks"] = &http.Response{
@@ -68,28 +71,60 @@ func TestClientCreateTask(t *testing.T) {
 			StatusCode: 200,
 			Body:       ioutil.NopCloser(strings.NewReader(`{"guid":"task-guid","state":"FAILED"}`)),
 		}
-		err = t.c.CreateTask("some-command", "some-name", "some-other-guid")
+		err = t.c.CreateTask(context.Background(), "some-command", "some-name", "some-other-guid")
 		Expect(t, err).To(Not(BeNil()))
 	})
 
+	o.Spec("it traces creating and polling the task", func(t TC) {
+		t.spyDoer.m["POST:http://some-addr.com/v3/apps/some-guid/tasks"] = &http.Response{
+			StatusCode: 202,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"links":{"self":{"href":"http://xx.failed"}},"state":"RUNNING"}`)),
+		}
+
+		t.spyDoer.m["GET:http://xx.failed"] = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"state":"FAILED"}`)),
+		}
+
+		spyExporter := &spyExporter{}
+		tracer := tracing.New("some-service", spyExporter, 0, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		t.c = capi.NewClient("http://some-addr.com", time.Millisecond, t.spyDoer, tracer, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		ctx, parent := tracer.Start(context.Background(), "task")
+		err := t.c.CreateTask(ctx, "some-command", "some-name", "some-guid")
+		Expect(t, err).To(Not(BeNil()))
+		tracer.Flush()
+
+		Expect(t, spyExporter.spans).To(HaveLen(2))
+		create, poll := spyExporter.spans[0], spyExporter.spans[1]
+		Expect(t, create.Name).To(Equal("capi.create_task"))
+		Expect(t, create.TraceID).To(Equal(parent.TraceID()))
+		Expect(t, create.Err).To(Equal(""))
+		Expect(t, poll.Name).To(Equal("capi.poll_task"))
+		Expect(t, poll.TraceID).To(Equal(parent.TraceID()))
+		Expect(t, poll.ParentSpanID).To(Equal(create.ParentSpanID))
+		Expect(t, poll.Err).To(Equal("task failed"))
+		Expect(t, poll.Attributes).To(Contain(tracing.Attribute{Key: "polls", Value: 2}))
+	})
+
 	o.Spec("it returns an error if a non-202 is received", func(t TC) {
 		t.spyDoer.m["POST:http://some-addr.com/v3/apps/some-guid/tasks"] = &http.Response{
 			StatusCode: 500,
 			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
 		}
-		err := t.c.CreateTask("some-command", "some-name", "some-guid")
+		err := t.c.CreateTask(context.Background(), "some-command", "some-name", "some-guid")
 		Expect(t, err).To(Not(BeNil()))
 	})
 
 	o.Spec("it returns an error if the addr is invalid", func(t TC) {
-		t.c = capi.NewClient("::invalid", time.Millisecond, t.spyDoer)
-		err := t.c.CreateTask("some-command", "some-name", "some-guid")
+		t.c = capi.NewClient("::invalid", time.Millisecond, t.spyDoer, nil, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		err := t.c.CreateTask(context.Background(), "some-command", "some-name", "some-guid")
 		Expect(t, err).To(Not(BeNil()))
 	})
 
 	o.Spec("it returns an error if the request fails", func(t TC) {
 		t.spyDoer.err = errors.New("some-error")
-		err := t.c.CreateTask("some-command", "some-name", "some-guid")
+		err := t.c.CreateTask(context.Background(), "some-command", "some-name", "some-guid")
 		Expect(t, err).To(Not(BeNil()))
 	})
 }
@@ -136,7 +171,7 @@ func TestClientListTasks(t *testing.T) {
 		return TC{
 			T:       t,
 			spyDoer: spyDoer,
-			c:       capi.NewClient("http://some-addr.com", time.Millisecond, spyDoer),
+			c:       capi.NewClient("http://some-addr.com", time.Millisecond, spyDoer, nil, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
 		}
 	})
 
@@ -211,3 +246,12 @@ func (s *spyDoer) Do(req *http.Request) (*http.Response, error) {
 
 	return r, s.err
 }
+
+type spyExporter struct {
+	spans []tracing.SpanData
+}
+
+func (s *spyExporter) Export(ctx context.Context, service string, spans []tracing.SpanData) error {
+	s.spans = append(s.spans, spans...)
+	return nil
+}
@@ -2,6 +2,8 @@ package capi
 
 import (
 	"net/http"
+	"sync"
+	"time"
 )
 
 type HTTPClient struct {
@@ -9,6 +11,9 @@ type HTTPClient struct {
 
 	tokenFetcher TokenFetcher
 	lastToken    string
+
+	mu     sync.Mutex
+	status TokenStatus
 }
 
 type TokenFetcher interface {
@@ -21,6 +26,19 @@ func (f TokenFetcherFunc) GetToken() (string, error) {
 	return f()
 }
 
+// TokenStatus is how fetching tokens has gone.
+type TokenStatus struct {
+	// LastFetch is when a token was last fetched. It is zero if one never
+	// has been.
+	LastFetch time.Time
+
+	// LastSuccess is when a token was last fetched successfully.
+	LastSuccess time.Time
+
+	// Err is why the last fetch failed. It is nil if it succeeded.
+	Err error
+}
+
 func NewHTTPClient(doer Doer, f TokenFetcher) *HTTPClient {
 	return &HTTPClient{
 		doer:         doer,
@@ -53,12 +71,21 @@ func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
+// TokenStatus returns how fetching tokens has gone. Tokens are only fetched
+// when there isn't one or it was rejected, so LastSuccess may be old.
+func (c *HTTPClient) TokenStatus() TokenStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
 func (c *HTTPClient) fetchToken() (string, error) {
 	if c.lastToken != "" {
 		return c.lastToken, nil
 	}
 
 	token, err := c.tokenFetcher.GetToken()
+	c.recordFetch(err)
 	if err != nil {
 		return "", err
 	}
@@ -66,3 +93,15 @@ func (c *HTTPClient) fetchToken() (string, error) {
 	c.lastToken = token
 	return token, nil
 }
+
+func (c *HTTPClient) recordFetch(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.status.LastFetch = now
+	c.status.Err = err
+	if err == nil {
+		c.status.LastSuccess = now
+	}
+}
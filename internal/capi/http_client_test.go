@@ -60,6 +60,23 @@ func TestHTTPClient(t *testing.T) {
 		Expect(t, err).To(Not(BeNil()))
 	})
 
+	o.Spec("it reports how fetching tokens has gone", func(t TH) {
+		Expect(t, t.c.TokenStatus().LastFetch.IsZero()).To(BeTrue())
+
+		t.stubTokenFetcher.err = errors.New("some-error")
+		t.c.Do(t.req)
+		status := t.c.TokenStatus()
+		Expect(t, status.Err).To(Equal(t.stubTokenFetcher.err))
+		Expect(t, status.LastFetch.IsZero()).To(BeFalse())
+		Expect(t, status.LastSuccess.IsZero()).To(BeTrue())
+
+		t.stubTokenFetcher.err = nil
+		t.c.Do(t.req)
+		status = t.c.TokenStatus()
+		Expect(t, status.Err).To(BeNil())
+		Expect(t, status.LastSuccess).To(Equal(status.LastFetch))
+	})
+
 	o.Spec("it fetches a new token and tries again (once) for a non-200 status code", func(t TH) {
 		t.spyDoer.m["GET:http://some.url"] = &http.Response{
 			StatusCode: 403,
@@ -0,0 +1,375 @@
+package dashboard
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+// Dashboard serves a read-only web UI, under /ui/, of the branches triple-c
+// is watching, the plans on each and their recent runs.
+type Dashboard struct {
+	branches  BranchLister
+	configs   ConfigLister
+	repos     RepoLister
+	runs      RunLister
+	artifacts ArtifactLister
+	logsURL   string
+	log       *slog.Logger
+}
+
+// BranchLister lists the branches of the config repo.
+type BranchLister interface {
+	Branches() []string
+}
+
+// ConfigLister reports the config (and so the plans) of each branch.
+type ConfigLister interface {
+	ConfigInfo() []metrics.ConfigInfo
+}
+
+// RepoLister reports the SHA of each repo being watched.
+type RepoLister interface {
+	RepoInfo() []metrics.RepoInfo
+}
+
+// RunLister reads the run history.
+type RunLister interface {
+	Runs() []metrics.Run
+	Run(id string) (metrics.Run, bool)
+}
+
+// ArtifactLister lists the artifacts of a run.
+type ArtifactLister interface {
+	List(runID string) []artifacts.Artifact
+}
+
+// maxRuns is how many runs the runs page lists.
+const maxRuns = 200
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var pages = map[string]*template.Template{
+	"index": parsePage("index"),
+	"runs":  parsePage("runs"),
+	"run":   parsePage("run"),
+}
+
+func parsePage(name string) *template.Template {
+	return template.Must(template.New("layout.html").Funcs(template.FuncMap{
+		"short": shortSHA,
+		"ago":   ago,
+	}).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html"))
+}
+
+// New returns a Dashboard. logsURL links each run and task to its logs
+// (e.g., in a log search UI); {run_id} and {task} are replaced with the run
+// ID and task name. Runs aren't linked to logs if it's empty.
+func New(
+	branches BranchLister,
+	configs ConfigLister,
+	repos RepoLister,
+	runs RunLister,
+	artifacts ArtifactLister,
+	logsURL string,
+	log *slog.Logger,
+) http.Handler {
+	return &Dashboard{
+		branches:  branches,
+		configs:   configs,
+		repos:     repos,
+		runs:      runs,
+		artifacts: artifacts,
+		logsURL:   logsURL,
+		log:       log,
+	}
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/ui/":
+		d.render(w, "index", d.index())
+	case path == "/ui/runs":
+		q := r.URL.Query()
+		d.render(w, "runs", d.listRuns(q.Get("branch"), q.Get("plan"), q.Get("status")))
+	case strings.HasPrefix(path, "/ui/runs/") && !strings.Contains(path[len("/ui/runs/"):], "/"):
+		run, ok := d.runs.Run(path[len("/ui/runs/"):])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		d.render(w, "run", d.toRunView(run, true))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (d *Dashboard) render(w http.ResponseWriter, page string, data interface{}) {
+	var buf bytes.Buffer
+	if err := pages[page].Execute(&buf, data); err != nil {
+		d.log.Error("failed to render page", "page", page, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+type branchView struct {
+	Name string
+
+	// ConfigSHA is the config the branch is running and ConfigError is why
+	// a newer one failed to load.
+	ConfigSHA   string
+	ConfigError string
+
+	Repos []metrics.RepoInfo
+	Plans []planView
+}
+
+type planView struct {
+	Name    string
+	File    string
+	LastRun *runView
+}
+
+type runView struct {
+	ID        string
+	Plan      string
+	Matrix    string
+	Branch    string
+	Repo      string
+	SHA       string
+	Tag       string
+	ConfigSHA string
+	Status    string
+	Reason    string
+	Started   time.Time
+	Duration  string
+	LogsURL   string
+	Tasks     []taskView
+	Artifacts []artifactView
+}
+
+type taskView struct {
+	Name     string
+	Status   string
+	Duration string
+	LogsURL  string
+}
+
+type artifactView struct {
+	Name string
+	Size string
+	URL  string
+}
+
+func (d *Dashboard) index() []branchView {
+	configs := make(map[string]metrics.ConfigInfo)
+	for _, c := range d.configs.ConfigInfo() {
+		configs[c.Branch] = c
+	}
+
+	names := make(map[string]bool)
+	for _, b := range d.branches.Branches() {
+		names[b] = true
+	}
+	for b := range configs {
+		names[b] = true
+	}
+
+	repos := make(map[string][]metrics.RepoInfo)
+	for _, info := range d.repos.RepoInfo() {
+		repos[info.Branch] = append(repos[info.Branch], info)
+	}
+
+	// Runs are newest first, so the first run of each plan is its last.
+	lastRuns := make(map[[2]string]metrics.Run)
+	for _, run := range d.runs.Runs() {
+		key := [2]string{run.Branch, run.Plan}
+		if _, ok := lastRuns[key]; !ok {
+			lastRuns[key] = run
+		}
+	}
+
+	var results []branchView
+	for name := range names {
+		c := configs[name]
+		b := branchView{
+			Name:        name,
+			ConfigSHA:   c.LastGoodSHA,
+			ConfigError: c.Error,
+			Repos:       repos[name],
+		}
+
+		sort.Slice(b.Repos, func(i, j int) bool {
+			return b.Repos[i].Repo < b.Repos[j].Repo
+		})
+
+		for plan, file := range c.Plans {
+			p := planView{Name: plan, File: file}
+			if run, ok := lastRuns[[2]string{name, plan}]; ok {
+				v := d.toRunView(run, false)
+				p.LastRun = &v
+			}
+			b.Plans = append(b.Plans, p)
+		}
+
+		sort.Slice(b.Plans, func(i, j int) bool {
+			return b.Plans[i].Name < b.Plans[j].Name
+		})
+
+		results = append(results, b)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results
+}
+
+type runsView struct {
+	Branch string
+	Plan   string
+	Status string
+	Runs   []runView
+
+	// Statuses are what Status may be.
+	Statuses []string
+}
+
+func (d *Dashboard) listRuns(branch, plan, status string) runsView {
+	results := runsView{
+		Branch:   branch,
+		Plan:     plan,
+		Status:   status,
+		Statuses: []string{metrics.RunRunning, metrics.RunSucceeded, metrics.RunFailed, metrics.RunSkipped},
+	}
+
+	for _, run := range d.runs.Runs() {
+		if (branch != "" && run.Branch != branch) ||
+			(plan != "" && run.Plan != plan) ||
+			(status != "" && run.Status != status) {
+			continue
+		}
+
+		results.Runs = append(results.Runs, d.toRunView(run, false))
+		if len(results.Runs) == maxRuns {
+			break
+		}
+	}
+
+	return results
+}
+
+// toRunView describes a run. Its artifacts are only listed if withArtifacts
+// is set, as listing them may need to reach the artifact store.
+func (d *Dashboard) toRunView(r metrics.Run, withArtifacts bool) runView {
+	v := runView{
+		ID:        r.ID,
+		Plan:      r.Plan,
+		Matrix:    r.Matrix,
+		Branch:    r.Branch,
+		Repo:      r.Repo,
+		SHA:       r.SHA,
+		Tag:       r.Tag,
+		ConfigSHA: r.ConfigSHA,
+		Status:    r.Status,
+		Reason:    r.Reason,
+		Started:   r.Started,
+		Duration:  duration(r.Started, r.Finished),
+		LogsURL:   d.logsLink(r.ID, ""),
+	}
+
+	for _, t := range r.Tasks {
+		v.Tasks = append(v.Tasks, taskView{
+			Name:     t.Name,
+			Status:   t.Status,
+			Duration: duration(t.Started, t.Finished),
+			LogsURL:  d.logsLink(r.ID, t.Name),
+		})
+	}
+
+	if withArtifacts {
+		for _, a := range d.artifacts.List(r.ID) {
+			v.Artifacts = append(v.Artifacts, artifactView{
+				Name: a.Name,
+				Size: size(a.Size),
+				URL:  fmt.Sprintf("/v1/runs/%s/artifacts/%s", url.PathEscape(r.ID), url.PathEscape(a.Name)),
+			})
+		}
+	}
+
+	return v
+}
+
+func (d *Dashboard) logsLink(runID, task string) string {
+	if d.logsURL == "" {
+		return ""
+	}
+
+	return strings.NewReplacer(
+		"{run_id}", url.QueryEscape(runID),
+		"{task}", url.QueryEscape(task),
+	).Replace(d.logsURL)
+}
+
+// duration returns how long something that started at start took. It is
+// still going if finished is zero.
+func duration(start, finished time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+
+	if finished.IsZero() {
+		return time.Since(start).Round(time.Second).String() + "…"
+	}
+
+	return finished.Sub(start).Round(time.Second).String()
+}
+
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+func size(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
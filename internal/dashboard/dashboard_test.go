@@ -0,0 +1,210 @@
+package dashboard_test
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/dashboard"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type TD struct {
+	*testing.T
+	d        http.Handler
+	recorder *httptest.ResponseRecorder
+	spy      *spySources
+}
+
+func TestDashboard(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TD {
+		started := time.Now().Add(-time.Minute)
+		spy := &spySources{
+			branches: []string{"some-branch", "some-other-branch"},
+			configs: []metrics.ConfigInfo{
+				{
+					Branch:      "some-branch",
+					SHA:         "bad-config-sha",
+					LastGoodSHA: "0123456789abcdef",
+					Error:       "some-config-error",
+					Plans:       map[string]string{"some-plan": "pipelines/a.yml", "unrun-plan": "pipelines/b.yml"},
+				},
+			},
+			repos: []metrics.RepoInfo{
+				{Repo: "https://some.host/some-repo", Branch: "some-branch", SHA: "fedcba9876543210"},
+			},
+			runs: []metrics.Run{
+				{
+					ID:       "newer-run",
+					Plan:     "some-plan",
+					Branch:   "some-branch",
+					Repo:     "https://some.host/some-repo",
+					SHA:      "fedcba9876543210",
+					Status:   metrics.RunFailed,
+					Reason:   "some-reason",
+					Started:  started,
+					Finished: started.Add(90 * time.Second),
+					Tasks: []metrics.TaskRun{
+						{Index: 0, Name: "some-task", Status: metrics.RunSucceeded, Started: started, Finished: started.Add(30 * time.Second)},
+						{Index: 1, Name: "other-task", Status: metrics.RunFailed, Started: started.Add(30 * time.Second), Finished: started.Add(90 * time.Second)},
+					},
+				},
+				{
+					ID:      "older-run",
+					Plan:    "some-plan",
+					Branch:  "some-branch",
+					Status:  metrics.RunSucceeded,
+					Started: started.Add(-time.Hour),
+				},
+			},
+			artifacts: map[string][]artifacts.Artifact{
+				"newer-run": {{Name: "some artifact", Size: 2048}},
+			},
+		}
+
+		return TD{
+			T:        t,
+			d:        dashboard.New(spy, spy, spy, spy, spy, "https://logs.some.url/?q=run_id:{run_id}+task:{task}", slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+			recorder: httptest.NewRecorder(),
+			spy:      spy,
+		}
+	})
+
+	get := func(t TD, path string) string {
+		req, err := http.NewRequest("GET", "http://some.url"+path, nil)
+		Expect(t, err).To(BeNil())
+		t.d.ServeHTTP(t.recorder, req)
+		return t.recorder.Body.String()
+	}
+
+	o.Spec("it returns a 405 for anything other than a GET", func(t TD) {
+		req, err := http.NewRequest("POST", "http://some.url/ui/", nil)
+		Expect(t, err).To(BeNil())
+		t.d.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	o.Spec("it returns a 404 for unknown pages and runs", func(t TD) {
+		get(t, "/ui/invalid")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+
+		t.recorder = httptest.NewRecorder()
+		get(t, "/ui/runs/unknown-run")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	o.Spec("it shows each branch with its plans and their last runs", func(t TD) {
+		body := get(t, "/ui/")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Header().Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(t, body).To(ContainSubstring(`<h2 id="some-branch">some-branch</h2>`))
+		Expect(t, body).To(ContainSubstring(`<h2 id="some-other-branch">some-other-branch</h2>`))
+		Expect(t, body).To(ContainSubstring("<code>01234567</code>"))
+		Expect(t, body).To(ContainSubstring("some-config-error"))
+		Expect(t, body).To(ContainSubstring("https://some.host/some-repo"))
+		Expect(t, body).To(ContainSubstring("pipelines/a.yml"))
+		Expect(t, body).To(ContainSubstring(`<a class="failed" href="/ui/runs/newer-run">failed</a>`))
+		Expect(t, body).To(Not(ContainSubstring("older-run")))
+		Expect(t, body).To(ContainSubstring("1m30s"))
+		Expect(t, body).To(ContainSubstring("not run yet"))
+	})
+
+	o.Spec("it lists runs, filtered by branch, plan and status", func(t TD) {
+		body := get(t, "/ui/runs")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, body).To(ContainSubstring("newer-run"))
+		Expect(t, body).To(ContainSubstring("older-run"))
+		Expect(t, body).To(ContainSubstring(`title="other-task: failed"`))
+
+		t.recorder = httptest.NewRecorder()
+		body = get(t, "/ui/runs?branch=some-branch&plan=some-plan&status=succeeded")
+		Expect(t, body).To(Not(ContainSubstring("newer-run")))
+		Expect(t, body).To(ContainSubstring("older-run"))
+		Expect(t, body).To(ContainSubstring("<option selected>succeeded</option>"))
+
+		t.recorder = httptest.NewRecorder()
+		body = get(t, "/ui/runs?branch=some-other-branch")
+		Expect(t, body).To(ContainSubstring("No runs."))
+	})
+
+	o.Spec("it shows a run with its tasks, artifacts and logs", func(t TD) {
+		body := get(t, "/ui/runs/newer-run")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, body).To(ContainSubstring("some-reason"))
+		Expect(t, body).To(ContainSubstring("some-task"))
+		Expect(t, body).To(ContainSubstring("30s"))
+		Expect(t, body).To(ContainSubstring("1m0s"))
+		Expect(t, body).To(ContainSubstring(`<a href="/v1/runs/newer-run/artifacts/some%20artifact">some artifact</a>`))
+		Expect(t, body).To(ContainSubstring("2.0 KiB"))
+		Expect(t, body).To(ContainSubstring(`href="https://logs.some.url/?q=run_id:newer-run&#43;task:other-task"`))
+	})
+
+	o.Spec("it shows how to find the logs without a logs URL", func(t TD) {
+		d := dashboard.New(t.spy, t.spy, t.spy, t.spy, t.spy, "", slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		req, err := http.NewRequest("GET", "http://some.url/ui/runs/newer-run", nil)
+		Expect(t, err).To(BeNil())
+		d.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Body.String()).To(ContainSubstring(`select(.run_id == "newer-run")`))
+		Expect(t, t.recorder.Body.String()).To(Not(ContainSubstring(">logs</a>")))
+	})
+
+	o.Spec("it escapes what it shows", func(t TD) {
+		t.spy.branches = []string{"<script>alert(1)</script>"}
+		body := get(t, "/ui/")
+
+		Expect(t, body).To(Not(ContainSubstring("<script>")))
+		Expect(t, body).To(ContainSubstring("&lt;script&gt;"))
+	})
+}
+
+type spySources struct {
+	branches  []string
+	configs   []metrics.ConfigInfo
+	repos     []metrics.RepoInfo
+	runs      []metrics.Run
+	artifacts map[string][]artifacts.Artifact
+}
+
+func (s *spySources) Branches() []string {
+	return s.branches
+}
+
+func (s *spySources) ConfigInfo() []metrics.ConfigInfo {
+	return s.configs
+}
+
+func (s *spySources) RepoInfo() []metrics.RepoInfo {
+	return s.repos
+}
+
+func (s *spySources) Runs() []metrics.Run {
+	return s.runs
+}
+
+func (s *spySources) Run(id string) (metrics.Run, bool) {
+	for _, r := range s.runs {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return metrics.Run{}, false
+}
+
+func (s *spySources) List(runID string) []artifacts.Artifact {
+	return s.artifacts[runID]
+}
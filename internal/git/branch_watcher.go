@@ -2,7 +2,8 @@ package git
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 )
 
@@ -13,21 +14,28 @@ type BranchWatcher struct {
 
 	gitReads func(delta uint64)
 	gitErrs  func(delta uint64)
-	log      *log.Logger
+	log      *slog.Logger
+
+	reads *statusTracker
+
+	mu       sync.Mutex
+	branches []string
 }
 
 type BranchLister interface {
 	ListBranches() ([]string, error)
 }
 
+// StartBranchWatcher lists the branches every backoff until the context is
+// done. The returned BranchWatcher reports how listing them has gone.
 func StartBranchWatcher(
 	ctx context.Context,
 	lister BranchLister,
 	callback func(branches []string),
 	backoff time.Duration,
 	m Metrics,
-	log *log.Logger,
-) {
+	log *slog.Logger,
+) *BranchWatcher {
 	w := &BranchWatcher{
 		lister:   lister,
 		callback: callback,
@@ -36,9 +44,30 @@ func StartBranchWatcher(
 
 		gitReads: m.NewCounter("GitBranchReads"),
 		gitErrs:  m.NewCounter("GitBranchErrs"),
+
+		// Starting counts as an attempt, so that a watcher that has yet
+		// to list the branches isn't taken for a stuck one.
+		reads: &statusTracker{s: Status{LastAttempt: time.Now()}},
 	}
 
 	go w.start(ctx)
+
+	return w
+}
+
+// Status returns how listing the branches has gone.
+func (w *BranchWatcher) Status() Status {
+	return w.reads.status()
+}
+
+// Branches returns the branches that were last listed.
+func (w *BranchWatcher) Branches() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	results := make([]string, len(w.branches))
+	copy(results, w.branches)
+	return results
 }
 
 func (w *BranchWatcher) start(ctx context.Context) {
@@ -55,9 +84,10 @@ func (w *BranchWatcher) readFromGit() {
 	w.gitReads(1)
 
 	branches, err := w.lister.ListBranches()
+	w.reads.record(err)
 
 	if err != nil {
-		w.log.Printf("failed to read branches: %s", err)
+		w.log.Error("failed to read branches", "err", err)
 		w.gitErrs(1)
 		return
 	}
@@ -66,5 +96,9 @@ func (w *BranchWatcher) readFromGit() {
 		return
 	}
 
+	w.mu.Lock()
+	w.branches = branches
+	w.mu.Unlock()
+
 	w.callback(branches)
 }
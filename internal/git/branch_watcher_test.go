@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"sync"
 	"testing"
 	"time"
@@ -77,6 +77,42 @@ func TestBranchWatcher(t *testing.T) {
 		Expect(t, t.Branches).To(Always(HaveLen(0)))
 	})
 
+	o.Spec("it reports how listing the branches has gone", func(t *TB) {
+		t.spyBranchLister.errs = []error{errors.New("some-error")}
+		t.spyBranchLister.branches = [][]string{nil}
+
+		start := time.Now()
+		w := git.StartBranchWatcher(
+			context.Background(),
+			t.spyBranchLister,
+			func([]string) {},
+			time.Hour,
+			t.spyMetrics,
+			slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		)
+
+		Expect(t, func() error { return w.Status().Err }).To(ViaPolling(Not(BeNil())))
+		status := w.Status()
+		Expect(t, status.LastAttempt.Before(start)).To(BeFalse())
+		Expect(t, status.LastSuccess.IsZero()).To(BeTrue())
+	})
+
+	o.Spec("it remembers the branches it last listed", func(t *TB) {
+		t.spyBranchLister.errs = []error{nil}
+		t.spyBranchLister.branches = [][]string{{"some-branch", "some-other-branch"}}
+
+		w := git.StartBranchWatcher(
+			context.Background(),
+			t.spyBranchLister,
+			func([]string) {},
+			time.Hour,
+			t.spyMetrics,
+			slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		)
+
+		Expect(t, w.Branches).To(ViaPolling(Equal([]string{"some-branch", "some-other-branch"})))
+	})
+
 	o.Spec("it keeps track of how many errors it has encountered", func(t *TB) {
 		t.spyBranchLister.errs = []error{errors.New("some-error")}
 		t.spyBranchLister.branches = [][]string{{}}
@@ -99,7 +135,7 @@ func startBranchWalkerWithContext(ctx context.Context, t *TB) {
 		},
 		time.Nanosecond,
 		t.spyMetrics,
-		log.New(ioutil.Discard, "", 0),
+		slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
 	)
 }
 
@@ -114,7 +150,7 @@ func startBranchWalker(t *TB) {
 		},
 		time.Nanosecond,
 		t.spyMetrics,
-		log.New(ioutil.Discard, "", 0),
+		slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
 	)
 }
 
@@ -4,17 +4,44 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/poy/triple-c/internal/metrics"
 )
 
 type Repo interface {
 	SHA(branch string) (string, error)
 	File(SHA, filePath string) (string, error)
+	ListFiles(SHA string) ([]string, error)
+	Diff(fromSHA, toSHA string) ([]string, error)
+	Commit(SHA string) (Commit, error)
+	Commits(fromSHA, toSHA string) ([]string, error)
 	ListBranches() ([]string, error)
+	ListTags() ([]string, error)
+}
+
+// Commit describes a single commit.
+type Commit struct {
+	SHA            string
+	AuthorName     string
+	AuthorEmail    string
+	CommitterName  string
+	CommitterEmail string
+	Timestamp      time.Time
+	Message        string
+}
+
+// SkipCI reports whether the commit message asks for CI to be skipped with
+// [skip ci] or [ci skip].
+func (c Commit) SkipCI() bool {
+	m := strings.ToLower(c.Message)
+	return strings.Contains(m, "[skip ci]") || strings.Contains(m, "[ci skip]")
 }
 
 type repo struct {
@@ -22,6 +49,13 @@ type repo struct {
 	exec     Executer
 	repoPath string
 
+	m      Metrics
+	labels metrics.Labels
+
+	// fetches is how fetching the repo has gone. Cloning it counts as its
+	// first fetch.
+	fetches *statusTracker
+
 	gitFetchSuccess func(uint64)
 	gitFetchFail    func(uint64)
 
@@ -31,8 +65,23 @@ type repo struct {
 	gitFileSuccess func(uint64)
 	gitFileFailure func(uint64)
 
+	gitListFilesSuccess func(uint64)
+	gitListFilesFailure func(uint64)
+
+	gitDiffSuccess func(uint64)
+	gitDiffFailure func(uint64)
+
+	gitCommitSuccess func(uint64)
+	gitCommitFailure func(uint64)
+
+	gitCommitsSuccess func(uint64)
+	gitCommitsFailure func(uint64)
+
 	gitBranchesSuccess func(uint64)
 	gitBranchesFailure func(uint64)
+
+	gitTagsSuccess func(uint64)
+	gitTagsFailure func(uint64)
 }
 
 type Executer interface {
@@ -46,7 +95,8 @@ func (f ExecutorFunc) Run(path string, commands ...string) ([]string, error) {
 }
 
 type Metrics interface {
-	NewCounter(name string) func(delta uint64)
+	NewCounter(name string, labels ...metrics.Labels) func(delta uint64)
+	NewHistogram(name string, buckets []float64, labels ...metrics.Labels) func(value float64)
 }
 
 func NewRepo(
@@ -57,31 +107,54 @@ func NewRepo(
 	m Metrics,
 ) (Repo, error) {
 	repoDirName := base64.RawURLEncoding.EncodeToString([]byte(repoPath))
+	labels := metrics.Labels{"repo": repoLabel(repoPath)}
 
 	r := &repo{
 		exec:     e,
 		repoPath: path.Join(tmpPath, repoDirName),
 
-		gitFetchSuccess:    m.NewCounter("GitFetchAllSuccess"),
-		gitFetchFail:       m.NewCounter("GitFetchAllFailure"),
-		gitSHASuccess:      m.NewCounter("GitSHASuccess"),
-		gitSHAFailure:      m.NewCounter("GitSHAFailure"),
-		gitFileSuccess:     m.NewCounter("GitFileSuccess"),
-		gitFileFailure:     m.NewCounter("GitFileFailure"),
-		gitBranchesSuccess: m.NewCounter("GitBranchesSuccess"),
-		gitBranchesFailure: m.NewCounter("GitBranchesFailure"),
+		m:       m,
+		labels:  labels,
+		fetches: &statusTracker{},
+
+		gitFetchSuccess:    m.NewCounter("GitFetchAllSuccess", labels),
+		gitFetchFail:       m.NewCounter("GitFetchAllFailure", labels),
+		gitSHASuccess:      m.NewCounter("GitSHASuccess", labels),
+		gitSHAFailure:      m.NewCounter("GitSHAFailure", labels),
+		gitFileSuccess:     m.NewCounter("GitFileSuccess", labels),
+		gitFileFailure:     m.NewCounter("GitFileFailure", labels),
+		gitBranchesSuccess: m.NewCounter("GitBranchesSuccess", labels),
+		gitBranchesFailure: m.NewCounter("GitBranchesFailure", labels),
+
+		gitListFilesSuccess: m.NewCounter("GitListFilesSuccess", labels),
+		gitListFilesFailure: m.NewCounter("GitListFilesFailure", labels),
+
+		gitDiffSuccess: m.NewCounter("GitDiffSuccess", labels),
+		gitDiffFailure: m.NewCounter("GitDiffFailure", labels),
+
+		gitCommitSuccess: m.NewCounter("GitCommitSuccess", labels),
+		gitCommitFailure: m.NewCounter("GitCommitFailure", labels),
+
+		gitCommitsSuccess: m.NewCounter("GitCommitsSuccess", labels),
+		gitCommitsFailure: m.NewCounter("GitCommitsFailure", labels),
+
+		gitTagsSuccess: m.NewCounter("GitTagsSuccess", labels),
+		gitTagsFailure: m.NewCounter("GitTagsFailure", labels),
 	}
 
 	if !r.exists(r.repoPath) {
+		start := time.Now()
 		_, err := r.exec.Run(
 			tmpPath,
 			"git", "clone", repoPath, repoDirName,
 		)
+		r.observe("clone", start)
 
 		if err != nil {
 			return nil, err
 		}
 	}
+	r.fetches.record(nil)
 
 	go r.start(interval)
 
@@ -92,9 +165,9 @@ func (r repo) SHA(branch string) (string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	results, err := r.exec.Run(
-		r.repoPath,
-		"git", "rev-parse", branch,
+	results, err := r.git(
+		"sha",
+		"rev-parse", branch,
 	)
 
 	if err != nil {
@@ -115,9 +188,9 @@ func (r repo) File(SHA, filePath string) (string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	results, err := r.exec.Run(
-		r.repoPath,
-		"git", "show", fmt.Sprintf("%s:%s", SHA, filePath),
+	results, err := r.git(
+		"file",
+		"show", fmt.Sprintf("%s:%s", SHA, filePath),
 	)
 
 	if err != nil {
@@ -129,13 +202,110 @@ func (r repo) File(SHA, filePath string) (string, error) {
 	return strings.Join(results, "\n"), nil
 }
 
+// ListFiles returns the path of every file in the repo at the given SHA.
+func (r repo) ListFiles(SHA string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results, err := r.git(
+		"list_files",
+		"ls-tree", "-r", "--name-only", SHA,
+	)
+
+	if err != nil {
+		r.gitListFilesFailure(1)
+		return nil, err
+	}
+
+	r.gitListFilesSuccess(1)
+	return results, nil
+}
+
+// Diff returns the path of every file that changed between the two SHAs.
+func (r repo) Diff(fromSHA, toSHA string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results, err := r.git(
+		"diff",
+		"diff", "--name-only", fromSHA, toSHA,
+	)
+
+	if err != nil {
+		r.gitDiffFailure(1)
+		return nil, err
+	}
+
+	r.gitDiffSuccess(1)
+	return results, nil
+}
+
+// Commit returns the author, committer, timestamp and message of the commit
+// at the given SHA.
+func (r repo) Commit(SHA string) (Commit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results, err := r.git(
+		"commit",
+		"show", "-s", "--format=%H%n%an%n%ae%n%cn%n%ce%n%ct%n%B", SHA,
+	)
+
+	if err != nil {
+		r.gitCommitFailure(1)
+		return Commit{}, err
+	}
+
+	if len(results) < 6 {
+		r.gitCommitFailure(1)
+		return Commit{}, fmt.Errorf("unexpected output for commit %s", SHA)
+	}
+
+	ts, err := strconv.ParseInt(results[5], 10, 64)
+	if err != nil {
+		r.gitCommitFailure(1)
+		return Commit{}, fmt.Errorf("invalid timestamp for commit %s: %s", SHA, err)
+	}
+
+	r.gitCommitSuccess(1)
+	return Commit{
+		SHA:            results[0],
+		AuthorName:     results[1],
+		AuthorEmail:    results[2],
+		CommitterName:  results[3],
+		CommitterEmail: results[4],
+		Timestamp:      time.Unix(ts, 0).UTC(),
+		Message:        strings.TrimSpace(strings.Join(results[6:], "\n")),
+	}, nil
+}
+
+// Commits returns the SHA of every commit reachable from toSHA but not from
+// fromSHA, oldest first.
+func (r repo) Commits(fromSHA, toSHA string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results, err := r.git(
+		"commits",
+		"rev-list", "--reverse", fmt.Sprintf("%s..%s", fromSHA, toSHA),
+	)
+
+	if err != nil {
+		r.gitCommitsFailure(1)
+		return nil, err
+	}
+
+	r.gitCommitsSuccess(1)
+	return results, nil
+}
+
 func (r repo) ListBranches() ([]string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	results, err := r.exec.Run(
-		r.repoPath,
-		"git", "branch", "-a",
+	results, err := r.git(
+		"branches",
+		"branch", "-a",
 	)
 
 	if err != nil {
@@ -156,6 +326,25 @@ func (r repo) ListBranches() ([]string, error) {
 	return branches, nil
 }
 
+// ListTags returns the name of every tag in the repo.
+func (r repo) ListTags() ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results, err := r.git(
+		"tags",
+		"tag", "-l",
+	)
+
+	if err != nil {
+		r.gitTagsFailure(1)
+		return nil, err
+	}
+
+	r.gitTagsSuccess(1)
+	return results, nil
+}
+
 func (r repo) start(interval time.Duration) {
 	for {
 		func() {
@@ -164,10 +353,11 @@ func (r repo) start(interval time.Duration) {
 			r.mu.Lock()
 			defer r.mu.Unlock()
 
-			_, err := r.exec.Run(
-				r.repoPath,
-				"git", "fetch", "--all",
+			_, err := r.git(
+				"fetch",
+				"fetch", "--all",
 			)
+			r.fetches.record(err)
 			if err != nil {
 				r.gitFetchFail(1)
 				return
@@ -177,6 +367,34 @@ func (r repo) start(interval time.Duration) {
 	}
 }
 
+// git runs the git command in the repo and observes how long it took as the
+// latency of the operation.
+func (r repo) git(op string, args ...string) ([]string, error) {
+	defer r.observe(op, time.Now())
+	return r.exec.Run(r.repoPath, append([]string{"git"}, args...)...)
+}
+
+func (r repo) observe(op string, start time.Time) {
+	r.m.NewHistogram(
+		"GitOperationSeconds",
+		metrics.DefaultBuckets,
+		r.labels,
+		metrics.Labels{"op": op},
+	)(time.Since(start).Seconds())
+}
+
+// repoLabel returns the repo path without any credentials, so they don't
+// end up in metrics.
+func repoLabel(repoPath string) string {
+	u, err := url.Parse(repoPath)
+	if err != nil || u.User == nil {
+		return repoPath
+	}
+
+	u.User = nil
+	return u.String()
+}
+
 func (r repo) exists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
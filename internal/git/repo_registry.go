@@ -42,6 +42,22 @@ func (r *RepoRegistry) FetchRepo(repoPath string) (Repo, error) {
 	return repo, nil
 }
 
+// FetchStatus returns how fetching each repo has gone, by repo. Repos are
+// named without any credentials.
+func (r *RepoRegistry) FetchStatus() map[string]Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make(map[string]Status, len(r.m))
+	for repoPath, rr := range r.m {
+		if rr, ok := rr.(*repo); ok {
+			statuses[repoLabel(repoPath)] = rr.fetches.status()
+		}
+	}
+
+	return statuses
+}
+
 func (r *RepoRegistry) ListRepos() []Repo {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -0,0 +1,45 @@
+package git
+
+import (
+	"sync"
+	"time"
+)
+
+// Status is how a recurring git operation (e.g., fetching a repo or listing
+// branches) has gone.
+type Status struct {
+	// LastAttempt is when the operation last ran.
+	LastAttempt time.Time
+
+	// LastSuccess is when the operation last succeeded. It is zero if it
+	// never has.
+	LastSuccess time.Time
+
+	// Err is why the last attempt failed. It is nil if it succeeded.
+	Err error
+}
+
+// statusTracker records a Status. It is shared by copies of whatever owns
+// it.
+type statusTracker struct {
+	mu sync.Mutex
+	s  Status
+}
+
+func (t *statusTracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.s.LastAttempt = now
+	t.s.Err = err
+	if err == nil {
+		t.s.LastSuccess = now
+	}
+}
+
+func (t *statusTracker) status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.s
+}
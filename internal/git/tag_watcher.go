@@ -0,0 +1,182 @@
+package git
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TagWatcher struct {
+	lister   TagLister
+	patterns []string
+	callback func(tag, SHA string)
+	backoff  time.Duration
+
+	// seen is shared with earlier watchers of the same plan, if any.
+	seen *SeenTags
+
+	gitReads func(delta uint64)
+	gitErrs  func(delta uint64)
+	log      *slog.Logger
+}
+
+type TagLister interface {
+	ListTags() ([]string, error)
+	SHA(ref string) (string, error)
+}
+
+// SeenTags are the tags TagWatchers have read. A watcher that is given the
+// SeenTags of an earlier one (e.g., when its plan changes) reports the tags
+// pushed since, instead of starting over.
+type SeenTags struct {
+	mu sync.Mutex
+
+	// tags is nil until the first read.
+	tags map[string]bool
+}
+
+// NewSeenTags returns SeenTags that haven't been read into yet.
+func NewSeenTags() *SeenTags {
+	return &SeenTags{}
+}
+
+// seed records the tags if nothing has been read yet, and reports whether
+// it did.
+func (s *SeenTags) seed(tags []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tags != nil {
+		return false
+	}
+
+	s.tags = make(map[string]bool)
+	for _, tag := range tags {
+		s.tags[tag] = true
+	}
+	return true
+}
+
+func (s *SeenTags) has(tag string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tags[tag]
+}
+
+// claim adds the tag and reports whether it wasn't already there, so that
+// only one of the watchers sharing the SeenTags reports it.
+func (s *SeenTags) claim(tag string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tags[tag] {
+		return false
+	}
+	s.tags[tag] = true
+	return true
+}
+
+// StartTagWatcher invokes the callback with the tag and the SHA of its
+// commit for every new tag that matches one of the patterns (see
+// path.Match). Tags in seen are not new. If seen is empty, the tags that
+// exist when the watcher starts are added to it (and logged) rather than
+// reported.
+func StartTagWatcher(
+	ctx context.Context,
+	lister TagLister,
+	patterns []string,
+	seen *SeenTags,
+	callback func(tag, SHA string),
+	backoff time.Duration,
+	m Metrics,
+	log *slog.Logger,
+) {
+	w := &TagWatcher{
+		lister:   lister,
+		patterns: patterns,
+		seen:     seen,
+		callback: callback,
+		log:      log,
+		backoff:  backoff,
+
+		gitReads: m.NewCounter("GitTagReads"),
+		gitErrs:  m.NewCounter("GitTagErrs"),
+	}
+
+	go w.start(ctx)
+}
+
+func (w *TagWatcher) start(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		w.readFromGit()
+	}
+}
+
+func (w *TagWatcher) readFromGit() {
+	defer time.Sleep(w.backoff)
+	w.gitReads(1)
+
+	tags, err := w.lister.ListTags()
+	if err != nil {
+		w.log.Error("failed to read tags", "err", err)
+		w.gitErrs(1)
+		return
+	}
+
+	sort.Strings(tags)
+	if w.seen.seed(tags) {
+		var skipped []string
+		for _, tag := range tags {
+			if w.matches(tag) {
+				skipped = append(skipped, tag)
+			}
+		}
+		if len(skipped) > 0 {
+			w.log.Info("not building tags that existed before the watcher started", "tags", strings.Join(skipped, ","))
+		}
+		return
+	}
+
+	for _, tag := range tags {
+		if w.seen.has(tag) {
+			continue
+		}
+
+		// Tags that don't match are seen too, so that a later watcher with
+		// other patterns doesn't build tags pushed before it started.
+		if !w.matches(tag) {
+			w.seen.claim(tag)
+			continue
+		}
+
+		// Resolve annotated tags to the commit they point at.
+		sha, err := w.lister.SHA(tag + "^{commit}")
+		if err != nil {
+			// Try again on the next read.
+			w.log.Error("failed to read SHA for tag", "tag", tag, "err", err)
+			w.gitErrs(1)
+			continue
+		}
+
+		if w.seen.claim(tag) {
+			w.callback(tag, sha)
+		}
+	}
+}
+
+func (w *TagWatcher) matches(tag string) bool {
+	for _, p := range w.patterns {
+		if ok, _ := path.Match(p, tag); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,171 @@
+package git_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/git"
+)
+
+type TT struct {
+	*testing.T
+	spyTagLister *spyTagLister
+	spyMetrics   *spyMetrics
+	seen         *git.SeenTags
+	tags         []string
+	mu           *sync.Mutex
+}
+
+func (t *TT) Tags() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results := make([]string, len(t.tags))
+	copy(results, t.tags)
+
+	return results
+}
+
+func TestTagWatcher(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) *TT {
+		return &TT{
+			T:            t,
+			spyTagLister: newSpyTagLister(),
+			spyMetrics:   newSpyMetrics(),
+			seen:         git.NewSeenTags(),
+			mu:           &sync.Mutex{},
+		}
+	})
+
+	o.Spec("invokes the function for new tags that match a pattern", func(t *TT) {
+		t.spyTagLister.tags = [][]string{
+			{"v0.1.0"},
+			{"v0.1.0", "v0.2.0", "nightly"},
+		}
+		t.spyTagLister.shas = map[string]string{
+			"v0.2.0^{commit}":  "sha-2",
+			"nightly^{commit}": "sha-3",
+		}
+
+		startTagWatcher(context.Background(), t)
+
+		Expect(t, t.Tags).To(ViaPolling(Equal([]string{"v0.2.0:sha-2"})))
+		Expect(t, t.Tags).To(Always(HaveLen(1)))
+	})
+
+	o.Spec("it retries tags it could not resolve", func(t *TT) {
+		t.spyTagLister.tags = [][]string{
+			{},
+			{"v0.1.0"},
+			{"v0.1.0"},
+		}
+		t.spyTagLister.shaErrs = []error{errors.New("some-error")}
+		t.spyTagLister.shas = map[string]string{"v0.1.0^{commit}": "sha-1"}
+
+		startTagWatcher(context.Background(), t)
+
+		Expect(t, t.Tags).To(ViaPolling(Equal([]string{"v0.1.0:sha-1"})))
+		Expect(t, t.spyMetrics.GetDelta("GitTagErrs")()).To(Equal(uint64(1)))
+	})
+
+	o.Spec("it picks up where an earlier watcher with the same seen tags left off", func(t *TT) {
+		t.spyTagLister.tags = [][]string{{"v0.1.0"}}
+		ctx, cancel := context.WithCancel(context.Background())
+		startTagWatcher(ctx, t)
+		Expect(t, func() bool {
+			// The first read is done once the second starts.
+			return t.spyMetrics.GetDelta("GitTagReads")() > 1
+		}).To(ViaPolling(BeTrue()))
+		cancel()
+
+		t.spyTagLister.mu.Lock()
+		t.spyTagLister.tags = [][]string{{"v0.1.0", "v0.2.0"}}
+		t.spyTagLister.shas = map[string]string{"v0.2.0^{commit}": "sha-2"}
+		t.spyTagLister.mu.Unlock()
+		startTagWatcher(context.Background(), t)
+
+		Expect(t, t.Tags).To(ViaPolling(Equal([]string{"v0.2.0:sha-2"})))
+		Expect(t, t.Tags).To(Always(HaveLen(1)))
+	})
+
+	o.Spec("stops watching when context is canceled", func(t *TT) {
+		t.spyTagLister.tags = [][]string{{}, {"v0.1.0"}}
+		t.spyTagLister.shas = map[string]string{"v0.1.0^{commit}": "sha-1"}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		startTagWatcher(ctx, t)
+
+		Expect(t, t.Tags).To(Always(HaveLen(0)))
+	})
+}
+
+func startTagWatcher(ctx context.Context, t *TT) {
+	git.StartTagWatcher(
+		ctx,
+		t.spyTagLister,
+		[]string{"v*"},
+		t.seen,
+		func(tag, SHA string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tags = append(t.tags, tag+":"+SHA)
+		},
+		time.Nanosecond,
+		t.spyMetrics,
+		slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+	)
+}
+
+type spyTagLister struct {
+	mu sync.Mutex
+
+	tags    [][]string
+	shas    map[string]string
+	shaErrs []error
+}
+
+func newSpyTagLister() *spyTagLister {
+	return &spyTagLister{}
+}
+
+func (s *spyTagLister) ListTags() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tags) == 0 {
+		return nil, nil
+	}
+
+	c := s.tags[0]
+	if len(s.tags) > 1 {
+		s.tags = s.tags[1:]
+	}
+
+	return c, nil
+}
+
+func (s *spyTagLister) SHA(ref string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.shaErrs) > 0 {
+		err := s.shaErrs[0]
+		s.shaErrs = s.shaErrs[1:]
+		return "", err
+	}
+
+	return s.shas[ref], nil
+}
@@ -2,7 +2,7 @@ package git
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 )
 
@@ -12,7 +12,11 @@ type Watcher struct {
 	repoName string
 	branch   string
 
-	log *log.Logger
+	// maxCommits is how many of the commits since the last poll are passed
+	// to commit. Zero means only the newest.
+	maxCommits int
+
+	log *slog.Logger
 }
 
 type SHATracker interface {
@@ -25,18 +29,20 @@ func StartWatcher(
 	branch string,
 	commit func(SHA string),
 	interval time.Duration,
+	maxCommits int,
 	repo Repo,
 	shaTracker SHATracker,
-	log *log.Logger,
+	log *slog.Logger,
 ) {
 	tracker := shaTracker.Register(ctx, repoName, branch)
 
 	w := &Watcher{
-		commit:   commit,
-		repoName: repoName,
-		branch:   branch,
-		repo:     repo,
-		log:      log,
+		commit:     commit,
+		repoName:   repoName,
+		branch:     branch,
+		maxCommits: maxCommits,
+		repo:       repo,
+		log:        log.With("repo", repoName, "branch", branch),
 	}
 
 	go w.start(ctx, interval, tracker)
@@ -58,7 +64,7 @@ func (w *Watcher) start(ctx context.Context, interval time.Duration, tracker fun
 func (w *Watcher) readSHA(lastSHA string) string {
 	sha, err := w.repo.SHA(w.branch)
 	if err != nil {
-		w.log.Printf("failed to read SHA for %s on %s: %s", w.repoName, w.branch, err)
+		w.log.Error("failed to read SHA", "err", err)
 		return lastSHA
 	}
 
@@ -66,6 +72,30 @@ func (w *Watcher) readSHA(lastSHA string) string {
 		return lastSHA
 	}
 
-	w.commit(sha)
+	for _, s := range w.newCommits(lastSHA, sha) {
+		w.commit(s)
+	}
 	return sha
 }
+
+// newCommits returns the commits after lastSHA up to and including SHA,
+// oldest first. Only SHA is returned unless maxCommits is set. If there are
+// more than maxCommits, the oldest are dropped.
+func (w *Watcher) newCommits(lastSHA, SHA string) []string {
+	if w.maxCommits <= 0 || lastSHA == "" {
+		return []string{SHA}
+	}
+
+	shas, err := w.repo.Commits(lastSHA, SHA)
+	if err != nil || len(shas) == 0 {
+		w.log.Warn("failed to list commits, using the newest", "from_sha", lastSHA, "sha", SHA, "err", err)
+		return []string{SHA}
+	}
+
+	if len(shas) > w.maxCommits {
+		w.log.Info("skipping commits", "skipped", len(shas)-w.maxCommits, "from_sha", lastSHA, "sha", SHA, "max_commits", w.maxCommits)
+		shas = shas[len(shas)-w.maxCommits:]
+	}
+
+	return shas
+}
@@ -3,7 +3,7 @@ package git_test
 import (
 	"context"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"sync"
 	"testing"
 	"time"
@@ -83,6 +83,33 @@ func TestWatcher(t *testing.T) {
 			Contain("sha2"),
 		)))
 	})
+
+	o.Spec("it invokes the function for every new commit up to the max", func(t *TW) {
+		t.spyRepo.errs = []error{nil, nil, nil}
+		t.spyRepo.shas = []string{"sha1", "sha4", "sha6"}
+		t.spyRepo.commits = map[string][]string{
+			"sha1..sha4": {"sha2", "sha3", "sha4"},
+			"sha4..sha6": {"sha5", "sha6"},
+		}
+
+		git.StartWatcher(
+			context.Background(),
+			"some-repo",
+			"some-branch",
+			func(sha string) {
+				t.mu.Lock()
+				defer t.mu.Unlock()
+				t.shas = append(t.shas, sha)
+			},
+			time.Millisecond,
+			2,
+			t.spyRepo,
+			t.spySHATracker,
+			slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
+		)
+
+		Expect(t, t.Shas).To(ViaPolling(Equal([]string{"sha1", "sha3", "sha4", "sha5", "sha6"})))
+	})
 }
 
 func startWatcherWithContext(ctx context.Context, t *TW) {
@@ -96,9 +123,10 @@ func startWatcherWithContext(ctx context.Context, t *TW) {
 			t.shas = append(t.shas, sha)
 		},
 		time.Millisecond,
+		0,
 		t.spyRepo,
 		t.spySHATracker,
-		log.New(ioutil.Discard, "", 0),
+		slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
 	)
 }
 
@@ -113,9 +141,10 @@ func startWatcher(t *TW) {
 			t.shas = append(t.shas, sha)
 		},
 		time.Millisecond,
+		0,
 		t.spyRepo,
 		t.spySHATracker,
-		log.New(ioutil.Discard, "", 0),
+		slog.New(slog.NewTextHandler(ioutil.Discard, nil)),
 	)
 }
 
@@ -127,6 +156,16 @@ type spyRepo struct {
 
 	shas []string
 	errs []error
+
+	commits    map[string][]string
+	commitsErr error
+}
+
+func (s *spyRepo) Commits(fromSHA, toSHA string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commits[fromSHA+".."+toSHA], s.commitsErr
 }
 
 func newSpyRepo() *spyRepo {
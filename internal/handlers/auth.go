@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Scope is what a client of the API may do.
+type Scope string
+
+const (
+	// ScopeRead allows listing repos, configs, runs, caches and metrics.
+	ScopeRead Scope = "read"
+
+	// ScopeTrigger is reserved for endpoints that start and stop runs,
+	// which don't exist yet. For now it allows what ScopeRead does.
+	ScopeTrigger Scope = "trigger"
+
+	// ScopeAdmin allows everything else (e.g., removing caches). It implies
+	// every other scope.
+	ScopeAdmin Scope = "admin"
+)
+
+// ParseScope returns the scope with the given name.
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case ScopeRead, ScopeTrigger, ScopeAdmin:
+		return Scope(s), nil
+	default:
+		return "", fmt.Errorf("unknown scope %q", s)
+	}
+}
+
+// Identity is who made a request and what they may do.
+type Identity struct {
+	// Subject names the client (e.g., a static token's name or a JWT's
+	// sub claim).
+	Subject string
+	Scopes  []Scope
+}
+
+// HasScope reports whether the identity has the scope, or one that implies
+// it.
+func (i Identity) HasScope(s Scope) bool {
+	for _, has := range i.Scopes {
+		switch {
+		case has == s, has == ScopeAdmin:
+			return true
+		case has == ScopeTrigger && s == ScopeRead:
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnknownToken is returned by an Authenticator for a token it doesn't
+// issue, so that the next one can try it.
+var ErrUnknownToken = errors.New("unknown token")
+
+// Authenticator returns who a bearer token belongs to.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Identity, error)
+}
+
+// Authenticators tries each Authenticator in turn until one knows the
+// token.
+type Authenticators []Authenticator
+
+func (a Authenticators) Authenticate(ctx context.Context, token string) (Identity, error) {
+	for _, auth := range a {
+		id, err := auth.Authenticate(ctx, token)
+		if err == ErrUnknownToken {
+			continue
+		}
+		return id, err
+	}
+	return Identity{}, ErrUnknownToken
+}
+
+// StaticToken is a long-lived API token.
+type StaticToken struct {
+	Name   string
+	Token  string
+	Scopes []Scope
+}
+
+// StaticTokens authenticates a fixed set of tokens. Its Identities are named
+// after the tokens.
+type StaticTokens []StaticToken
+
+func (s StaticTokens) Authenticate(ctx context.Context, token string) (Identity, error) {
+	// Every token is compared so that how long this takes doesn't give
+	// away which ones are close.
+	var found *StaticToken
+	for i, t := range s {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			found = &s[i]
+		}
+	}
+
+	if found == nil {
+		return Identity{}, ErrUnknownToken
+	}
+
+	return Identity{
+		Subject: found.Name,
+		Scopes:  found.Scopes,
+	}, nil
+}
+
+// Auth is middleware that only lets through requests with a bearer token
+// that has the scope they need. The Identity of an authenticated request is
+// in its context.
+type Auth struct {
+	a   Authenticator
+	log *slog.Logger
+}
+
+// NewAuth returns an Auth that uses a to authenticate requests. If a is nil,
+// every request is let through.
+func NewAuth(a Authenticator, log *slog.Logger) *Auth {
+	return &Auth{
+		a:   a,
+		log: log,
+	}
+}
+
+// Protect returns a handler that requires the safe scope for GET and HEAD
+// requests and the unsafe scope for anything else before passing them on to
+// next.
+func (a *Auth) Protect(next http.Handler, safe, unsafe Scope) http.Handler {
+	if a.a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := unsafe
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			scope = safe
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="triple-c"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		id, err := a.a.Authenticate(r.Context(), token)
+		if err != nil {
+			a.log.Info("rejected API token", "path", r.URL.Path, "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="triple-c", error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !id.HasScope(scope) {
+			a.log.Info("API token lacks scope", "path", r.URL.Path, "subject", id.Subject, "scope", scope)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="triple-c", error="insufficient_scope", scope=%q`, scope))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the Identity of the authenticated request the
+// context belongs to.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < len("Bearer ") || !strings.EqualFold(h[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(h[len("Bearer "):])
+	return token, token != ""
+}
@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/handlers"
+)
+
+type TA struct {
+	*testing.T
+	h        http.Handler
+	recorder *httptest.ResponseRecorder
+	spyNext  *spyHandler
+}
+
+func TestAuth(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TA {
+		spyNext := &spyHandler{}
+		auth := handlers.NewAuth(handlers.Authenticators{
+			handlers.StaticTokens{
+				{Name: "reader", Token: "read-token", Scopes: []handlers.Scope{handlers.ScopeRead}},
+				{Name: "ci", Token: "trigger-token", Scopes: []handlers.Scope{handlers.ScopeTrigger}},
+				{Name: "ops", Token: "admin-token", Scopes: []handlers.Scope{handlers.ScopeAdmin}},
+			},
+			authenticatorFunc(func(token string) (handlers.Identity, error) {
+				if token == "bad-token" {
+					return handlers.Identity{}, errors.New("some-error")
+				}
+				return handlers.Identity{}, handlers.ErrUnknownToken
+			}),
+		}, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		return TA{
+			T:        t,
+			h:        auth.Protect(spyNext, handlers.ScopeRead, handlers.ScopeAdmin),
+			recorder: httptest.NewRecorder(),
+			spyNext:  spyNext,
+		}
+	})
+
+	do := func(t TA, method, authorization string) {
+		req, err := http.NewRequest(method, "http://some.url/v1/caches", nil)
+		Expect(t, err).To(BeNil())
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		t.h.ServeHTTP(t.recorder, req)
+	}
+
+	o.Spec("it returns a 401 without a bearer token", func(t TA) {
+		do(t, "GET", "")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(t, t.recorder.Header().Get("WWW-Authenticate")).To(Equal(`Bearer realm="triple-c"`))
+		Expect(t, t.spyNext.called).To(BeFalse())
+
+		t.recorder = httptest.NewRecorder()
+		do(t, "GET", "Basic cmVhZC10b2tlbjo=")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	o.Spec("it returns a 401 for unknown or invalid tokens", func(t TA) {
+		do(t, "GET", "Bearer unknown-token")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(t, t.recorder.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error="invalid_token"`))
+
+		t.recorder = httptest.NewRecorder()
+		do(t, "GET", "Bearer bad-token")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(t, t.spyNext.called).To(BeFalse())
+	})
+
+	o.Spec("it passes requests with the scope on with their identity", func(t TA) {
+		do(t, "GET", "bearer read-token")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.spyNext.called).To(BeTrue())
+		Expect(t, t.spyNext.identity.Subject).To(Equal("reader"))
+	})
+
+	o.Spec("it requires the unsafe scope for anything other than a GET or HEAD", func(t TA) {
+		do(t, "DELETE", "Bearer read-token")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(t, t.recorder.Header().Get("WWW-Authenticate")).To(ContainSubstring(`scope="admin"`))
+
+		t.recorder = httptest.NewRecorder()
+		do(t, "DELETE", "Bearer trigger-token")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(t, t.spyNext.called).To(BeFalse())
+
+		t.recorder = httptest.NewRecorder()
+		do(t, "DELETE", "Bearer admin-token")
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	o.Spec("higher scopes imply lower ones", func(t TA) {
+		for _, token := range []string{"trigger-token", "admin-token"} {
+			t.recorder = httptest.NewRecorder()
+			do(t, "HEAD", "Bearer "+token)
+			Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		}
+	})
+
+	o.Spec("it lets everything through without an authenticator", func(t TA) {
+		h := handlers.NewAuth(nil, slog.New(slog.NewTextHandler(ioutil.Discard, nil))).Protect(t.spyNext, handlers.ScopeRead, handlers.ScopeAdmin)
+		req, err := http.NewRequest("DELETE", "http://some.url/v1/caches", nil)
+		Expect(t, err).To(BeNil())
+		h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.spyNext.called).To(BeTrue())
+	})
+
+	o.Spec("it parses scopes", func(t TA) {
+		s, err := handlers.ParseScope("trigger")
+		Expect(t, err).To(BeNil())
+		Expect(t, s).To(Equal(handlers.ScopeTrigger))
+
+		_, err = handlers.ParseScope("invalid")
+		Expect(t, err).To(Not(BeNil()))
+	})
+}
+
+type authenticatorFunc func(token string) (handlers.Identity, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, token string) (handlers.Identity, error) {
+	return f(token)
+}
+
+type spyHandler struct {
+	called   bool
+	identity handlers.Identity
+}
+
+func (s *spyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.called = true
+	s.identity, _ = handlers.IdentityFromContext(r.Context())
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/poy/triple-c/internal/artifacts"
+)
+
+type Caches struct {
+	s   CacheStore
+	log *slog.Logger
+}
+
+// CacheStore keeps each cache as its own run (see artifacts.CacheID).
+type CacheStore interface {
+	Runs() []string
+	List(runID string) []artifacts.Artifact
+	Remove(runID string) error
+}
+
+// NewCaches returns a handler that lists and purges caches:
+//
+//	GET    /v1/caches[?plan=<plan>]  lists caches
+//	DELETE /v1/caches?plan=<plan>    purges every cache of the plan
+//	DELETE /v1/caches/<id>           purges a single cache
+func NewCaches(s CacheStore, log *slog.Logger) http.Handler {
+	return &Caches{
+		s:   s,
+		log: log,
+	}
+}
+
+type cacheResult struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+func (c *Caches) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/caches")
+	id = strings.TrimPrefix(id, "/")
+	if strings.Contains(id, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	ids := c.find(id, r.URL.Query().Get("plan"))
+
+	switch r.Method {
+	case http.MethodGet:
+		if id != "" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var results struct {
+			Size   int64         `json:"size"`
+			Caches []cacheResult `json:"caches"`
+		}
+		results.Caches = []cacheResult{}
+
+		for _, id := range ids {
+			for _, a := range c.s.List(id) {
+				results.Size += a.Size
+				results.Caches = append(results.Caches, cacheResult{
+					ID:      id,
+					Size:    a.Size,
+					Created: a.Created,
+				})
+			}
+		}
+
+		c.write(w, results)
+
+	case http.MethodDelete:
+		if id == "" && r.URL.Query().Get("plan") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("a cache ID or plan is required"))
+			return
+		}
+
+		if id != "" && len(ids) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var results struct {
+			Removed []string `json:"removed"`
+		}
+		results.Removed = []string{}
+
+		for _, id := range ids {
+			if err := c.s.Remove(id); err != nil {
+				c.log.Error("failed to remove cache", "cache", id, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			results.Removed = append(results.Removed, id)
+		}
+
+		c.write(w, results)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// find returns the ID of every stored cache that matches the ID (if set)
+// and belongs to the plan (if set).
+func (c *Caches) find(id, plan string) []string {
+	var prefix string
+	if plan != "" {
+		prefix = artifacts.CachePrefix(plan)
+	}
+
+	var ids []string
+	for _, runID := range c.s.Runs() {
+		if id != "" && runID != id {
+			continue
+		}
+
+		if !strings.HasPrefix(runID, prefix) {
+			continue
+		}
+
+		ids = append(ids, runID)
+	}
+
+	return ids
+}
+
+func (c *Caches) write(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.log.Error("failed to marshal results", "err", err)
+		panic(err)
+	}
+
+	w.Write(data)
+}
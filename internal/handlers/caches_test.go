@@ -0,0 +1,119 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/handlers"
+)
+
+type TC struct {
+	*testing.T
+	h        http.Handler
+	store    *artifacts.Store
+	recorder *httptest.ResponseRecorder
+}
+
+func TestCaches(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TC {
+		dir, err := ioutil.TempDir("", "")
+		Expect(t, err).To(BeNil())
+
+		store, err := artifacts.NewStore(dir, artifacts.RetentionPolicy{}, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		Expect(t, err).To(BeNil())
+
+		for _, id := range []string{
+			artifacts.CacheID("some-plan", "some-branch"),
+			artifacts.CacheID("some-plan", "other-branch"),
+			artifacts.CacheID("other-plan", "some-branch"),
+		} {
+			_, err := store.Put(id, "cache", strings.NewReader("some-data"))
+			Expect(t, err).To(BeNil())
+		}
+
+		return TC{
+			T:        t,
+			h:        handlers.NewCaches(store, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+			store:    store,
+			recorder: httptest.NewRecorder(),
+		}
+	})
+
+	o.Spec("it lists every cache", func(t TC) {
+		req, err := http.NewRequest("GET", "http://some.url/v1/caches", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+
+		var results struct {
+			Size   int64 `json:"size"`
+			Caches []struct {
+				ID   string `json:"id"`
+				Size int64  `json:"size"`
+			} `json:"caches"`
+		}
+		Expect(t, json.Unmarshal(t.recorder.Body.Bytes(), &results)).To(BeNil())
+		Expect(t, results.Size).To(Equal(int64(27)))
+		Expect(t, results.Caches).To(HaveLen(3))
+	})
+
+	o.Spec("it lists the caches of a plan", func(t TC) {
+		req, err := http.NewRequest("GET", "http://some.url/v1/caches?plan=some-plan", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Body.String()).To(ContainSubstring(artifacts.CacheID("some-plan", "some-branch")))
+		Expect(t, t.recorder.Body.String()).To(Not(ContainSubstring(artifacts.CachePrefix("other-plan"))))
+	})
+
+	o.Spec("it purges the caches of a plan", func(t TC) {
+		req, err := http.NewRequest("DELETE", "http://some.url/v1/caches?plan=some-plan", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.store.Runs()).To(Equal([]string{artifacts.CacheID("other-plan", "some-branch")}))
+	})
+
+	o.Spec("it purges a single cache", func(t TC) {
+		id := artifacts.CacheID("some-plan", "some-branch")
+		req, err := http.NewRequest("DELETE", "http://some.url/v1/caches/"+id, nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Body.String()).To(MatchJSON(`{"removed": ["` + id + `"]}`))
+		Expect(t, t.store.Runs()).To(HaveLen(2))
+	})
+
+	o.Spec("it returns a 404 for an unknown cache", func(t TC) {
+		req, err := http.NewRequest("DELETE", "http://some.url/v1/caches/unknown", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	o.Spec("it won't purge everything at once", func(t TC) {
+		req, err := http.NewRequest("DELETE", "http://some.url/v1/caches", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(t, t.store.Runs()).To(HaveLen(3))
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type Configs struct {
+	l   ConfigLister
+	log *slog.Logger
+}
+
+type ConfigLister interface {
+	ConfigInfo() []metrics.ConfigInfo
+}
+
+type ConfigListerFunc func() []metrics.ConfigInfo
+
+func (f ConfigListerFunc) ConfigInfo() []metrics.ConfigInfo {
+	return f()
+}
+
+func NewConfigs(l ConfigLister, log *slog.Logger) http.Handler {
+	return &Configs{
+		l:   l,
+		log: log,
+	}
+}
+
+type configStatus struct {
+	SHA         string            `json:"sha"`
+	LastGoodSHA string            `json:"last_good_sha"`
+	Valid       bool              `json:"valid"`
+	Error       string            `json:"error,omitempty"`
+	File        string            `json:"file,omitempty"`
+	Line        int               `json:"line,omitempty"`
+	Plans       map[string]string `json:"plans"`
+}
+
+func (c *Configs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path != "/v1/configs" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var results struct {
+		Branches map[string]configStatus `json:"branches"`
+	}
+	results.Branches = make(map[string]configStatus)
+
+	for _, info := range c.l.ConfigInfo() {
+		results.Branches[info.Branch] = configStatus{
+			SHA:         info.SHA,
+			LastGoodSHA: info.LastGoodSHA,
+			Valid:       info.Error == "",
+			Error:       info.Error,
+			File:        info.File,
+			Line:        info.Line,
+			Plans:       info.Plans,
+		}
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		c.log.Error("failed to marshal results", "err", err)
+		panic(err)
+	}
+
+	w.Write(data)
+}
@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/handlers"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type TCF struct {
+	*testing.T
+	c        http.Handler
+	recorder *httptest.ResponseRecorder
+	results  []metrics.ConfigInfo
+}
+
+func TestConfigs(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) *TCF {
+		tc := &TCF{
+			T:        t,
+			recorder: httptest.NewRecorder(),
+		}
+		tc.c = handlers.NewConfigs(handlers.ConfigListerFunc(func() []metrics.ConfigInfo {
+			return tc.results
+		}), slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		return tc
+	})
+
+	o.Spec("it returns a 405 for anything other than a GET", func(t *TCF) {
+		req, err := http.NewRequest("PUT", "http://some.url/v1/configs", nil)
+		Expect(t, err).To(BeNil())
+		t.c.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	o.Spec("it returns a 404 for non /v1/configs", func(t *TCF) {
+		req, err := http.NewRequest("GET", "http://some.url/invalid", nil)
+		Expect(t, err).To(BeNil())
+		t.c.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	o.Spec("it returns the config status of each branch", func(t *TCF) {
+		t.results = []metrics.ConfigInfo{
+			{
+				Branch:      "some-branch",
+				SHA:         "sha-1",
+				LastGoodSHA: "sha-1",
+				Plans:       map[string]string{"some-plan": "pipelines/a.yml"},
+			},
+			{
+				Branch:      "some-other-branch",
+				SHA:         "sha-2",
+				LastGoodSHA: "sha-1",
+				Error:       "some-error",
+				File:        "pipelines/b.yml",
+				Line:        3,
+			},
+		}
+
+		req, err := http.NewRequest("GET", "http://some.url/v1/configs", nil)
+		Expect(t, err).To(BeNil())
+		t.c.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Body.String()).To(MatchJSON(`{
+			"branches": {
+				"some-branch": {
+					"sha": "sha-1",
+					"last_good_sha": "sha-1",
+					"valid": true,
+					"plans": {"some-plan": "pipelines/a.yml"}
+				},
+				"some-other-branch": {
+					"sha": "sha-2",
+					"last_good_sha": "sha-1",
+					"valid": false,
+					"error": "some-error",
+					"file": "pipelines/b.yml",
+					"line": 3,
+					"plans": null
+				}
+			}
+		}`))
+	})
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/poy/triple-c/internal/capi"
+	"github.com/poy/triple-c/internal/git"
+)
+
+// Health serves /healthz and /readyz. /healthz fails when triple-c itself is
+// stuck (the branch watcher has stopped trying), while /readyz also fails
+// when what it depends on is (a repo can't be fetched, the branches can't be
+// listed or a token can't be fetched).
+type Health struct {
+	repos    FetchStatuser
+	branches WatcherStatuser
+	tokens   TokenStatuser
+
+	maxFetchAge  time.Duration
+	maxBranchAge time.Duration
+	log          *slog.Logger
+}
+
+// FetchStatuser reports how fetching each repo has gone.
+type FetchStatuser interface {
+	FetchStatus() map[string]git.Status
+}
+
+// WatcherStatuser reports how watching the branches has gone.
+type WatcherStatuser interface {
+	Status() git.Status
+}
+
+// TokenStatuser reports how fetching CAPI tokens has gone.
+type TokenStatuser interface {
+	TokenStatus() capi.TokenStatus
+}
+
+// NewHealth returns a Health. A repo is stale once it hasn't been fetched
+// for maxFetchAge and the branch watcher once it hasn't listed the branches
+// for maxBranchAge. tokens may be nil when CAPI isn't used.
+func NewHealth(
+	repos FetchStatuser,
+	branches WatcherStatuser,
+	tokens TokenStatuser,
+	maxFetchAge time.Duration,
+	maxBranchAge time.Duration,
+	log *slog.Logger,
+) http.Handler {
+	return &Health{
+		repos:        repos,
+		branches:     branches,
+		tokens:       tokens,
+		maxFetchAge:  maxFetchAge,
+		maxBranchAge: maxBranchAge,
+		log:          log,
+	}
+}
+
+type healthCheck struct {
+	Name   string `json:"name"`
+	Repo   string `json:"repo,omitempty"`
+	Status string `json:"status"`
+
+	// Last is when whatever is checked last happened (e.g., a successful
+	// fetch) and AgeSeconds is how long ago that was.
+	Last       *time.Time `json:"last,omitempty"`
+	AgeSeconds *float64   `json:"age_seconds,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var checks []healthCheck
+	switch r.URL.Path {
+	case "/healthz":
+		checks = []healthCheck{h.watcherLiveness()}
+	case "/readyz":
+		checks = append(h.repoChecks(), h.watcherReadiness())
+		if h.tokens != nil {
+			checks = append(checks, h.tokenCheck())
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	results := struct {
+		Status string        `json:"status"`
+		Checks []healthCheck `json:"checks"`
+	}{
+		Status: "ok",
+		Checks: checks,
+	}
+
+	for _, c := range checks {
+		if c.Status != "ok" {
+			results.Status = "failing"
+		}
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		h.log.Error("failed to marshal results", "err", err)
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if results.Status != "ok" {
+		h.log.Warn("health check failing", "path", r.URL.Path, "checks", string(data))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(data)
+}
+
+func (h *Health) repoChecks() []healthCheck {
+	statuses := h.repos.FetchStatus()
+
+	var repos []string
+	for repo := range statuses {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	var checks []healthCheck
+	for _, repo := range repos {
+		s := statuses[repo]
+		c := newHealthCheck("repo_fetch", s.LastSuccess, s.Err, h.maxFetchAge)
+		c.Repo = repo
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// watcherLiveness only fails when the branch watcher has stopped trying to
+// list the branches, not when it is failing to.
+func (h *Health) watcherLiveness() healthCheck {
+	s := h.branches.Status()
+	c := newHealthCheck("branch_watcher", s.LastAttempt, nil, h.maxBranchAge)
+	if s.Err != nil {
+		c.Error = s.Err.Error()
+	}
+	return c
+}
+
+func (h *Health) watcherReadiness() healthCheck {
+	s := h.branches.Status()
+	return newHealthCheck("branch_watcher", s.LastSuccess, s.Err, h.maxBranchAge)
+}
+
+// tokenCheck fails when the last token fetch failed. Tokens are only fetched
+// when they're rejected, so how long ago one was fetched doesn't matter.
+func (h *Health) tokenCheck() healthCheck {
+	s := h.tokens.TokenStatus()
+	c := newHealthCheck("capi_token", s.LastSuccess, s.Err, 0)
+	if s.Err != nil {
+		c.Status = "failing"
+	}
+	return c
+}
+
+// newHealthCheck returns a check that fails if last is zero or older than
+// maxAge. A maxAge of 0 disables the age limit.
+func newHealthCheck(name string, last time.Time, err error, maxAge time.Duration) healthCheck {
+	c := healthCheck{
+		Name:   name,
+		Status: "ok",
+	}
+
+	if err != nil {
+		c.Error = err.Error()
+	}
+
+	if last.IsZero() {
+		if maxAge > 0 {
+			c.Status = "failing"
+		}
+		return c
+	}
+
+	age := time.Since(last)
+	ageSeconds := age.Seconds()
+	c.Last = &last
+	c.AgeSeconds = &ageSeconds
+
+	if maxAge > 0 && age > maxAge {
+		c.Status = "failing"
+	}
+
+	return c
+}
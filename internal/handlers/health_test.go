@@ -0,0 +1,198 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/capi"
+	"github.com/poy/triple-c/internal/git"
+	"github.com/poy/triple-c/internal/handlers"
+)
+
+type TH struct {
+	*testing.T
+	h          http.Handler
+	recorder   *httptest.ResponseRecorder
+	spyRepos   *spyFetchStatuser
+	spyWatcher *spyWatcherStatuser
+	spyTokens  *spyTokenStatuser
+}
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TH {
+		now := time.Now()
+		spyRepos := &spyFetchStatuser{
+			statuses: map[string]git.Status{
+				"some-repo": {LastAttempt: now, LastSuccess: now},
+			},
+		}
+		spyWatcher := &spyWatcherStatuser{
+			status: git.Status{LastAttempt: now, LastSuccess: now},
+		}
+		spyTokens := &spyTokenStatuser{
+			status: capi.TokenStatus{LastFetch: now, LastSuccess: now},
+		}
+
+		return TH{
+			T:          t,
+			h:          handlers.NewHealth(spyRepos, spyWatcher, spyTokens, time.Minute, time.Minute, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+			recorder:   httptest.NewRecorder(),
+			spyRepos:   spyRepos,
+			spyWatcher: spyWatcher,
+			spyTokens:  spyTokens,
+		}
+	})
+
+	get := func(t TH, path string) healthResults {
+		req, err := http.NewRequest("GET", "http://some.url"+path, nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		var results healthResults
+		Expect(t, json.Unmarshal(t.recorder.Body.Bytes(), &results)).To(BeNil())
+		return results
+	}
+
+	o.Spec("it returns a 405 for anything other than a GET", func(t TH) {
+		req, err := http.NewRequest("PUT", "http://some.url/healthz", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	o.Spec("it returns a 404 for anything other than /healthz and /readyz", func(t TH) {
+		req, err := http.NewRequest("GET", "http://some.url/invalid", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	o.Spec("it is ready when everything is fresh", func(t TH) {
+		results := get(t, "/readyz")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, results.Status).To(Equal("ok"))
+		Expect(t, results.Checks).To(HaveLen(3))
+		Expect(t, results.Checks[0].Name).To(Equal("repo_fetch"))
+		Expect(t, results.Checks[0].Repo).To(Equal("some-repo"))
+		Expect(t, results.Checks[0].Status).To(Equal("ok"))
+		Expect(t, results.Checks[0].AgeSeconds < 60).To(BeTrue())
+		Expect(t, results.Checks[1].Name).To(Equal("branch_watcher"))
+		Expect(t, results.Checks[2].Name).To(Equal("capi_token"))
+	})
+
+	o.Spec("it is not ready when a repo has not been fetched recently", func(t TH) {
+		t.spyRepos.statuses["some-other-repo"] = git.Status{
+			LastAttempt: time.Now(),
+			LastSuccess: time.Now().Add(-time.Hour),
+			Err:         errors.New("some-error"),
+		}
+		results := get(t, "/readyz")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(t, results.Status).To(Equal("failing"))
+		Expect(t, results.Checks[0].Repo).To(Equal("some-other-repo"))
+		Expect(t, results.Checks[0].Status).To(Equal("failing"))
+		Expect(t, results.Checks[0].Error).To(Equal("some-error"))
+		Expect(t, results.Checks[1].Status).To(Equal("ok"))
+	})
+
+	o.Spec("it is not ready when the branches have not been listed recently", func(t TH) {
+		t.spyWatcher.status.LastSuccess = time.Time{}
+		results := get(t, "/readyz")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(t, results.Checks[1].Status).To(Equal("failing"))
+	})
+
+	o.Spec("it is not ready when fetching a token failed", func(t TH) {
+		t.spyTokens.status.Err = errors.New("some-error")
+		results := get(t, "/readyz")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(t, results.Checks[2].Status).To(Equal("failing"))
+		Expect(t, results.Checks[2].Error).To(Equal("some-error"))
+	})
+
+	o.Spec("it does not check tokens without CAPI", func(t TH) {
+		h := handlers.NewHealth(t.spyRepos, t.spyWatcher, nil, time.Minute, time.Minute, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		req, err := http.NewRequest("GET", "http://some.url/readyz", nil)
+		Expect(t, err).To(BeNil())
+		h.ServeHTTP(t.recorder, req)
+
+		var results healthResults
+		Expect(t, json.Unmarshal(t.recorder.Body.Bytes(), &results)).To(BeNil())
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, results.Checks).To(HaveLen(2))
+	})
+
+	o.Spec("it is alive while the branch watcher keeps trying", func(t TH) {
+		t.spyWatcher.status.LastSuccess = time.Time{}
+		t.spyWatcher.status.Err = errors.New("some-error")
+		t.spyRepos.statuses["some-repo"] = git.Status{}
+		results := get(t, "/healthz")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, results.Checks).To(HaveLen(1))
+		Expect(t, results.Checks[0].Name).To(Equal("branch_watcher"))
+		Expect(t, results.Checks[0].Error).To(Equal("some-error"))
+	})
+
+	o.Spec("it is not alive when the branch watcher has stopped", func(t TH) {
+		t.spyWatcher.status.LastAttempt = time.Now().Add(-time.Hour)
+		results := get(t, "/healthz")
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(t, results.Status).To(Equal("failing"))
+	})
+}
+
+type healthResults struct {
+	Status string `json:"status"`
+	Checks []struct {
+		Name       string  `json:"name"`
+		Repo       string  `json:"repo"`
+		Status     string  `json:"status"`
+		AgeSeconds float64 `json:"age_seconds"`
+		Error      string  `json:"error"`
+	} `json:"checks"`
+}
+
+type spyFetchStatuser struct {
+	statuses map[string]git.Status
+}
+
+func (s *spyFetchStatuser) FetchStatus() map[string]git.Status {
+	return s.statuses
+}
+
+type spyWatcherStatuser struct {
+	status git.Status
+}
+
+func (s *spyWatcherStatuser) Status() git.Status {
+	return s.status
+}
+
+type spyTokenStatuser struct {
+	status capi.TokenStatus
+}
+
+func (s *spyTokenStatuser) TokenStatus() capi.TokenStatus {
+	return s.status
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig describes which JWTs a JWTAuthenticator accepts.
+type JWTConfig struct {
+	// JWKSURL is where the signing keys are published (e.g., UAA's
+	// /token_keys).
+	JWKSURL string
+
+	// Issuer and Audience are the iss and aud claims tokens must have.
+	// Either is ignored when empty.
+	Issuer   string
+	Audience string
+
+	// ScopePrefix is removed from the token's scopes to find its Scopes
+	// (e.g., triple-c.admin is ScopeAdmin with the prefix "triple-c.").
+	// Scopes without it are ignored.
+	ScopePrefix string
+
+	// KeysMaxAge is how long keys are used before being fetched again.
+	// They are also fetched (at most once a minute) when a token is signed
+	// by a key that isn't known.
+	KeysMaxAge time.Duration
+}
+
+// Doer sends HTTP requests. *http.Client is a Doer.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// JWTAuthenticator authenticates OAuth2 access tokens (e.g., from UAA) that
+// are JWTs signed with RSA.
+type JWTAuthenticator struct {
+	cfg JWTConfig
+	d   Doer
+	log *slog.Logger
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+
+	// fetching is closed once the keys being fetched, if any, are.
+	fetching chan struct{}
+}
+
+const (
+	// jwtLeeway is how far clocks may drift when checking exp and nbf.
+	jwtLeeway = 30 * time.Second
+
+	// minKeysRefetch is how often unknown keys may cause the keys to be
+	// fetched again.
+	minKeysRefetch = time.Minute
+
+	// keysFetchTimeout is how long fetching the keys may take.
+	keysFetchTimeout = 30 * time.Second
+
+	// minRSABits is the size of the smallest key that is trusted.
+	minRSABits = 2048
+)
+
+// NewJWTAuthenticator returns a JWTAuthenticator that fetches keys with d.
+// Keys are fetched when they're first needed.
+func NewJWTAuthenticator(cfg JWTConfig, d Doer, log *slog.Logger) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		cfg: cfg,
+		d:   d,
+		log: log,
+	}
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// Authenticate returns ErrUnknownToken for anything that isn't a JWT.
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, ErrUnknownToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return Identity{}, fmt.Errorf("invalid header: %s", err)
+	}
+
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return Identity{}, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid signature: %s", err)
+	}
+
+	key, err := a.key(ctx, header.Kid)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), sig); err != nil {
+		return Identity{}, errors.New("invalid signature")
+	}
+
+	var claims struct {
+		Sub      string      `json:"sub"`
+		ClientID string      `json:"client_id"`
+		Iss      string      `json:"iss"`
+		Aud      stringOrArr `json:"aud"`
+		Exp      *float64    `json:"exp"`
+		Nbf      *float64    `json:"nbf"`
+		Scope    stringOrArr `json:"scope"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return Identity{}, fmt.Errorf("invalid claims: %s", err)
+	}
+
+	now := time.Now()
+	if claims.Exp == nil || now.After(unixTime(*claims.Exp).Add(jwtLeeway)) {
+		return Identity{}, errors.New("token has expired")
+	}
+
+	if claims.Nbf != nil && now.Add(jwtLeeway).Before(unixTime(*claims.Nbf)) {
+		return Identity{}, errors.New("token is not valid yet")
+	}
+
+	if a.cfg.Issuer != "" && claims.Iss != a.cfg.Issuer {
+		return Identity{}, fmt.Errorf("unexpected issuer %q", claims.Iss)
+	}
+
+	if a.cfg.Audience != "" && !claims.Aud.contains(a.cfg.Audience) {
+		return Identity{}, fmt.Errorf("token is not for %q", a.cfg.Audience)
+	}
+
+	id := Identity{Subject: claims.Sub}
+	if id.Subject == "" {
+		id.Subject = claims.ClientID
+	}
+
+	for _, s := range claims.Scope {
+		if !strings.HasPrefix(s, a.cfg.ScopePrefix) {
+			continue
+		}
+
+		if scope, err := ParseScope(strings.TrimPrefix(s, a.cfg.ScopePrefix)); err == nil {
+			id.Scopes = append(id.Scopes, scope)
+		}
+	}
+
+	return id, nil
+}
+
+// key returns the key with the ID, fetching the keys if they're old or
+// don't include it. Old keys are used while newer ones are fetched. A token
+// without a key ID may only be signed by the only key.
+func (a *JWTAuthenticator) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	key, ok := a.findKey(kid)
+	sinceFetch := time.Since(a.fetched)
+
+	// A key that isn't known may be in the keys being fetched.
+	done := a.fetching
+	if (!ok && sinceFetch > minKeysRefetch) || (a.cfg.KeysMaxAge > 0 && sinceFetch > a.cfg.KeysMaxAge) {
+		done = a.refresh()
+	}
+	a.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		a.mu.Lock()
+		key, ok = a.findKey(kid)
+		a.mu.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+// refresh starts fetching the keys, unless they're already being fetched,
+// and returns a channel that is closed once they have been. a.mu must be
+// held.
+func (a *JWTAuthenticator) refresh() chan struct{} {
+	if a.fetching != nil {
+		return a.fetching
+	}
+
+	a.fetched = time.Now()
+	done := make(chan struct{})
+	a.fetching = done
+
+	go func() {
+		// The keys outlive the request that needed them.
+		ctx, cancel := context.WithTimeout(context.Background(), keysFetchTimeout)
+		defer cancel()
+		keys, err := a.fetchKeys(ctx)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if err != nil {
+			// Keep using the keys already fetched.
+			a.log.Error("failed to fetch JWKS", "url", a.cfg.JWKSURL, "err", err)
+		} else {
+			a.keys = keys
+		}
+		a.fetching = nil
+		close(done)
+	}()
+
+	return done
+}
+
+func (a *JWTAuthenticator) findKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+func (a *JWTAuthenticator) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.d.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %s", k.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid key %q: bad exponent", k.Kid)
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < minRSABits {
+			a.log.Warn("ignoring JWKS key that is too small", "kid", k.Kid, "bits", key.N.BitLen())
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// stringOrArr is a claim that may be either a list or a single string.
+// Strings are split on spaces (e.g., "triple-c.read triple-c.trigger").
+type stringOrArr []string
+
+func (s *stringOrArr) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = strings.Fields(str)
+		return nil
+	}
+
+	var arr []string
+	if err := json.Unmarshal(data, &arr); err != nil {
+		return err
+	}
+	*s = arr
+	return nil
+}
+
+func (s stringOrArr) contains(v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
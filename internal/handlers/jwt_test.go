@@ -0,0 +1,299 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/handlers"
+)
+
+type TJ struct {
+	*testing.T
+	a      *handlers.JWTAuthenticator
+	jwks   *jwksServer
+	signer *jwtSigner
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	signer := newJWTSigner(t, "some-key")
+	otherSigner := newJWTSigner(t, "other-key")
+
+	o.BeforeEach(func(t *testing.T) TJ {
+		jwks := &jwksServer{}
+		jwks.setKeys(signer)
+		jwks.Server = httptest.NewServer(jwks)
+
+		return TJ{
+			T: t,
+			a: handlers.NewJWTAuthenticator(handlers.JWTConfig{
+				JWKSURL:     jwks.URL,
+				Issuer:      "https://uaa.some.url/oauth/token",
+				Audience:    "triple-c",
+				ScopePrefix: "triple-c.",
+				KeysMaxAge:  time.Hour,
+			}, http.DefaultClient, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+			jwks:   jwks,
+			signer: signer,
+		}
+	})
+
+	o.AfterEach(func(t TJ) {
+		t.jwks.Close()
+	})
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "some-user",
+			"iss":   "https://uaa.some.url/oauth/token",
+			"aud":   []string{"triple-c", "cloud_controller"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": []string{"triple-c.trigger", "triple-c.unknown", "cloud_controller.read"},
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	o.Spec("it authenticates tokens signed by a published key", func(t TJ) {
+		id, err := t.a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(BeNil())
+		Expect(t, id.Subject).To(Equal("some-user"))
+		Expect(t, id.Scopes).To(Equal([]handlers.Scope{handlers.ScopeTrigger}))
+	})
+
+	o.Spec("it accepts scopes and audiences as strings", func(t TJ) {
+		id, err := t.a.Authenticate(context.Background(), t.signer.sign(t, "RS512", claims(map[string]interface{}{
+			"aud":       "triple-c",
+			"scope":     "triple-c.read triple-c.admin",
+			"sub":       nil,
+			"client_id": "some-client",
+		})))
+		Expect(t, err).To(BeNil())
+		Expect(t, id.Subject).To(Equal("some-client"))
+		Expect(t, id.Scopes).To(Equal([]handlers.Scope{handlers.ScopeRead, handlers.ScopeAdmin}))
+	})
+
+	o.Spec("it leaves tokens that are not JWTs to others", func(t TJ) {
+		_, err := t.a.Authenticate(context.Background(), "some-static-token")
+		Expect(t, err).To(Equal(handlers.ErrUnknownToken))
+	})
+
+	o.Spec("it rejects invalid tokens", func(t TJ) {
+		valid := t.signer.sign(t, "RS256", claims(nil))
+		parts := strings.Split(valid, ".")
+
+		for _, token := range map[string]string{
+			"tampered":      parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2],
+			"unsigned":      base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"some-key"}`)) + "." + parts[1] + ".",
+			"unknown key":   otherSigner.sign(t, "RS256", claims(nil)),
+			"expired":       t.signer.sign(t, "RS256", claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+			"no expiry":     t.signer.sign(t, "RS256", claims(map[string]interface{}{"exp": nil})),
+			"not yet valid": t.signer.sign(t, "RS256", claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+			"wrong issuer":  t.signer.sign(t, "RS256", claims(map[string]interface{}{"iss": "https://some.other.url"})),
+			"wrong aud":     t.signer.sign(t, "RS256", claims(map[string]interface{}{"aud": "cloud_controller"})),
+			"garbage":       "a.b.c",
+		} {
+			_, err := t.a.Authenticate(context.Background(), token)
+			Expect(t, err).To(Not(BeNil()))
+			Expect(t, err == handlers.ErrUnknownToken).To(BeFalse())
+		}
+	})
+
+	o.Spec("it fetches the keys when they are first needed", func(t TJ) {
+		Expect(t, t.jwks.Requests()).To(Equal(0))
+
+		for i := 0; i < 2; i++ {
+			_, err := t.a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+			Expect(t, err).To(BeNil())
+		}
+		Expect(t, t.jwks.Requests()).To(Equal(1))
+	})
+
+	o.Spec("it does not fetch the keys for every unknown key", func(t TJ) {
+		for i := 0; i < 2; i++ {
+			_, err := t.a.Authenticate(context.Background(), otherSigner.sign(t, "RS256", claims(nil)))
+			Expect(t, err).To(Not(BeNil()))
+		}
+		Expect(t, t.jwks.Requests()).To(Equal(1))
+	})
+
+	o.Spec("it fetches the keys once for concurrent requests", func(t TJ) {
+		t.jwks.setDelay(100 * time.Millisecond)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := t.a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			Expect(t, err).To(BeNil())
+		}
+		Expect(t, t.jwks.Requests()).To(Equal(1))
+	})
+
+	o.Spec("it uses the keys it has while fetching newer ones", func(t TJ) {
+		a := handlers.NewJWTAuthenticator(handlers.JWTConfig{
+			JWKSURL:    t.jwks.URL,
+			KeysMaxAge: time.Nanosecond,
+		}, http.DefaultClient, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		_, err := a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(BeNil())
+
+		t.jwks.setDelay(time.Second)
+		start := time.Now()
+		_, err = a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(BeNil())
+		Expect(t, time.Since(start) < 500*time.Millisecond).To(BeTrue())
+	})
+
+	o.Spec("it ignores keys smaller than 2048 bits", func(t TJ) {
+		small := newJWTSignerWithBits(t.T, "small-key", 1024)
+		t.jwks.setKeys(t.signer, small)
+
+		_, err := t.a.Authenticate(context.Background(), small.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(Not(BeNil()))
+
+		_, err = t.a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(BeNil())
+	})
+
+	o.Spec("it uses the keys it has when the JWKS can't be fetched", func(t TJ) {
+		a := handlers.NewJWTAuthenticator(handlers.JWTConfig{
+			JWKSURL:    t.jwks.URL,
+			KeysMaxAge: time.Nanosecond,
+		}, http.DefaultClient, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		_, err := a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(BeNil())
+
+		t.jwks.Close()
+		_, err = a.Authenticate(context.Background(), t.signer.sign(t, "RS256", claims(nil)))
+		Expect(t, err).To(BeNil())
+	})
+}
+
+// jwtSigner signs JWTs with a key that is published by a jwksServer.
+type jwtSigner struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+func newJWTSigner(t *testing.T, kid string) *jwtSigner {
+	return newJWTSignerWithBits(t, kid, 2048)
+}
+
+func newJWTSignerWithBits(t *testing.T, kid string, bits int) *jwtSigner {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jwtSigner{kid: kid, key: key}
+}
+
+func (s *jwtSigner) sign(t TJ, alg string, claims map[string]interface{}) string {
+	hash := map[string]crypto.Hash{"RS256": crypto.SHA256, "RS512": crypto.SHA512}[alg]
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": s.kid, "typ": "JWT"})
+	Expect(t, err).To(BeNil())
+	payload, err := json.Marshal(claims)
+	Expect(t, err).To(BeNil())
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := hash.New()
+	h.Write([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, h.Sum(nil))
+	Expect(t, err).To(BeNil())
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+type jwksServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     []byte
+	requests int
+	delay    time.Duration
+}
+
+func (s *jwksServer) setKeys(signers ...*jwtSigner) {
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, signer := range signers {
+		jwks.Keys = append(jwks.Keys, map[string]string{
+			"kty": "RSA",
+			"kid": signer.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(signer.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signer.key.E)).Bytes()),
+		})
+	}
+
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = data
+}
+
+func (s *jwksServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *jwksServer) setDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	keys, delay := s.keys, s.delay
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+	fmt.Fprintf(w, "%s", keys)
+}
@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/poy/triple-c/internal/metrics"
@@ -10,7 +10,7 @@ import (
 
 type Repos struct {
 	l   RepoLister
-	log *log.Logger
+	log *slog.Logger
 }
 
 type RepoLister interface {
@@ -23,7 +23,7 @@ func (f RepoListerFunc) RepoInfo() []metrics.RepoInfo {
 	return f()
 }
 
-func NewRepos(l RepoLister, log *log.Logger) http.Handler {
+func NewRepos(l RepoLister, log *slog.Logger) http.Handler {
 	return &Repos{
 		l:   l,
 		log: log,
@@ -69,7 +69,8 @@ func (b *Repos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	data, err := json.Marshal(results)
 	if err != nil {
-		b.log.Panicf("failed to marshal results: %s", err)
+		b.log.Error("failed to marshal results", "err", err)
+		panic(err)
 	}
 
 	w.Write(data)
@@ -2,7 +2,7 @@ package handlers_test
 
 import (
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -30,7 +30,7 @@ func TestRepos(t *testing.T) {
 		spyRepoLister := newSpyRepoLister()
 		return TB{
 			T:             t,
-			b:             handlers.NewRepos(spyRepoLister, log.New(ioutil.Discard, "", 0)),
+			b:             handlers.NewRepos(spyRepoLister, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
 			recorder:      httptest.NewRecorder(),
 			spyRepoLister: spyRepoLister,
 		}
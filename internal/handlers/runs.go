@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type Runs struct {
+	h   RunLister
+	a   ArtifactLister
+	log *slog.Logger
+}
+
+type RunLister interface {
+	Runs() []metrics.Run
+	Run(id string) (metrics.Run, bool)
+}
+
+// ArtifactLister reads the artifacts of runs.
+type ArtifactLister interface {
+	List(runID string) []artifacts.Artifact
+	Open(runID, name string) (io.ReadCloser, artifacts.Artifact, error)
+}
+
+func NewRuns(h RunLister, a ArtifactLister, log *slog.Logger) http.Handler {
+	return &Runs{
+		h:   h,
+		a:   a,
+		log: log,
+	}
+}
+
+type runResult struct {
+	ID        string       `json:"id"`
+	Plan      string       `json:"plan"`
+	Branch    string       `json:"branch"`
+	Repo      string       `json:"repo"`
+	SHA       string       `json:"sha"`
+	Tag       string       `json:"tag,omitempty"`
+	ConfigSHA string       `json:"config_sha"`
+	Matrix    string       `json:"matrix,omitempty"`
+	Status    string       `json:"status"`
+	Reason    string       `json:"reason,omitempty"`
+	Started   time.Time    `json:"started"`
+	Finished  *time.Time   `json:"finished,omitempty"`
+	Tasks     []taskResult `json:"tasks"`
+
+	Artifacts []artifactResult `json:"artifacts"`
+}
+
+type artifactResult struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type taskResult struct {
+	Index    int        `json:"index"`
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func (h *Runs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path == "/v1/runs" {
+		var results struct {
+			Runs []runResult `json:"runs"`
+		}
+		results.Runs = []runResult{}
+
+		for _, run := range h.h.Runs() {
+			results.Runs = append(results.Runs, h.toRunResult(run))
+		}
+
+		h.write(w, results)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/v1/runs/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	parts := strings.Split(r.URL.Path[len("/v1/runs/"):], "/")
+	switch {
+	case len(parts) == 1:
+		run, ok := h.h.Run(parts[0])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		h.write(w, h.toRunResult(run))
+	case len(parts) == 2 && parts[1] == "artifacts":
+		// Artifacts outlive the run history, so the run need not be known.
+		var results struct {
+			Artifacts []artifactResult `json:"artifacts"`
+		}
+		results.Artifacts = h.artifacts(parts[0])
+
+		h.write(w, results)
+	case len(parts) == 3 && parts[1] == "artifacts":
+		h.download(w, r, parts[0], parts[2])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (h *Runs) download(w http.ResponseWriter, r *http.Request, runID, name string) {
+	f, a, err := h.a.Open(runID, name)
+	if err == artifacts.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log.Error("failed to open artifact", "run_id", runID, "artifact", name, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", a.Name))
+	serveArtifact(w, r, f, a)
+}
+
+func (h *Runs) artifacts(runID string) []artifactResult {
+	results := []artifactResult{}
+	for _, a := range h.a.List(runID) {
+		results = append(results, artifactResult{
+			Name:    a.Name,
+			Size:    a.Size,
+			SHA256:  a.SHA256,
+			Created: a.Created,
+		})
+	}
+
+	return results
+}
+
+func (h *Runs) write(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		h.log.Error("failed to marshal results", "err", err)
+		panic(err)
+	}
+
+	w.Write(data)
+}
+
+func (h *Runs) toRunResult(r metrics.Run) runResult {
+	result := runResult{
+		ID:        r.ID,
+		Plan:      r.Plan,
+		Branch:    r.Branch,
+		Repo:      r.Repo,
+		SHA:       r.SHA,
+		Tag:       r.Tag,
+		ConfigSHA: r.ConfigSHA,
+		Matrix:    r.Matrix,
+		Status:    r.Status,
+		Reason:    r.Reason,
+		Started:   r.Started,
+		Finished:  optionalTime(r.Finished),
+		Tasks:     []taskResult{},
+		Artifacts: h.artifacts(r.ID),
+	}
+
+	for _, t := range r.Tasks {
+		result.Tasks = append(result.Tasks, taskResult{
+			Index:    t.Index,
+			Name:     t.Name,
+			Status:   t.Status,
+			Started:  t.Started,
+			Finished: optionalTime(t.Finished),
+		})
+	}
+
+	return result
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
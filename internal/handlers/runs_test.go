@@ -0,0 +1,219 @@
+package handlers_test
+
+import (
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/handlers"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type TRN struct {
+	*testing.T
+	h            http.Handler
+	recorder     *httptest.ResponseRecorder
+	spyHistory   *spyRunLister
+	spyArtifacts *spyArtifactLister
+}
+
+func TestRuns(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TRN {
+		spyHistory := &spyRunLister{}
+		spyArtifacts := &spyArtifactLister{}
+		return TRN{
+			T:            t,
+			h:            handlers.NewRuns(spyHistory, spyArtifacts, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+			recorder:     httptest.NewRecorder(),
+			spyHistory:   spyHistory,
+			spyArtifacts: spyArtifacts,
+		}
+	})
+
+	o.Spec("it returns a 405 for anything other than a GET", func(t TRN) {
+		req, err := http.NewRequest("PUT", "http://some.url/v1/runs", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	o.Spec("it lists the runs", func(t TRN) {
+		started := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+		t.spyHistory.runs = []metrics.Run{
+			{
+				ID:      "some-id",
+				Plan:    "some-plan",
+				Branch:  "some-branch",
+				Repo:    "some-repo",
+				SHA:     "some-sha",
+				Status:  metrics.RunSkipped,
+				Reason:  "some-reason",
+				Started: started,
+			},
+		}
+
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Body.String()).To(MatchJSON(`{
+			"runs": [{
+				"id": "some-id",
+				"plan": "some-plan",
+				"branch": "some-branch",
+				"repo": "some-repo",
+				"sha": "some-sha",
+				"config_sha": "",
+				"status": "skipped",
+				"reason": "some-reason",
+				"started": "2018-06-01T00:00:00Z",
+				"tasks": [],
+				"artifacts": []
+			}]
+		}`))
+	})
+
+	o.Spec("it returns a single run", func(t TRN) {
+		t.spyHistory.runs = []metrics.Run{
+			{ID: "some-id", Plan: "some-plan"},
+		}
+
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs/some-id", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Body.String()).To(ContainSubstring(`"plan":"some-plan"`))
+	})
+
+	o.Spec("it lists the artifacts of a run", func(t TRN) {
+		t.spyArtifacts.artifacts = []artifacts.Artifact{
+			{
+				RunID:   "some-id",
+				Name:    "output",
+				Size:    9,
+				SHA256:  "9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1",
+				Created: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+			},
+		}
+
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs/some-id/artifacts", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.spyArtifacts.runID).To(Equal("some-id"))
+		Expect(t, t.recorder.Body.String()).To(MatchJSON(`{
+			"artifacts": [{
+				"name": "output",
+				"size": 9,
+				"sha256": "9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1",
+				"created": "2018-06-01T00:00:00Z"
+			}]
+		}`))
+	})
+
+	o.Spec("it downloads an artifact", func(t TRN) {
+		t.spyArtifacts.data = "some-data"
+		t.spyArtifacts.artifacts = []artifacts.Artifact{
+			{
+				RunID:  "some-id",
+				Name:   "output",
+				Size:   9,
+				SHA256: "9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1",
+			},
+		}
+
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs/some-id/artifacts/output", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Body.String()).To(Equal("some-data"))
+		Expect(t, t.recorder.Header().Get("Content-Length")).To(Equal("9"))
+		Expect(t, t.recorder.Header().Get("Digest")).To(Equal("SHA-256=kzLZTV7mmtF9MQ5izRAdcPV4Ak/V6NFkf4Bz+IbIlOE="))
+	})
+
+	o.Spec("it omits the Digest when the checksum is unknown", func(t TRN) {
+		t.spyArtifacts.data = "some-data"
+		t.spyArtifacts.artifacts = []artifacts.Artifact{
+			{RunID: "some-id", Name: "output", Size: 9},
+		}
+
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs/some-id/artifacts/output", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		_, ok := t.recorder.Header()["Digest"]
+		Expect(t, ok).To(BeFalse())
+	})
+
+	o.Spec("it returns a 404 for an unknown artifact", func(t TRN) {
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs/some-id/artifacts/unknown", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	o.Spec("it returns a 404 for an unknown run", func(t TRN) {
+		req, err := http.NewRequest("GET", "http://some.url/v1/runs/unknown", nil)
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+	})
+}
+
+type spyRunLister struct {
+	runs []metrics.Run
+}
+
+func (s *spyRunLister) Runs() []metrics.Run {
+	return s.runs
+}
+
+func (s *spyRunLister) Run(id string) (metrics.Run, bool) {
+	for _, r := range s.runs {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return metrics.Run{}, false
+}
+
+type spyArtifactLister struct {
+	runID     string
+	artifacts []artifacts.Artifact
+	data      string
+}
+
+func (s *spyArtifactLister) List(runID string) []artifacts.Artifact {
+	s.runID = runID
+	return s.artifacts
+}
+
+func (s *spyArtifactLister) Open(runID, name string) (io.ReadCloser, artifacts.Artifact, error) {
+	for _, a := range s.artifacts {
+		if a.RunID == runID && a.Name == name {
+			return ioutil.NopCloser(strings.NewReader(s.data)), a, nil
+		}
+	}
+	return nil, artifacts.Artifact{}, artifacts.ErrNotFound
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// spool keeps a copy of an upload in progress so that any number of
+// downloads can stream it while it is written. The file is unlinked as soon
+// as it is created and closed once the upload and every reader are done.
+type spool struct {
+	f *os.File
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	size int64
+	done bool
+	err  error
+	refs int
+}
+
+func newSpool() (*spool, error) {
+	f, err := ioutil.TempFile("", "triple-c-transfer-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+
+	s := &spool{f: f, refs: 1}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Write appends to the spool and wakes any waiting readers.
+func (s *spool) Write(p []byte) (int, error) {
+	n, err := s.f.Write(p)
+
+	s.mu.Lock()
+	s.size += int64(n)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+
+	return n, err
+}
+
+// finish marks the upload as done. Readers that reach the end get err, or
+// io.EOF if it is nil.
+func (s *spool) finish(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+
+	s.mu.Lock()
+	s.done = true
+	s.err = err
+	s.mu.Unlock()
+	s.cond.Broadcast()
+
+	s.release()
+}
+
+// reader returns a reader that follows the spool from the start until the
+// upload is finished or ctx is done. It must be closed.
+func (s *spool) reader(ctx context.Context) io.ReadCloser {
+	s.mu.Lock()
+	s.refs++
+	s.mu.Unlock()
+
+	// The lock is taken before waking readers so that a reader can't miss
+	// the broadcast between checking ctx and waiting.
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+
+	return &spoolReader{s: s, ctx: ctx, stop: stop}
+}
+
+func (s *spool) release() {
+	s.mu.Lock()
+	s.refs--
+	closeFile := s.refs == 0
+	s.mu.Unlock()
+
+	if closeFile {
+		s.f.Close()
+	}
+}
+
+type spoolReader struct {
+	s      *spool
+	ctx    context.Context
+	stop   func() bool
+	off    int64
+	closed bool
+}
+
+func (r *spoolReader) Read(p []byte) (int, error) {
+	s := r.s
+
+	s.mu.Lock()
+	for r.off >= s.size && !s.done && r.ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	size, err := s.size, s.err
+	s.mu.Unlock()
+
+	if cerr := r.ctx.Err(); cerr != nil {
+		return 0, cerr
+	}
+
+	if r.off >= size {
+		return 0, err
+	}
+
+	if int64(len(p)) > size-r.off {
+		p = p[:size-r.off]
+	}
+
+	n, rerr := s.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	if rerr == io.EOF {
+		rerr = nil
+	}
+	return n, rerr
+}
+
+func (r *spoolReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.stop()
+		r.s.release()
+	}
+	return nil
+}
@@ -1,125 +1,525 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/poy/triple-c/internal/artifacts"
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 type Transfer struct {
-	mu      sync.RWMutex
-	m       map[string]transferInfo
-	dataDir string
-	host    string
-	log     *log.Logger
+	mu           sync.RWMutex
+	m            map[string]*transfer
+	store        ArtifactStore
+	caches       ArtifactStore
+	host         string
+	wait         time.Duration
+	maxCacheSize int64
+	tracer       *tracing.Tracer
+	log          *slog.Logger
+}
+
+// ArtifactStore keeps the outputs of runs.
+type ArtifactStore interface {
+	Put(runID, name string, r io.Reader) (artifacts.Artifact, error)
+	Open(runID, name string) (io.ReadCloser, artifacts.Artifact, error)
+}
+
+// Presigner is implemented by ArtifactStores that tasks can upload to and
+// download from directly.
+type Presigner interface {
+	PresignUpload(runID, name string) (string, error)
+	PresignDownload(runID, name string) (string, error)
 }
 
-type transferInfo struct {
-	c   chan []byte
-	ctx context.Context
+// transfer is an artifact handed out by InitInterconnect or InitCache.
+// Downloads must present the read token and uploads the write token. Only
+// one upload is accepted.
+type transfer struct {
+	ctx      context.Context
+	store    ArtifactStore
+	runID    string
+	name     string
+	read     string
+	write    string
+	uploaded bool
+
+	// wait is how long a download waits for the upload to start.
+	wait time.Duration
+
+	// limit is the largest upload accepted, if positive.
+	limit int64
+
+	// spool is set while an upload is in progress.
+	spool *spool
+
+	// changed is closed (and replaced) whenever an upload starts or ends.
+	changed chan struct{}
+
+	// attrs are the run and artifact, or the cache, the transfer is for.
+	// They are added to its log and spans.
+	attrs []interface{}
+	log   *slog.Logger
 }
 
-func NewTransfer(host, dataDir string, log *log.Logger) *Transfer {
+var errDigestMismatch = errors.New("upload does not match its digest")
+
+// cacheName is what each cache is called within its run in the caches
+// store.
+const cacheName = "cache"
+
+// NewTransfer returns a Transfer that keeps artifacts in store and caches
+// in caches. A download that arrives before the artifact is uploaded waits
+// up to wait for the upload to start. Caches larger than maxCacheSize are
+// rejected. Each upload and download is a span, whose parent is the
+// request's traceparent header or else the run the transfer belongs to.
+func NewTransfer(host string, store, caches ArtifactStore, wait time.Duration, maxCacheSize int64, tracer *tracing.Tracer, log *slog.Logger) *Transfer {
 	return &Transfer{
-		m:       make(map[string]transferInfo),
-		host:    host,
-		dataDir: dataDir,
-		log:     log,
+		m:            make(map[string]*transfer),
+		host:         host,
+		store:        store,
+		caches:       caches,
+		wait:         wait,
+		maxCacheSize: maxCacheSize,
+		tracer:       tracer,
+		log:          log,
 	}
 }
 
 func (t *Transfer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
+	if !strings.HasPrefix(r.URL.Path, "/v1/transfer/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	key := r.URL.Path[len("/v1/transfer/"):]
+
 	t.mu.RLock()
-	defer t.mu.RUnlock()
+	tr, ok := t.m[key]
+	t.mu.RUnlock()
 
-	if !strings.HasPrefix(r.URL.Path, "/v1/transfer/") {
+	if !ok || tr.ctx.Err() != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	name := r.URL.Path[len("/v1/transfer/"):]
-	ctx := r.Context()
+	token := r.URL.Query().Get("token")
 
 	switch r.Method {
-	case http.MethodGet:
-		c, ok := t.m[name]
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
+	case http.MethodGet, http.MethodHead:
+		if !validToken(token, tr.read) {
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		f, err := os.Open(path.Join(t.dataDir, name))
-		if err != nil {
-			t.log.Printf("failed to create file to transfer data: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		sw, span := t.startSpan(w, r, tr, "transfer.download")
+		defer sw.end(span)
+		t.download(sw, r, tr)
+
+	case http.MethodPut, http.MethodPost:
+		if !validToken(token, tr.write) {
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
-		defer f.Close()
 
-		select {
-		case <-ctx.Done():
-			return
-		case <-c.ctx.Done():
+		sw, span := t.startSpan(w, r, tr, "transfer.upload")
+		defer sw.end(span)
+		t.upload(sw, r, tr)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+// startSpan starts the span of a request to the transfer, and returns a
+// ResponseWriter that records the status the span ends with.
+func (t *Transfer) startSpan(w http.ResponseWriter, r *http.Request, tr *transfer, name string) (*statusWriter, *tracing.Span) {
+	ctx := tr.ctx
+	if h := r.Header.Get("traceparent"); h != "" {
+		ctx = tracing.ContextWithTraceParent(ctx, h)
+	}
+
+	_, span := t.tracer.Start(ctx, name)
+	span.SetAttributes(tr.attrs...)
+	span.SetAttributes("http.method", r.Method)
+
+	return &statusWriter{ResponseWriter: w}, span
+}
+
+// download serves the artifact. While it is being uploaded it is streamed
+// as it arrives, unless a range is requested, in which case the upload is
+// waited for. If nothing has been uploaded yet, it waits for the upload to
+// start.
+func (t *Transfer) download(w http.ResponseWriter, r *http.Request, tr *transfer) {
+	timeout := time.NewTimer(tr.wait)
+	defer timeout.Stop()
+
+	for {
+		t.mu.RLock()
+		sp, changed := tr.spool, tr.changed
+		t.mu.RUnlock()
+
+		if sp != nil && r.Header.Get("Range") == "" {
+			t.stream(w, r, tr, sp)
 			return
-		default:
-			io.Copy(w, f)
 		}
 
-	case http.MethodPost:
-		c, ok := t.m[name]
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
-			return
+		if sp == nil {
+			f, a, err := tr.store.Open(tr.runID, tr.name)
+			if err == nil {
+				defer f.Close()
+				serveArtifact(w, r, f, a)
+				return
+			}
+
+			if err != artifacts.ErrNotFound {
+				tr.log.Error("failed to open artifact to transfer", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 		}
-		defer close(c.c)
 
-		f, err := os.Create(path.Join(t.dataDir, name))
-		if err != nil {
-			t.log.Printf("failed to create file to transfer data: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		select {
+		case <-changed:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case <-tr.ctx.Done():
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case <-r.Context().Done():
 			return
 		}
-		defer f.Close()
+	}
+}
 
-		_, err = io.Copy(f, r.Body)
-		if err != nil {
-			log.Printf("failed to save data from transfer: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+// stream copies an upload in progress. If the upload fails, the connection
+// is aborted so the client doesn't mistake what it got for the artifact.
+func (t *Transfer) stream(w http.ResponseWriter, r *http.Request, tr *transfer, sp *spool) {
+	rd := sp.reader(r.Context())
+	defer rd.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err := io.Copy(flushWriter{w}, rd); err != nil {
+		if r.Context().Err() != nil {
+			// The client went away, so there is nothing left to abort.
 			return
 		}
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		tr.log.Warn("aborting streamed transfer", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (t *Transfer) upload(w http.ResponseWriter, r *http.Request, tr *transfer) {
+	if tr.limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, tr.limit)
+	}
+
+	want, err := uploadDigest(r.Header)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	sp, err := newSpool()
+	if err != nil {
+		tr.log.Error("failed to create spool for transfer", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	t.mu.Lock()
+	replay := tr.uploaded
+	if !replay {
+		tr.uploaded = true
+		tr.spool = sp
+		tr.notify()
+	}
+	t.mu.Unlock()
+
+	if replay {
+		sp.finish(nil)
+		tr.log.Warn("rejected a second upload")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	var body io.Reader = io.TeeReader(r.Body, sp)
+	if want != nil {
+		body = &digestReader{r: body, h: sha256.New(), want: want}
+	}
+
+	_, err = tr.store.Put(tr.runID, tr.name, body)
+	sp.finish(err)
+
+	t.mu.Lock()
+	tr.spool = nil
+	if err != nil {
+		// Let the task retry.
+		tr.uploaded = false
+	}
+	tr.notify()
+	t.mu.Unlock()
+
+	if err == errDigestMismatch {
+		tr.log.Warn("rejected upload", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		tr.log.Warn("rejected upload larger than the limit", "limit", tooLarge.Limit)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
 		return
 	}
+
+	if err != nil {
+		tr.log.Error("failed to save data from transfer", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// notify wakes downloads waiting on the transfer. The Transfer's lock must
+// be held.
+func (tr *transfer) notify() {
+	close(tr.changed)
+	tr.changed = make(chan struct{})
 }
 
-func (t *Transfer) InitInterconnect(ctx context.Context) string {
+// InitInterconnect returns the URLs tasks of the run use to upload (with a
+// PUT) and download the named artifact. If the store is a Presigner, the
+// URLs point at it directly. Otherwise they point at the Transfer, carry
+// separate random read and write tokens and stop working once the context
+// is done, though the artifact is kept. The upload URL may only be used
+// once.
+func (t *Transfer) InitInterconnect(ctx context.Context, runID, name string) (upload, download string) {
+	if p, ok := t.store.(Presigner); ok {
+		up, uerr := p.PresignUpload(runID, name)
+		down, derr := p.PresignDownload(runID, name)
+		if uerr == nil && derr == nil {
+			return up, down
+		}
+		t.log.Warn("failed to presign URLs, using the transfer handler", "run_id", runID, "artifact", name, "upload_err", uerr, "download_err", derr)
+	}
+
+	return t.init(fmt.Sprintf("%s/%s", runID, name), &transfer{
+		ctx:   ctx,
+		store: t.store,
+		runID: runID,
+		name:  name,
+		wait:  t.wait,
+		attrs: []interface{}{"run_id", runID, "artifact", name},
+	})
+}
+
+// InitCache returns the URLs a task of the run uses to save (with a PUT)
+// and restore the cache with the given ID (see artifacts.CacheID). Each run
+// gets its own URLs, so that runs that overlap don't replace each other's.
+// Restoring a cache that was never saved fails straight away instead of
+// waiting.
+func (t *Transfer) InitCache(ctx context.Context, runID, id string) (upload, download string) {
+	return t.init(fmt.Sprintf("caches/%s/%s", id, runID), &transfer{
+		ctx:   ctx,
+		store: t.caches,
+		runID: id,
+		name:  cacheName,
+		limit: t.maxCacheSize,
+		attrs: []interface{}{"run_id", runID, "cache", id},
+	})
+}
+
+// init registers the transfer under key until its context is done and
+// returns its upload and download URLs.
+func (t *Transfer) init(key string, tr *transfer) (upload, download string) {
+	tr.read = newToken()
+	tr.write = newToken()
+	tr.changed = make(chan struct{})
+	tr.log = t.log.With(tr.attrs...)
+
+	addr := fmt.Sprintf("%s/v1/transfer/%s", t.host, key)
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	name := fmt.Sprint(time.Now().UnixNano())
-	t.m[name] = transferInfo{
-		c:   make(chan []byte, 100),
-		ctx: ctx,
-	}
+	t.m[key] = tr
 
 	go func() {
-		<-ctx.Done()
+		<-tr.ctx.Done()
 		t.mu.Lock()
-		log.Printf("done with transfer handler at %s/v1/transfer/%s", t.host, name)
-		os.Remove(path.Join(t.dataDir, name))
 		defer t.mu.Unlock()
-		delete(t.m, name)
+		tr.log.Debug("done with transfer handler", "addr", addr)
+
+		// The same artifact may have been handed out again since.
+		if t.m[key] == tr {
+			delete(t.m, key)
+		}
 	}()
 
-	log.Printf("starting transfer handler at %s/v1/transfer/%s", t.host, name)
-	return fmt.Sprintf("%s/v1/transfer/%s", t.host, name)
+	tr.log.Debug("starting transfer handler", "addr", addr)
+	return addr + "?token=" + tr.write, addr + "?token=" + tr.read
+}
+
+// serveArtifact writes a stored artifact, honoring Range requests when the
+// store's reader can seek.
+func serveArtifact(w http.ResponseWriter, r *http.Request, f io.Reader, a artifacts.Artifact) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if sum, err := hex.DecodeString(a.SHA256); err == nil && len(sum) > 0 {
+		w.Header().Set("ETag", fmt.Sprintf("%q", a.SHA256))
+		w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, a.Name, a.Created, rs)
+		return
+	}
+
+	if a.Size >= 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(a.Size))
+	}
+	if r.Method != http.MethodHead {
+		io.Copy(w, f)
+	}
+}
+
+// uploadDigest returns the SHA-256 the uploader says the body has, from
+// either a Digest (SHA-256=<base64>) or X-Checksum-Sha256 (<hex>) header.
+// It returns nil if there is neither.
+func uploadDigest(h http.Header) ([]byte, error) {
+	if v := h.Get("X-Checksum-Sha256"); v != "" {
+		sum, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid X-Checksum-Sha256 %q", v)
+		}
+		return sum, nil
+	}
+
+	for _, d := range strings.Split(h.Get("Digest"), ",") {
+		d = strings.TrimSpace(d)
+		i := strings.Index(d, "=")
+		if i < 0 || !strings.EqualFold(d[:i], "SHA-256") {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(d[i+1:])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid Digest %q", d)
+		}
+		return sum, nil
+	}
+
+	return nil, nil
+}
+
+// digestReader fails with errDigestMismatch instead of io.EOF when what was
+// read does not hash to want.
+type digestReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want []byte
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(d.h.Sum(nil), d.want) {
+		return n, errDigestMismatch
+	}
+	return n, err
+}
+
+// statusWriter records the status of a response, so that it can be added to
+// its span.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusWriter) Flush() {
+	if fl, ok := s.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// end ends the span with the status of the response. A handler that panics
+// (e.g., to abort a stream) ends it too.
+func (s *statusWriter) end(span *tracing.Span) {
+	if r := recover(); r != nil {
+		span.RecordError(fmt.Errorf("aborted: %v", r))
+		span.End()
+		panic(r)
+	}
+
+	status := s.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	span.SetAttributes("http.status_code", status)
+	if status >= 400 {
+		span.RecordError(fmt.Errorf("status %d", status))
+	}
+	span.End()
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+func validToken(given, expected string) bool {
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
@@ -4,27 +4,35 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/poy/onpar"
 	. "github.com/poy/onpar/expect"
 	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
 	"github.com/poy/triple-c/internal/handlers"
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 type TT struct {
 	*testing.T
 	h        *handlers.Transfer
+	store    *artifacts.Store
+	caches   *artifacts.Store
+	tracer   *tracing.Tracer
+	exporter *spyExporter
 	recorder *httptest.ResponseRecorder
-	dataDir  string
 }
 
 func TestTransfer(t *testing.T) {
@@ -33,31 +41,25 @@ func TestTransfer(t *testing.T) {
 	defer o.Run(t)
 
 	o.BeforeEach(func(t *testing.T) TT {
-		dataDir, err := ioutil.TempDir("", "")
-		Expect(t, err).To(BeNil())
-
-		return TT{
-			T:        t,
-			dataDir:  dataDir,
-			h:        handlers.NewTransfer("http://some.url", dataDir, log.New(ioutil.Discard, "", 0)),
-			recorder: httptest.NewRecorder(),
-		}
+		return newTT(t, 100*time.Millisecond)
 	})
 
-	o.Spec("writes data from the POST to the dataDir", func(t TT) {
-		name := t.h.InitInterconnect(context.Background())
+	o.Spec("writes data from the POST to the store", func(t TT) {
+		addr, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		Expect(t, addr).To(StartWith("http://some.url/v1/transfer/run-1/output?token="))
+		Expect(t, download).To(StartWith("http://some.url/v1/transfer/run-1/output?token="))
+		Expect(t, download).To(Not(Equal(addr)))
+
 		expectedData := make([]byte, 10*1024)
 		rand.Read(expectedData)
 
-		postReq, err := http.NewRequest("POST", name, bytes.NewReader(expectedData))
+		postReq, err := http.NewRequest("POST", addr, bytes.NewReader(expectedData))
 		Expect(t, err).To(BeNil())
 		recorder := httptest.NewRecorder()
 		t.h.ServeHTTP(recorder, postReq)
 		Expect(t, recorder.Code).To(Equal(http.StatusOK))
 
-		name = path.Base(name)
-
-		f, err := os.Open(path.Join(t.dataDir, name))
+		f, _, err := t.store.Open("run-1", "output")
 		Expect(t, err).To(BeNil())
 		defer f.Close()
 
@@ -66,17 +68,66 @@ func TestTransfer(t *testing.T) {
 		Expect(t, data).To(Equal(expectedData))
 	})
 
-	o.Spec("GET reads data from the dataDir", func(t TT) {
-		name := t.h.InitInterconnect(context.Background())
+	o.Spec("it traces transfers as children of the traceparent", func(t TT) {
+		ctx, _ := t.tracer.Start(context.Background(), "plan.run")
+		upload, download := t.h.InitInterconnect(ctx, "run-1", "output")
+		_, task := t.tracer.Start(ctx, "task")
+
+		req, err := http.NewRequest("PUT", upload, strings.NewReader("some-data"))
+		Expect(t, err).To(BeNil())
+		req.Header.Set("traceparent", task.TraceParent())
+		t.h.ServeHTTP(httptest.NewRecorder(), req)
+
+		req, err = http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+		req.Header.Set("traceparent", task.TraceParent())
+		t.h.ServeHTTP(httptest.NewRecorder(), req)
+
+		t.tracer.Flush()
+		spans := t.exporter.Spans()
+		Expect(t, spans).To(HaveLen(2))
+		Expect(t, spans[0].Name).To(Equal("transfer.upload"))
+		Expect(t, spans[1].Name).To(Equal("transfer.download"))
+		for _, s := range spans {
+			Expect(t, s.TraceID).To(Equal(task.TraceID()))
+			Expect(t, task.TraceParent()).To(ContainSubstring(s.ParentSpanID))
+			Expect(t, s.Attributes).To(Contain(
+				tracing.Attribute{Key: "run_id", Value: "run-1"},
+				tracing.Attribute{Key: "artifact", Value: "output"},
+				tracing.Attribute{Key: "http.status_code", Value: http.StatusOK},
+			))
+			Expect(t, s.Err).To(Equal(""))
+		}
+	})
+
+	o.Spec("it traces transfers as part of the run without a traceparent", func(t TT) {
+		ctx, run := t.tracer.Start(context.Background(), "plan.run")
+		_, download := t.h.InitCache(ctx, "run-1", "some-cache")
+
+		req, err := http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(httptest.NewRecorder(), req)
+
+		t.tracer.Flush()
+		spans := t.exporter.Spans()
+		Expect(t, spans).To(HaveLen(1))
+		Expect(t, spans[0].TraceID).To(Equal(run.TraceID()))
+		Expect(t, spans[0].Attributes).To(Contain(
+			tracing.Attribute{Key: "cache", Value: "some-cache"},
+			tracing.Attribute{Key: "http.status_code", Value: http.StatusNotFound},
+		))
+		Expect(t, spans[0].Err).To(Not(Equal("")))
+	})
+
+	o.Spec("GET reads data from the store", func(t TT) {
+		_, addr := t.h.InitInterconnect(context.Background(), "run-1", "output")
 		expectedData := make([]byte, 10*1024)
 		rand.Read(expectedData)
 
-		f, err := os.Create(path.Join(t.dataDir, path.Base(name)))
+		_, err := t.store.Put("run-1", "output", bytes.NewReader(expectedData))
 		Expect(t, err).To(BeNil())
-		io.Copy(f, bytes.NewReader(expectedData))
-		f.Close()
 
-		getReq, err := http.NewRequest("GET", name, bytes.NewReader(nil))
+		getReq, err := http.NewRequest("GET", addr, bytes.NewReader(nil))
 		Expect(t, err).To(BeNil())
 		recorder := httptest.NewRecorder()
 		t.h.ServeHTTP(recorder, getReq)
@@ -84,36 +135,109 @@ func TestTransfer(t *testing.T) {
 		Expect(t, recorder.Body.Bytes()).To(Equal(expectedData))
 	})
 
-	o.Spec("it returns a 405 for non GET or POST", func(t TT) {
-		name := t.h.InitInterconnect(context.Background())
-		req, err := http.NewRequest("PUT", name, bytes.NewReader(nil))
+	o.Spec("it returns a 404 for GET before the artifact is written", func(t TT) {
+		_, addr := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		req, err := http.NewRequest("GET", addr, bytes.NewReader(nil))
 		Expect(t, err).To(BeNil())
 
 		t.h.ServeHTTP(t.recorder, req)
-		Expect(t, t.recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
 	})
 
-	o.Spec("InitInterconnect returns unique names", func(t TT) {
-		a := t.h.InitInterconnect(context.Background())
-		b := t.h.InitInterconnect(context.Background())
-		Expect(t, a).To(Not(Equal(b)))
+	o.Spec("it accepts uploads with PUT", func(t TT) {
+		addr, _ := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		req, err := http.NewRequest("PUT", addr, bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+
+		t.h.ServeHTTP(t.recorder, req)
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.store.List("run-1")).To(HaveLen(1))
+	})
+
+	o.Spec("it returns a 405 for other methods", func(t TT) {
+		addr, _ := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		req, err := http.NewRequest("DELETE", addr, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+
+		t.h.ServeHTTP(t.recorder, req)
+		Expect(t, t.recorder.Code).To(Equal(http.StatusMethodNotAllowed))
 	})
 
-	o.Spec("it returns a 404 for an expired name", func(t TT) {
+	o.Spec("it returns a 404 for an expired name but keeps the artifact", func(t TT) {
 		ctx, cancel := context.WithCancel(context.Background())
-		name := t.h.InitInterconnect(ctx)
-		req, err := http.NewRequest("GET", name, bytes.NewReader(nil))
+		upload, download := t.h.InitInterconnect(ctx, "run-1", "output")
+
+		req, err := http.NewRequest("POST", upload, bytes.NewReader([]byte("some-data")))
 		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(httptest.NewRecorder(), req)
 
 		cancel()
 		time.Sleep(100 * time.Millisecond)
 
+		req, err = http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
 		t.h.ServeHTTP(t.recorder, req)
 		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+
+		Expect(t, t.store.List("run-1")).To(HaveLen(1))
+	})
+
+	o.Spec("it requires the write token to upload", func(t TT) {
+		_, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+
+		for _, addr := range []string{
+			download,
+			"http://some.url/v1/transfer/run-1/output",
+			"http://some.url/v1/transfer/run-1/output?token=wrong",
+		} {
+			req, err := http.NewRequest("PUT", addr, bytes.NewReader([]byte("some-data")))
+			Expect(t, err).To(BeNil())
+			recorder := httptest.NewRecorder()
+			t.h.ServeHTTP(recorder, req)
+			Expect(t, recorder.Code).To(Equal(http.StatusForbidden))
+		}
+
+		Expect(t, t.store.List("run-1")).To(HaveLen(0))
+	})
+
+	o.Spec("it requires the read token to download", func(t TT) {
+		upload, _ := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		_, err := t.store.Put("run-1", "output", bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+
+		for _, addr := range []string{
+			upload,
+			"http://some.url/v1/transfer/run-1/output",
+		} {
+			req, err := http.NewRequest("GET", addr, bytes.NewReader(nil))
+			Expect(t, err).To(BeNil())
+			recorder := httptest.NewRecorder()
+			t.h.ServeHTTP(recorder, req)
+			Expect(t, recorder.Code).To(Equal(http.StatusForbidden))
+		}
+	})
+
+	o.Spec("it rejects a replayed upload", func(t TT) {
+		upload, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+
+		req, err := http.NewRequest("PUT", upload, bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(httptest.NewRecorder(), req)
+
+		req, err = http.NewRequest("PUT", upload, bytes.NewReader([]byte("other-data")))
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+		Expect(t, t.recorder.Code).To(Equal(http.StatusConflict))
+
+		req, err = http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+		recorder := httptest.NewRecorder()
+		t.h.ServeHTTP(recorder, req)
+		Expect(t, recorder.Body.String()).To(Equal("some-data"))
 	})
 
 	o.Spec("it returns a 404 for an unknown name", func(t TT) {
-		req, err := http.NewRequest("GET", "http://some.url/v1/transfer/unknown", bytes.NewReader(nil))
+		req, err := http.NewRequest("GET", "http://some.url/v1/transfer/run-1/unknown", bytes.NewReader(nil))
 		Expect(t, err).To(BeNil())
 
 		t.h.ServeHTTP(t.recorder, req)
@@ -121,20 +245,368 @@ func TestTransfer(t *testing.T) {
 	})
 
 	o.Spec("it survives the race detector", func(t TT) {
+		done := make(chan struct{})
 		go func() {
+			defer close(done)
 			for i := 0; i < 100; i++ {
-				ctx, _ := context.WithTimeout(context.Background(), time.Microsecond)
-				req, err := http.NewRequest("GET", t.h.InitInterconnect(ctx), bytes.NewReader(nil))
+				ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond)
+				req, err := http.NewRequest("GET", downloadAddr(t.h, ctx, "run-1", fmt.Sprint(i)), bytes.NewReader(nil))
 				Expect(t, err).To(BeNil())
 				t.h.ServeHTTP(httptest.NewRecorder(), req)
+				cancel()
 			}
 		}()
 
 		for i := 0; i < 100; i++ {
-			ctx, _ := context.WithTimeout(context.Background(), time.Microsecond)
-			req, err := http.NewRequest("GET", t.h.InitInterconnect(ctx), bytes.NewReader(nil))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond)
+			req, err := http.NewRequest("GET", downloadAddr(t.h, ctx, "run-2", fmt.Sprint(i)), bytes.NewReader(nil))
 			Expect(t, err).To(BeNil())
 			t.h.ServeHTTP(httptest.NewRecorder(), req)
+			cancel()
+		}
+		<-done
+	})
+}
+
+func TestTransferCaches(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TT {
+		return newTT(t, 5*time.Second)
+	})
+
+	o.Spec("it saves a cache and restores it in a later run", func(t TT) {
+		ctx, cancel := context.WithCancel(context.Background())
+		upload, _ := t.h.InitCache(ctx, "run-1", "some-plan-some-cache")
+		Expect(t, upload).To(StartWith("http://some.url/v1/transfer/caches/some-plan-some-cache/run-1?token="))
+
+		req, err := http.NewRequest("PUT", upload, bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.caches.List("some-plan-some-cache")).To(HaveLen(1))
+		Expect(t, t.store.Runs()).To(HaveLen(0))
+		cancel()
+
+		_, download := t.h.InitCache(context.Background(), "run-2", "some-plan-some-cache")
+		req, err = http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+		recorder := httptest.NewRecorder()
+		t.h.ServeHTTP(recorder, req)
+		Expect(t, recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, recorder.Body.String()).To(Equal("some-data"))
+	})
+
+	o.Spec("it lets overlapping runs each save the cache", func(t TT) {
+		upload1, _ := t.h.InitCache(context.Background(), "run-1", "some-plan-some-cache")
+		upload2, _ := t.h.InitCache(context.Background(), "run-2", "some-plan-some-cache")
+		Expect(t, upload1).To(Not(Equal(upload2)))
+
+		for _, upload := range []string{upload1, upload2} {
+			req, err := http.NewRequest("PUT", upload, bytes.NewReader([]byte("some-data")))
+			Expect(t, err).To(BeNil())
+			recorder := httptest.NewRecorder()
+			t.h.ServeHTTP(recorder, req)
+			Expect(t, recorder.Code).To(Equal(http.StatusOK))
 		}
+		Expect(t, t.caches.List("some-plan-some-cache")).To(HaveLen(1))
 	})
+
+	o.Spec("it doesn't wait for a cache that was never saved", func(t TT) {
+		_, download := t.h.InitCache(context.Background(), "run-2", "some-plan-some-cache")
+
+		req, err := http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+
+		start := time.Now()
+		t.h.ServeHTTP(t.recorder, req)
+		Expect(t, t.recorder.Code).To(Equal(http.StatusNotFound))
+		Expect(t, time.Since(start) < time.Second).To(BeTrue())
+	})
+
+	o.Spec("it rejects caches larger than the limit", func(t TT) {
+		upload, _ := t.h.InitCache(context.Background(), "run-2", "some-plan-some-cache")
+
+		req, err := http.NewRequest("PUT", upload, bytes.NewReader(make([]byte, 17)))
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(t.recorder, req)
+		Expect(t, t.recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(t, t.caches.Runs()).To(HaveLen(0))
+	})
+}
+
+func TestTransferStreaming(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TT {
+		return newTT(t, 5*time.Second)
+	})
+
+	o.Spec("a GET waits for the upload", func(t TT) {
+		upload, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			req, err := http.NewRequest("GET", download, bytes.NewReader(nil))
+			Expect(t, err).To(BeNil())
+			t.h.ServeHTTP(t.recorder, req)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		req, err := http.NewRequest("PUT", upload, bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+		t.h.ServeHTTP(httptest.NewRecorder(), req)
+
+		<-done
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.recorder.Body.String()).To(Equal("some-data"))
+	})
+
+	o.Spec("it serves ranges of an uploaded artifact", func(t TT) {
+		_, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		_, err := t.store.Put("run-1", "output", bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+
+		req, err := http.NewRequest("GET", download, bytes.NewReader(nil))
+		Expect(t, err).To(BeNil())
+		req.Header.Set("Range", "bytes=5-")
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusPartialContent))
+		Expect(t, t.recorder.Body.String()).To(Equal("data"))
+		Expect(t, t.recorder.Header().Get("ETag")).To(Equal(`"9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1"`))
+	})
+
+	o.Spec("it accepts an upload that matches its digest", func(t TT) {
+		upload, _ := t.h.InitInterconnect(context.Background(), "run-1", "output")
+
+		req, err := http.NewRequest("PUT", upload, bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+		req.Header.Set("Digest", "SHA-256=kzLZTV7mmtF9MQ5izRAdcPV4Ak/V6NFkf4Bz+IbIlOE=")
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, t.store.List("run-1")).To(HaveLen(1))
+	})
+
+	o.Spec("it rejects an upload that doesn't match its digest", func(t TT) {
+		upload, _ := t.h.InitInterconnect(context.Background(), "run-1", "output")
+
+		req, err := http.NewRequest("PUT", upload, bytes.NewReader([]byte("other-data")))
+		Expect(t, err).To(BeNil())
+		req.Header.Set("X-Checksum-Sha256", "9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1")
+		t.h.ServeHTTP(t.recorder, req)
+
+		Expect(t, t.recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(t, t.store.List("run-1")).To(HaveLen(0))
+
+		// The task may retry.
+		req, err = http.NewRequest("PUT", upload, bytes.NewReader([]byte("some-data")))
+		Expect(t, err).To(BeNil())
+		req.Header.Set("X-Checksum-Sha256", "9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1")
+		recorder := httptest.NewRecorder()
+		t.h.ServeHTTP(recorder, req)
+		Expect(t, recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	o.Spec("it streams an upload in progress to every consumer", func(t TT) {
+		server := httptest.NewServer(t.h)
+		defer server.Close()
+		upload, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		upload = strings.Replace(upload, "http://some.url", server.URL, 1)
+		download = strings.Replace(download, "http://some.url", server.URL, 1)
+
+		pr, pw := io.Pipe()
+		uploaded := make(chan int)
+		go func() {
+			req, err := http.NewRequest("PUT", upload, pr)
+			Expect(t, err).To(BeNil())
+			resp, err := http.DefaultClient.Do(req)
+			Expect(t, err).To(BeNil())
+			resp.Body.Close()
+			uploaded <- resp.StatusCode
+		}()
+
+		pw.Write([]byte("some-"))
+
+		var bodies []io.ReadCloser
+		for i := 0; i < 2; i++ {
+			resp, err := http.Get(download)
+			Expect(t, err).To(BeNil())
+			defer resp.Body.Close()
+
+			first := make([]byte, 5)
+			_, err = io.ReadFull(resp.Body, first)
+			Expect(t, err).To(BeNil())
+			Expect(t, string(first)).To(Equal("some-"))
+			bodies = append(bodies, resp.Body)
+		}
+
+		pw.Write([]byte("data"))
+		pw.Close()
+		Expect(t, <-uploaded).To(Equal(http.StatusOK))
+
+		for _, b := range bodies {
+			rest, err := ioutil.ReadAll(b)
+			Expect(t, err).To(BeNil())
+			Expect(t, string(rest)).To(Equal("data"))
+		}
+	})
+
+	o.Spec("it aborts consumers of an upload that fails", func(t TT) {
+		server := httptest.NewServer(t.h)
+		defer server.Close()
+		upload, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		upload = strings.Replace(upload, "http://some.url", server.URL, 1)
+		download = strings.Replace(download, "http://some.url", server.URL, 1)
+
+		pr, pw := io.Pipe()
+		uploaded := make(chan int)
+		go func() {
+			req, err := http.NewRequest("PUT", upload, pr)
+			Expect(t, err).To(BeNil())
+			req.Header.Set("X-Checksum-Sha256", "9332d94d5ee69ad17d310e62cd101d70f578024fd5e8d1647f8073f886c894e1")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(t, err).To(BeNil())
+			resp.Body.Close()
+			uploaded <- resp.StatusCode
+		}()
+
+		pw.Write([]byte("other-"))
+
+		resp, err := http.Get(download)
+		Expect(t, err).To(BeNil())
+		defer resp.Body.Close()
+
+		pw.Write([]byte("data"))
+		pw.Close()
+		Expect(t, <-uploaded).To(Equal(http.StatusBadRequest))
+
+		_, err = ioutil.ReadAll(resp.Body)
+		Expect(t, err).To(Not(BeNil()))
+	})
+
+	o.Spec("it stops streaming a stalled upload when the consumer goes away", func(t TT) {
+		server := httptest.NewServer(t.h)
+		defer server.Close()
+		upload, download := t.h.InitInterconnect(context.Background(), "run-1", "output")
+		upload = strings.Replace(upload, "http://some.url", server.URL, 1)
+
+		pr, pw := io.Pipe()
+		defer pw.Close()
+		go func() {
+			req, err := http.NewRequest("PUT", upload, pr)
+			Expect(t, err).To(BeNil())
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+
+		pw.Write([]byte("some-"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest("GET", download, nil).WithContext(ctx)
+		recorder := httptest.NewRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			t.h.ServeHTTP(recorder, req)
+		}()
+
+		Expect(t, done).To(Always(Not(BeClosed())))
+
+		cancel()
+		Expect(t, done).To(ViaPolling(BeClosed()))
+	})
+}
+
+func TestTransferPresigned(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it hands out presigned URLs when the store supports them", func(t *testing.T) {
+		store := &spyPresignedStore{}
+		h := handlers.NewTransfer("http://some.url", store, nil, 100*time.Millisecond, 0, nil, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		upload, download := h.InitInterconnect(context.Background(), "run-1", "output")
+		Expect(t, upload).To(Equal("https://some-bucket/run-1/output?upload"))
+		Expect(t, download).To(Equal("https://some-bucket/run-1/output?download"))
+	})
+
+	o.Spec("it falls back to its own URL when presigning fails", func(t *testing.T) {
+		store := &spyPresignedStore{err: errors.New("some-error")}
+		h := handlers.NewTransfer("http://some.url", store, nil, 100*time.Millisecond, 0, nil, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		upload, download := h.InitInterconnect(context.Background(), "run-1", "output")
+		Expect(t, upload).To(StartWith("http://some.url/v1/transfer/run-1/output?token="))
+		Expect(t, download).To(StartWith("http://some.url/v1/transfer/run-1/output?token="))
+	})
+}
+
+func newTT(t *testing.T, wait time.Duration) TT {
+	dataDir, err := ioutil.TempDir("", "")
+	Expect(t, err).To(BeNil())
+
+	store, err := artifacts.NewStore(path.Join(dataDir, "artifacts"), artifacts.RetentionPolicy{}, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+	Expect(t, err).To(BeNil())
+
+	caches, err := artifacts.NewStore(path.Join(dataDir, "caches"), artifacts.RetentionPolicy{}, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+	Expect(t, err).To(BeNil())
+
+	exporter := &spyExporter{}
+	tracer := tracing.New("some-service", exporter, 0, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+	return TT{
+		T:        t,
+		store:    store,
+		caches:   caches,
+		tracer:   tracer,
+		exporter: exporter,
+		h:        handlers.NewTransfer("http://some.url", store, caches, wait, 16, tracer, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+		recorder: httptest.NewRecorder(),
+	}
+}
+
+func downloadAddr(h *handlers.Transfer, ctx context.Context, runID, name string) string {
+	_, addr := h.InitInterconnect(ctx, runID, name)
+	return addr
+}
+
+type spyPresignedStore struct {
+	handlers.ArtifactStore
+	err error
+}
+
+func (s *spyPresignedStore) PresignUpload(runID, name string) (string, error) {
+	return fmt.Sprintf("https://some-bucket/%s/%s?upload", runID, name), s.err
+}
+
+func (s *spyPresignedStore) PresignDownload(runID, name string) (string, error) {
+	return fmt.Sprintf("https://some-bucket/%s/%s?download", runID, name), s.err
+}
+
+type spyExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (s *spyExporter) Export(ctx context.Context, service string, spans []tracing.SpanData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = append(s.spans, spans...)
+	return nil
+}
+
+func (s *spyExporter) Spans() []tracing.SpanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]tracing.SpanData(nil), s.spans...)
 }
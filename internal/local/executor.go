@@ -0,0 +1,159 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Executor runs tasks as processes on the local machine. It satisfies the
+// same contract as the CAPI client so that a plan can be run without a Cloud
+// Foundry environment.
+type Executor struct {
+	dataDir string
+	build   CommandBuilder
+	sem     chan struct{}
+	log     *slog.Logger
+
+	mu    sync.Mutex
+	names map[string]int
+}
+
+// CommandBuilder returns the command that runs the script found at
+// scriptPath. The task's scratch directory is dir. The command must stop
+// when the context is done.
+type CommandBuilder func(ctx context.Context, dir, scriptPath string) *exec.Cmd
+
+// waitDelay is how long a stopped task's output is waited for, e.g., when
+// processes it started are still holding onto it.
+const waitDelay = 10 * time.Second
+
+// Bash runs the script with bash directly on the host.
+func Bash(ctx context.Context, dir, scriptPath string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "bash", scriptPath)
+	cmd.Env = append(os.Environ(), "TRIPLE_C_WORKDIR="+dir)
+	cmd.WaitDelay = waitDelay
+	killGroup(cmd)
+	return cmd
+}
+
+// Docker returns a CommandBuilder that runs the script in a container
+// created from the given image. The scratch directory is mounted as the
+// container's working directory. The container shares the host's network,
+// so that it reaches triple-c at the same address (e.g., localhost:8080) as
+// a local task would.
+func Docker(image string) CommandBuilder {
+	return func(ctx context.Context, dir, scriptPath string) *exec.Cmd {
+		// Killing the docker CLI leaves the container running, so it is
+		// killed by name instead.
+		name := "triple-c-" + path.Base(dir)
+		cmd := exec.CommandContext(
+			ctx,
+			"docker", "run", "--rm",
+			"--name", name,
+			"--network", "host",
+			"-v", fmt.Sprintf("%s:/workdir", dir),
+			"-w", "/workdir",
+			"-e", "TRIPLE_C_WORKDIR=/workdir",
+			image,
+			"bash", path.Join("/workdir", path.Base(scriptPath)),
+		)
+		cmd.Cancel = func() error {
+			return exec.Command("docker", "kill", name).Run()
+		}
+		cmd.WaitDelay = waitDelay
+		return cmd
+	}
+}
+
+// NewExecutor returns a new Executor. Each task gets its own scratch
+// directory within dataDir. At most concurrency tasks run at once.
+func NewExecutor(dataDir string, concurrency int, b CommandBuilder, log *slog.Logger) *Executor {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Executor{
+		dataDir: dataDir,
+		build:   b,
+		sem:     make(chan struct{}, concurrency),
+		log:     log,
+		names:   make(map[string]int),
+	}
+}
+
+// CreateTask runs the command and blocks until it exits. It returns an error
+// if the command exits with a non-zero status. The command is stopped when
+// the context is done (e.g., when its plan is removed). It gets the task's
+// span through TRACEPARENT.
+func (e *Executor) CreateTask(
+	ctx context.Context,
+	command string,
+	name string,
+	appGuid string,
+) error {
+	e.mu.Lock()
+	e.names[name]++
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.names[name]--; e.names[name] == 0 {
+			delete(e.names, name)
+		}
+	}()
+
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e.sem }()
+
+	dir, err := ioutil.TempDir(e.dataDir, "task")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	scriptPath := path.Join(dir, "triple-c-task.sh")
+	if err := ioutil.WriteFile(scriptPath, []byte(command), 0700); err != nil {
+		return err
+	}
+
+	cmd := e.build(ctx, dir, scriptPath)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		e.log.Info("local task output", "name", name, "line", line)
+	}
+	if err != nil {
+		return fmt.Errorf("task failed: %s", err)
+	}
+
+	return nil
+}
+
+// ListTasks returns the names of the tasks that are waiting to run or
+// running.
+func (e *Executor) ListTasks(appGuid string) ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var names []string
+	for name := range e.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
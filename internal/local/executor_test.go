@@ -0,0 +1,131 @@
+package local_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/local"
+)
+
+type TE struct {
+	*testing.T
+	dataDir string
+	e       *local.Executor
+}
+
+func TestExecutor(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TE {
+		dataDir, err := ioutil.TempDir("", "")
+		Expect(t, err).To(BeNil())
+
+		return TE{
+			T:       t,
+			dataDir: dataDir,
+			e:       local.NewExecutor(dataDir, 2, local.Bash, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+		}
+	})
+
+	o.Spec("it runs the command in a scratch dir within the data dir", func(t TE) {
+		out := path.Join(t.dataDir, "out")
+		err := t.e.CreateTask(context.Background(), "pwd > "+out+"\necho $TRIPLE_C_WORKDIR >> "+out, "some-name", "some-guid")
+		Expect(t, err).To(BeNil())
+
+		data, err := ioutil.ReadFile(out)
+		Expect(t, err).To(BeNil())
+		Expect(t, string(data)).To(StartWith(t.dataDir))
+	})
+
+	o.Spec("it returns an error when the command fails", func(t TE) {
+		err := t.e.CreateTask(context.Background(), "exit 1", "some-name", "some-guid")
+		Expect(t, err).To(Not(BeNil()))
+	})
+
+	o.Spec("it lists the names of the tasks that are running", func(t TE) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 2)
+		for _, name := range []string{"some-name", "some-other-name"} {
+			go func(name string) {
+				done <- t.e.CreateTask(ctx, "sleep 60", name, "some-guid")
+			}(name)
+		}
+
+		Expect(t, func() []string {
+			names, _ := t.e.ListTasks("some-guid")
+			return names
+		}).To(ViaPolling(Equal([]string{"some-name", "some-other-name"})))
+
+		cancel()
+		Expect(t, done).To(ViaPolling(Receive()))
+		Expect(t, done).To(ViaPolling(Receive()))
+
+		names, err := t.e.ListTasks("some-guid")
+		Expect(t, err).To(BeNil())
+		Expect(t, names).To(HaveLen(0))
+	})
+
+	o.Spec("it stops the task when the context is done", func(t TE) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := t.e.CreateTask(ctx, "sleep 60", "some-name", "some-guid")
+		Expect(t, err).To(Not(BeNil()))
+		Expect(t, time.Since(start) < 10*time.Second).To(BeTrue())
+	})
+
+	o.Spec("it limits the number of concurrent tasks", func(t TE) {
+		var (
+			mu      sync.Mutex
+			running int
+			max     int
+		)
+
+		e := local.NewExecutor(t.dataDir, 2, func(ctx context.Context, dir, scriptPath string) *exec.Cmd {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return exec.Command("true")
+		}, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e.CreateTask(context.Background(), "true", "some-name", "some-guid")
+			}()
+		}
+		wg.Wait()
+
+		Expect(t, max).To(Equal(2))
+	})
+
+	o.Spec("it runs docker tasks on the host's network", func(t TE) {
+		cmd := local.Docker("some-image")(context.Background(), t.dataDir, path.Join(t.dataDir, "triple-c-task.sh"))
+		Expect(t, strings.Join(cmd.Args, " ")).To(ContainSubstring("--network host"))
+		Expect(t, cmd.Args[len(cmd.Args)-1]).To(Equal("/workdir/triple-c-task.sh"))
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package local
+
+import "os/exec"
+
+// killGroup leaves the command to kill only bash when its context is done.
+func killGroup(cmd *exec.Cmd) {}
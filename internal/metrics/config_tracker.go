@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+)
+
+type ConfigInfo struct {
+	Branch string
+
+	// SHA is the latest config SHA that was loaded.
+	SHA string
+
+	// LastGoodSHA is the latest config SHA that loaded without error. It is
+	// the config the branch is running.
+	LastGoodSHA string
+
+	// Error, File and Line describe why the config at SHA failed to load.
+	// File and Line are empty if they are not known.
+	Error string
+	File  string
+	Line  int
+
+	// Plans maps the name of each plan in the last good config to the file
+	// it was defined in.
+	Plans map[string]string
+}
+
+// lineError is implemented by errors that know where in a file they
+// occurred.
+type lineError interface {
+	ErrorLine() int
+}
+
+// fileError is implemented by errors that know which file they occurred in.
+type fileError interface {
+	ErrorFile() string
+}
+
+type ConfigTracker struct {
+	mu sync.RWMutex
+	m  map[string]*ConfigInfo
+}
+
+func NewConfigTracker() *ConfigTracker {
+	return &ConfigTracker{
+		m: make(map[string]*ConfigInfo),
+	}
+}
+
+func (t *ConfigTracker) ConfigInfo() []ConfigInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var results []ConfigInfo
+	for _, v := range t.m {
+		results = append(results, *v)
+	}
+
+	return results
+}
+
+// Register starts tracking the config status of the given branch until the
+// context is canceled. The returned func records the outcome of loading the
+// config at a SHA, along with the file each plan came from. A failed load
+// leaves LastGoodSHA and Plans alone.
+func (t *ConfigTracker) Register(ctx context.Context, branch string) func(SHA string, planFiles map[string]string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := &ConfigInfo{
+		Branch: branch,
+	}
+	t.m[branch] = info
+
+	go func() {
+		<-ctx.Done()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.m[branch] == info {
+			delete(t.m, branch)
+		}
+	}()
+
+	return func(SHA string, planFiles map[string]string, err error) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		info.SHA = SHA
+		info.Error = ""
+		info.File = ""
+		info.Line = 0
+
+		if err == nil {
+			info.LastGoodSHA = SHA
+			info.Plans = planFiles
+			return
+		}
+
+		info.Error = err.Error()
+		if le, ok := err.(lineError); ok {
+			info.Line = le.ErrorLine()
+		}
+		if fe, ok := err.(fileError); ok {
+			info.File = fe.ErrorFile()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type TC struct {
+	*testing.T
+	c *metrics.ConfigTracker
+}
+
+func TestConfigTracker(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TC {
+		return TC{
+			T: t,
+			c: metrics.NewConfigTracker(),
+		}
+	})
+
+	o.Spec("it keeps track of the config status for each branch", func(t TC) {
+		t.c.Register(context.Background(), "some-branch-1")("sha-1", nil, nil)
+		t.c.Register(context.Background(), "some-branch-2")("sha-2", nil, errors.New("some-error"))
+
+		Expect(t, t.c.ConfigInfo()).To(And(
+			Contain(metrics.ConfigInfo{
+				Branch:      "some-branch-1",
+				SHA:         "sha-1",
+				LastGoodSHA: "sha-1",
+			}),
+			Contain(metrics.ConfigInfo{
+				Branch: "some-branch-2",
+				SHA:    "sha-2",
+				Error:  "some-error",
+			}),
+		))
+	})
+
+	o.Spec("it keeps the last good SHA when a config fails", func(t TC) {
+		f := t.c.Register(context.Background(), "some-branch")
+		f("sha-1", map[string]string{"some-plan": "a.yml"}, nil)
+		f("sha-2", nil, lineErr{line: 7, file: "b.yml"})
+
+		Expect(t, t.c.ConfigInfo()).To(Equal([]metrics.ConfigInfo{
+			{
+				Branch:      "some-branch",
+				SHA:         "sha-2",
+				LastGoodSHA: "sha-1",
+				Error:       "some-line-error",
+				File:        "b.yml",
+				Line:        7,
+				Plans:       map[string]string{"some-plan": "a.yml"},
+			},
+		}))
+	})
+
+	o.Spec("it clears the error when a later config loads", func(t TC) {
+		f := t.c.Register(context.Background(), "some-branch")
+		f("sha-1", nil, lineErr{line: 7})
+		f("sha-2", nil, nil)
+
+		Expect(t, t.c.ConfigInfo()).To(Equal([]metrics.ConfigInfo{
+			{
+				Branch:      "some-branch",
+				SHA:         "sha-2",
+				LastGoodSHA: "sha-2",
+			},
+		}))
+	})
+
+	o.Spec("it forgets the branch when the context is cancelled", func(t TC) {
+		ctx, cancel := context.WithCancel(context.Background())
+		f := t.c.Register(ctx, "some-branch")
+		f("sha", nil, nil)
+		cancel()
+
+		Expect(t, t.c.ConfigInfo).To(ViaPolling(HaveLen(0)))
+
+		f("sha", nil, nil)
+		Expect(t, t.c.ConfigInfo()).To(HaveLen(0))
+	})
+}
+
+type lineErr struct {
+	line int
+	file string
+}
+
+func (e lineErr) Error() string {
+	return "some-line-error"
+}
+
+func (e lineErr) ErrorLine() int {
+	return e.line
+}
+
+func (e lineErr) ErrorFile() string {
+	return e.file
+}
@@ -1,11 +1,26 @@
 package metrics
 
-import "expvar"
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
 
-// Metrics stores health metrics for the process. It has a gauge and counter
-// metrics.
+// Metrics stores health metrics for the process. It has counter, gauge and
+// histogram metrics. Each one is published to the Map (if there is one) and
+// served in the Prometheus text format by ServeHTTP.
 type Metrics struct {
 	m Map
+
+	mu       sync.Mutex
+	families map[string]*family
 }
 
 // Map stores the desired metrics.
@@ -20,35 +35,345 @@ type Map interface {
 	Get(key string) expvar.Var
 }
 
+// Labels are the dimensions of a metric (e.g., plan, branch, repo or task).
+// Each distinct set of labels is its own series.
+type Labels map[string]string
+
+// DefaultBuckets are the histogram buckets used when none are given. They
+// suit latencies of up to a few seconds (e.g., git operations).
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DurationBuckets are histogram buckets for durations of up to an hour (e.g.,
+// tasks).
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+type family struct {
+	kind    string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labels  []labelPair
+	buckets []float64
+
+	mu     sync.Mutex
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+type labelPair struct {
+	name, value string
+}
+
 // New returns a new Metrics.
 func New(m Map) *Metrics {
 	return &Metrics{
-		m: m,
+		m:        m,
+		families: make(map[string]*family),
 	}
 }
 
-// NewCounter returns a func to be used increment the counter total.
-func (m *Metrics) NewCounter(name string) func(delta uint64) {
-	if m.m == nil {
-		return func(_ uint64) {}
+// NewCounter returns a func to be used increment the counter total. Counters
+// with the same name and labels share a total. The Map gets the labeled
+// total and, so that it still has the overall total, the unlabeled one.
+func (m *Metrics) NewCounter(name string, labels ...Labels) func(delta uint64) {
+	s := m.series(name, kindCounter, nil, labels)
+	ints := m.expvarInts(name, s.labels)
+
+	return func(d uint64) {
+		s.mu.Lock()
+		s.value += float64(d)
+		s.mu.Unlock()
+
+		for _, i := range ints {
+			i.Add(int64(d))
+		}
 	}
+}
 
-	m.m.Add(name, 0)
-	i := m.m.Get(name).(*expvar.Int)
+// NewGauge returns a func to be used to set the value of a gauge metric. The
+// Map only gets the labeled value, as gauges can't be summed.
+func (m *Metrics) NewGauge(name string, labels ...Labels) func(value float64) {
+	s := m.series(name, kindGauge, nil, labels)
 
-	return func(d uint64) {
-		i.Add(int64(d))
+	var f *expvar.Float
+	if m.m != nil {
+		key := expvarKey(name, s.labels)
+		m.m.AddFloat(key, 0)
+		f = m.m.Get(key).(*expvar.Float)
+	}
+
+	return func(v float64) {
+		s.mu.Lock()
+		s.value = v
+		s.mu.Unlock()
+
+		if f != nil {
+			f.Set(v)
+		}
+	}
+}
+
+// NewHistogram returns a func to be used to observe values (e.g., durations
+// in seconds). The buckets are the sorted upper bounds of each bucket. If
+// there are none, DefaultBuckets are used. Histograms with the same name
+// share the buckets of the first one. Like counters, the Map only gets the
+// (labeled and unlabeled) count and sum of the observations.
+func (m *Metrics) NewHistogram(name string, buckets []float64, labels ...Labels) func(value float64) {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	s := m.series(name, kindHistogram, buckets, labels)
+	counts := m.expvarInts(name+"Count", s.labels)
+	sums := m.expvarFloats(name+"Sum", s.labels)
+
+	return func(v float64) {
+		s.mu.Lock()
+		for i, upper := range s.buckets {
+			if v <= upper {
+				s.counts[i]++
+				break
+			}
+		}
+		s.count++
+		s.value += v
+		s.mu.Unlock()
+
+		for i := range counts {
+			counts[i].Add(1)
+			sums[i].Add(v)
+		}
+	}
+}
+
+// ServeHTTP writes every metric in the Prometheus text format. Names are
+// prefixed with triple_c_ and converted to snake case (e.g., FailedTasks is
+// triple_c_failed_tasks_total).
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.expose())
+}
+
+func (m *Metrics) series(name, kind string, buckets []float64, labels []Labels) *series {
+	pairs := mergeLabels(labels)
+	key := expvarKey("", pairs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.families[name]
+	if !ok {
+		f = &family{
+			kind:    kind,
+			buckets: buckets,
+			series:  make(map[string]*series),
+		}
+		m.families[name] = f
+	}
+
+	if f.kind != kind {
+		panic(fmt.Sprintf("metric %s is already a %s", name, f.kind))
+	}
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labels:  pairs,
+			buckets: f.buckets,
+			counts:  make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// expvarInts returns the Map's Int for the name and labels and, if there are
+// labels, the one for just the name.
+func (m *Metrics) expvarInts(name string, labels []labelPair) []*expvar.Int {
+	if m.m == nil {
+		return nil
 	}
+
+	var ints []*expvar.Int
+	for _, key := range expvarKeys(name, labels) {
+		m.m.Add(key, 0)
+		ints = append(ints, m.m.Get(key).(*expvar.Int))
+	}
+	return ints
 }
 
-// NewGauge returns a func to be used to set the value of a gauge metric.
-func (m *Metrics) NewGauge(name string) func(value float64) {
+// expvarFloats is like expvarInts but for Floats.
+func (m *Metrics) expvarFloats(name string, labels []labelPair) []*expvar.Float {
 	if m.m == nil {
-		return func(_ float64) {}
+		return nil
+	}
+
+	var floats []*expvar.Float
+	for _, key := range expvarKeys(name, labels) {
+		m.m.AddFloat(key, 0)
+		floats = append(floats, m.m.Get(key).(*expvar.Float))
+	}
+	return floats
+}
+
+func (m *Metrics) expose() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
 	}
+	sort.Slice(names, func(i, j int) bool {
+		return promName(names[i]) < promName(names[j])
+	})
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := m.families[name]
+		pName := promName(name)
+		if f.kind == kindCounter && !strings.HasSuffix(pName, "_total") {
+			pName += "_total"
+		}
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", pName, f.kind)
+		for _, key := range keys {
+			s := f.series[key]
+			s.mu.Lock()
+
+			if f.kind != kindHistogram {
+				fmt.Fprintf(&buf, "%s%s %s\n", pName, promLabels(s.labels), formatFloat(s.value))
+				s.mu.Unlock()
+				continue
+			}
 
-	m.m.AddFloat(name, 0)
-	f := m.m.Get(name).(*expvar.Float)
+			var cumulative uint64
+			for i, upper := range f.buckets {
+				cumulative += s.counts[i]
+				le := append(s.labels[:len(s.labels):len(s.labels)], labelPair{"le", formatFloat(upper)})
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", pName, promLabels(le), cumulative)
+			}
+			le := append(s.labels[:len(s.labels):len(s.labels)], labelPair{"le", "+Inf"})
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", pName, promLabels(le), s.count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", pName, promLabels(s.labels), formatFloat(s.value))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", pName, promLabels(s.labels), s.count)
 
-	return f.Set
+			s.mu.Unlock()
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// mergeLabels merges the labels (later ones win) and sorts them by name.
+func mergeLabels(labels []Labels) []labelPair {
+	merged := make(Labels)
+	for _, l := range labels {
+		for k, v := range l {
+			merged[k] = v
+		}
+	}
+
+	pairs := make([]labelPair, 0, len(merged))
+	for k, v := range merged {
+		pairs = append(pairs, labelPair{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].name < pairs[j].name
+	})
+
+	return pairs
+}
+
+// expvarKey returns the name followed by the labels (e.g.,
+// FailedTasks{plan="some-plan"}). Unlabeled metrics keep their plain name.
+func expvarKey(name string, labels []labelPair) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + promLabels(labels)
+}
+
+func expvarKeys(name string, labels []labelPair) []string {
+	if len(labels) == 0 {
+		return []string{name}
+	}
+	return []string{expvarKey(name, labels), name}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabels(labels []labelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, promLabelName(l.name), labelEscaper.Replace(l.value)))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// promName converts a CamelCase name (e.g., GitSHASuccess) to a snake case
+// Prometheus name (e.g., triple_c_git_sha_success).
+func promName(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	b.WriteString("triple_c_")
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(promRune(unicode.ToLower(r)))
+	}
+
+	return b.String()
+}
+
+func promLabelName(name string) string {
+	return strings.Map(promRune, name)
+}
+
+func promRune(r rune) rune {
+	if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+		return r
+	}
+	return '_'
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
 }
@@ -2,6 +2,8 @@ package metrics_test
 
 import (
 	"expvar"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/poy/onpar"
@@ -46,10 +48,64 @@ func TestMetrics(t *testing.T) {
 		Expect(t, t.spyMap.getValue("some-gauge")).To(Equal(101.1))
 	})
 
+	o.Spec("publishes labeled metrics separately", func(t TM) {
+		t.m.NewCounter("some-counter", metrics.Labels{"plan": "a"})(1)
+		t.m.NewCounter("some-counter", metrics.Labels{"plan": "b"})(2)
+		t.m.NewCounter("some-counter", metrics.Labels{"plan": "a"})(3)
+
+		Expect(t, t.spyMap.getValue(`some-counter{plan="a"}`)).To(Equal(float64(4)))
+		Expect(t, t.spyMap.getValue(`some-counter{plan="b"}`)).To(Equal(float64(2)))
+		Expect(t, t.spyMap.getValue("some-counter")).To(Equal(float64(6)))
+	})
+
+	o.Spec("publishes the count and sum of a histogram", func(t TM) {
+		h := t.m.NewHistogram("some-histogram", nil, metrics.Labels{"repo": "some-repo"})
+		h(1.5)
+		h(2)
+
+		Expect(t, t.spyMap.getValue(`some-histogramCount{repo="some-repo"}`)).To(Equal(float64(2)))
+		Expect(t, t.spyMap.getValue(`some-histogramSum{repo="some-repo"}`)).To(Equal(3.5))
+		Expect(t, t.spyMap.getValue("some-histogramCount")).To(Equal(float64(2)))
+	})
+
+	o.Spec("serves the Prometheus text format", func(t TM) {
+		t.m.NewCounter("FailedTasks", metrics.Labels{"plan": "some-plan", "branch": "master"})(2)
+		t.m.NewGauge("GitSHAAge")(7.5)
+		h := t.m.NewHistogram("TaskDurationSeconds", []float64{1, 10}, metrics.Labels{"plan": `some-"plan"`})
+		h(0.5)
+		h(5)
+		h(50)
+
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://some.url/metrics", nil)
+		Expect(t, err).To(BeNil())
+		t.m.ServeHTTP(recorder, req)
+
+		Expect(t, recorder.Code).To(Equal(http.StatusOK))
+		Expect(t, recorder.Header().Get("Content-Type")).To(StartWith("text/plain; version=0.0.4"))
+		Expect(t, recorder.Body.String()).To(Equal(`# TYPE triple_c_failed_tasks_total counter
+triple_c_failed_tasks_total{branch="master",plan="some-plan"} 2
+# TYPE triple_c_git_sha_age gauge
+triple_c_git_sha_age 7.5
+# TYPE triple_c_task_duration_seconds histogram
+triple_c_task_duration_seconds_bucket{plan="some-\"plan\"",le="1"} 1
+triple_c_task_duration_seconds_bucket{plan="some-\"plan\"",le="10"} 2
+triple_c_task_duration_seconds_bucket{plan="some-\"plan\"",le="+Inf"} 3
+triple_c_task_duration_seconds_sum{plan="some-\"plan\""} 55.5
+triple_c_task_duration_seconds_count{plan="some-\"plan\""} 3
+`))
+	})
+
+	o.Spec("panics when a name is reused for another type", func(t TM) {
+		t.m.NewCounter("some-metric")
+		Expect(t, func() { t.m.NewGauge("some-metric") }).To(Panic())
+	})
+
 	o.Spec("deals with a nil map", func(t TM) {
 		t.m = metrics.New(nil)
 		Expect(t, func() { t.m.NewGauge("some-gauge") }).To(Not(Panic()))
 		Expect(t, func() { t.m.NewCounter("some-counter") }).To(Not(Panic()))
+		Expect(t, func() { t.m.NewHistogram("some-histogram", nil)(1) }).To(Not(Panic()))
 	})
 }
 
@@ -64,11 +120,17 @@ func newSpyMap() *spyMap {
 }
 
 func (s *spyMap) Add(key string, delta int64) {
-	s.m[key] = expvar.NewInt(key)
+	if _, ok := s.m[key]; !ok {
+		s.m[key] = new(expvar.Int)
+	}
+	s.m[key].(*expvar.Int).Add(delta)
 }
 
 func (s *spyMap) AddFloat(key string, delta float64) {
-	s.m[key] = expvar.NewFloat(key)
+	if _, ok := s.m[key]; !ok {
+		s.m[key] = new(expvar.Float)
+	}
+	s.m[key].(*expvar.Float).Add(delta)
 }
 
 func (s *spyMap) Get(key string) expvar.Var {
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped"
+)
+
+// Run is a single run of a plan for a commit.
+type Run struct {
+	ID        string
+	Plan      string
+	Branch    string
+	Repo      string
+	SHA       string
+	Tag       string
+	ConfigSHA string
+	Matrix    string
+
+	Status string
+
+	// Reason explains why a run was skipped or failed.
+	Reason string
+
+	Started  time.Time
+	Finished time.Time
+
+	Tasks []TaskRun
+}
+
+// TaskRun is a task within a Run.
+type TaskRun struct {
+	Index    int
+	Name     string
+	Status   string
+	Started  time.Time
+	Finished time.Time
+}
+
+// RunHistory keeps the most recent runs in memory.
+type RunHistory struct {
+	mu   sync.RWMutex
+	max  int
+	runs []*Run
+	ids  map[string]*Run
+}
+
+// NewRunHistory returns a RunHistory that remembers up to max runs.
+func NewRunHistory(max int) *RunHistory {
+	return &RunHistory{
+		max: max,
+		ids: make(map[string]*Run),
+	}
+}
+
+// Start records a new running run and returns its ID.
+func (h *RunHistory) Start(r Run) string {
+	r.Status = RunRunning
+	return h.add(r)
+}
+
+// Skip records a run that was not started and why.
+func (h *RunHistory) Skip(r Run, reason string) string {
+	r.Status = RunSkipped
+	r.Reason = reason
+	r.Finished = time.Now()
+	return h.add(r)
+}
+
+func (h *RunHistory) add(r Run) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r.ID = newRunID()
+	if r.Started.IsZero() {
+		r.Started = time.Now()
+	}
+
+	h.runs = append(h.runs, &r)
+	h.ids[r.ID] = &r
+
+	if len(h.runs) > h.max {
+		delete(h.ids, h.runs[0].ID)
+		h.runs = h.runs[1:]
+	}
+
+	return r.ID
+}
+
+// StartTask records that the task at the given index has started.
+func (h *RunHistory) StartTask(id string, index int, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.ids[id]
+	if !ok {
+		return
+	}
+
+	r.Tasks = append(r.Tasks, TaskRun{
+		Index:   index,
+		Name:    name,
+		Status:  RunRunning,
+		Started: time.Now(),
+	})
+}
+
+// FinishTask records the outcome of the task at the given index.
+func (h *RunHistory) FinishTask(id string, index int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.ids[id]
+	if !ok {
+		return
+	}
+
+	for i := range r.Tasks {
+		if r.Tasks[i].Index != index {
+			continue
+		}
+
+		r.Tasks[i].Finished = time.Now()
+		r.Tasks[i].Status = RunSucceeded
+		if err != nil {
+			r.Tasks[i].Status = RunFailed
+		}
+	}
+}
+
+// Finish records the outcome of the run. A non-empty reason means the run
+// failed.
+func (h *RunHistory) Finish(id string, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.ids[id]
+	if !ok {
+		return
+	}
+
+	r.Finished = time.Now()
+	r.Status = RunSucceeded
+	if reason != "" {
+		r.Status = RunFailed
+		r.Reason = reason
+	}
+}
+
+// Runs returns every run it remembers, newest first.
+func (h *RunHistory) Runs() []Run {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	results := make([]Run, 0, len(h.runs))
+	for i := len(h.runs) - 1; i >= 0; i-- {
+		results = append(results, copyRun(h.runs[i]))
+	}
+
+	return results
+}
+
+// Run returns the run with the given ID.
+func (h *RunHistory) Run(id string) (Run, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.ids[id]
+	if !ok {
+		return Run{}, false
+	}
+
+	return copyRun(r), true
+}
+
+func copyRun(r *Run) Run {
+	c := *r
+	c.Tasks = make([]TaskRun, len(r.Tasks))
+	copy(c.Tasks, r.Tasks)
+	return c
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,81 @@
+package metrics_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/metrics"
+)
+
+type TH struct {
+	*testing.T
+	h *metrics.RunHistory
+}
+
+func TestRunHistory(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TH {
+		return TH{
+			T: t,
+			h: metrics.NewRunHistory(2),
+		}
+	})
+
+	o.Spec("it records a run and its tasks", func(t TH) {
+		id := t.h.Start(metrics.Run{Plan: "some-plan", SHA: "some-sha"})
+		t.h.StartTask(id, 0, "task-a")
+		t.h.FinishTask(id, 0, nil)
+		t.h.StartTask(id, 1, "task-b")
+
+		r, ok := t.h.Run(id)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, r.Plan).To(Equal("some-plan"))
+		Expect(t, r.Status).To(Equal(metrics.RunRunning))
+		Expect(t, r.Tasks).To(HaveLen(2))
+		Expect(t, r.Tasks[0].Status).To(Equal(metrics.RunSucceeded))
+		Expect(t, r.Tasks[1].Status).To(Equal(metrics.RunRunning))
+
+		t.h.FinishTask(id, 1, errors.New("some-error"))
+		t.h.Finish(id, "task-b failed")
+
+		r, _ = t.h.Run(id)
+		Expect(t, r.Status).To(Equal(metrics.RunFailed))
+		Expect(t, r.Reason).To(Equal("task-b failed"))
+		Expect(t, r.Tasks[1].Status).To(Equal(metrics.RunFailed))
+		Expect(t, r.Finished.IsZero()).To(BeFalse())
+	})
+
+	o.Spec("it records skipped runs with a reason", func(t TH) {
+		id := t.h.Skip(metrics.Run{Plan: "some-plan"}, "some-reason")
+
+		r, ok := t.h.Run(id)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, r.Status).To(Equal(metrics.RunSkipped))
+		Expect(t, r.Reason).To(Equal("some-reason"))
+	})
+
+	o.Spec("it returns the newest runs first and forgets the oldest", func(t TH) {
+		id1 := t.h.Start(metrics.Run{Plan: "plan-1"})
+		t.h.Start(metrics.Run{Plan: "plan-2"})
+		t.h.Start(metrics.Run{Plan: "plan-3"})
+
+		var plans []string
+		for _, r := range t.h.Runs() {
+			plans = append(plans, r.Plan)
+		}
+		Expect(t, plans).To(Equal([]string{"plan-3", "plan-2"}))
+
+		_, ok := t.h.Run(id1)
+		Expect(t, ok).To(BeFalse())
+	})
+
+	o.Spec("it gives each run a unique ID", func(t TH) {
+		Expect(t, t.h.Start(metrics.Run{})).To(Not(Equal(t.h.Start(metrics.Run{}))))
+	})
+}
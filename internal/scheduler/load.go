@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FileReader reads a file from the config repo. Every file read while
+// loading a config must come from the same SHA so that the SHA identifies
+// the whole config.
+type FileReader func(filePath string) (string, error)
+
+// LoadPlans reads the plans files at filePaths along with every file they
+// include, merges them and fills in tasks that reference a template.
+// Included paths are relative to the file that includes them. Includes are
+// merged depth first in the order they are listed, followed by the including
+// file's own plans. Templates are shared between every file.
+func LoadPlans(read FileReader, filePaths ...string) (Plans, error) {
+	l := &loader{
+		read:      read,
+		templates: make(map[string]Task),
+		loading:   make(map[string]bool),
+		loaded:    make(map[string]bool),
+	}
+
+	for _, filePath := range filePaths {
+		if err := l.load(path.Clean(filePath)); err != nil {
+			return Plans{}, err
+		}
+	}
+
+	for i, plan := range l.plans {
+		for j, task := range plan.Tasks {
+			if task.Template == "" {
+				continue
+			}
+
+			tmpl, ok := l.templates[task.Template]
+			if !ok {
+				return Plans{}, fmt.Errorf("%s: plan %q task %d (%q): unknown template %q", plan.File, plan.Name, j, task.Name, task.Template)
+			}
+
+			l.plans[i].Tasks[j] = applyTemplate(tmpl, task)
+		}
+	}
+
+	return Plans{Plans: l.plans}, nil
+}
+
+type loader struct {
+	read      FileReader
+	plans     []Plan
+	templates map[string]Task
+	loading   map[string]bool
+	loaded    map[string]bool
+}
+
+func (l *loader) load(filePath string) error {
+	if l.loading[filePath] {
+		return fmt.Errorf("%s: include cycle", filePath)
+	}
+
+	// A file included more than once only contributes its plans once.
+	if l.loaded[filePath] {
+		return nil
+	}
+
+	l.loading[filePath] = true
+	defer delete(l.loading, filePath)
+
+	data, err := l.read(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", filePath, err)
+	}
+
+	p, err := ParsePlans([]byte(data))
+	if err != nil {
+		if pe, ok := err.(*ParseError); ok {
+			pe.File = filePath
+		}
+		return err
+	}
+
+	for _, inc := range p.Include {
+		if err := l.load(path.Join(path.Dir(filePath), inc)); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for name := range p.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := l.templates[name]; ok {
+			return fmt.Errorf("%s: template %q is already defined", filePath, name)
+		}
+		l.templates[name] = p.Templates[name]
+	}
+
+	for _, plan := range p.Plans {
+		plan.File = filePath
+		l.plans = append(l.plans, plan)
+	}
+	l.loaded[filePath] = true
+
+	return nil
+}
+
+// MatchFiles returns the files that the config path refers to, in order. The
+// config path may be a glob (e.g. pipelines/*.yml), a single file or a
+// directory, in which case every YAML file directly within it matches.
+func MatchFiles(files []string, configPath string) []string {
+	configPath = path.Clean(configPath)
+
+	var matches []string
+	for _, f := range files {
+		switch {
+		case f == configPath:
+			matches = append(matches, f)
+		case strings.ContainsAny(configPath, "*?["):
+			if ok, _ := path.Match(configPath, f); ok {
+				matches = append(matches, f)
+			}
+		case path.Dir(f) == configPath:
+			if ext := path.Ext(f); ext == ".yml" || ext == ".yaml" {
+				matches = append(matches, f)
+			}
+		}
+	}
+	sort.Strings(matches)
+
+	return matches
+}
+
+// applyTemplate returns the template with every field the task sets
+// overridden. Parameters are merged, with the task's taking precedence.
+func applyTemplate(tmpl, t Task) Task {
+	result := tmpl
+	result.Template = ""
+
+	if t.Name != "" {
+		result.Name = t.Name
+	}
+
+	if t.Input != "" {
+		result.Input = t.Input
+	}
+
+	if t.Output != "" {
+		result.Output = t.Output
+	}
+
+	if len(t.Inputs) > 0 {
+		result.Inputs = t.Inputs
+	}
+
+	if len(t.Outputs) > 0 {
+		result.Outputs = t.Outputs
+	}
+
+	if len(t.Caches) > 0 {
+		result.Caches = t.Caches
+	}
+
+	if t.Command != "" {
+		result.Command = t.Command
+	}
+
+	if t.BranchGuard != "" {
+		result.BranchGuard = t.BranchGuard
+	}
+
+	if len(tmpl.Parameters) > 0 || len(t.Parameters) > 0 {
+		result.Parameters = make(map[string]string)
+		for k, v := range tmpl.Parameters {
+			result.Parameters[k] = v
+		}
+		for k, v := range t.Parameters {
+			result.Parameters[k] = v
+		}
+	}
+
+	return result
+}
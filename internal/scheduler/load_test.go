@@ -0,0 +1,209 @@
+package scheduler_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/scheduler"
+)
+
+type TL struct {
+	*testing.T
+	files map[string]string
+	read  []string
+}
+
+func (t *TL) Read(filePath string) (string, error) {
+	t.read = append(t.read, filePath)
+	data, ok := t.files[filePath]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return data, nil
+}
+
+func TestLoadPlans(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) *TL {
+		return &TL{
+			T:     t,
+			files: make(map[string]string),
+		}
+	})
+
+	o.Spec("it loads the plans in a single file", func(t *TL) {
+		t.files["ci/config.yml"] = `
+plans:
+- name: some-plan
+  tasks:
+  - command: some-command
+`
+		p, err := scheduler.LoadPlans(t.Read, "ci/config.yml")
+		Expect(t, err).To(BeNil())
+		Expect(t, p.Plans).To(HaveLen(1))
+		Expect(t, p.Plans[0].Name).To(Equal("some-plan"))
+	})
+
+	o.Spec("it merges includes relative to the including file in order", func(t *TL) {
+		t.files["ci/config.yml"] = `
+include:
+- b.yml
+- sub/a.yml
+plans:
+- name: plan-c
+`
+		t.files["ci/b.yml"] = `
+plans:
+- name: plan-b
+`
+		t.files["ci/sub/a.yml"] = `
+include:
+- ../b.yml
+plans:
+- name: plan-a
+`
+		p, err := scheduler.LoadPlans(t.Read, "ci/config.yml")
+		Expect(t, err).To(BeNil())
+
+		var names []string
+		for _, plan := range p.Plans {
+			names = append(names, plan.Name)
+		}
+		Expect(t, names).To(Equal([]string{"plan-b", "plan-a", "plan-c"}))
+	})
+
+	o.Spec("it merges several files and records where each plan came from", func(t *TL) {
+		t.files["pipelines/a.yml"] = `
+include: [../common.yml]
+plans:
+- name: plan-a
+`
+		t.files["pipelines/b.yml"] = `
+plans:
+- name: plan-b
+`
+		t.files["common.yml"] = `
+plans:
+- name: plan-common
+`
+		p, err := scheduler.LoadPlans(t.Read, "pipelines/a.yml", "pipelines/b.yml")
+		Expect(t, err).To(BeNil())
+
+		files := make(map[string]string)
+		for _, plan := range p.Plans {
+			files[plan.Name] = plan.File
+		}
+		Expect(t, files).To(Equal(map[string]string{
+			"plan-common": "common.yml",
+			"plan-a":      "pipelines/a.yml",
+			"plan-b":      "pipelines/b.yml",
+		}))
+	})
+
+	o.Spec("it matches config files by glob, file or directory", func(t *TL) {
+		files := []string{
+			"README.md",
+			"ci/config.yml",
+			"pipelines/b.yml",
+			"pipelines/a.yaml",
+			"pipelines/notes.txt",
+			"pipelines/nested/c.yml",
+		}
+
+		Expect(t, scheduler.MatchFiles(files, "ci/config.yml")).To(Equal([]string{"ci/config.yml"}))
+		Expect(t, scheduler.MatchFiles(files, "pipelines/*.yml")).To(Equal([]string{"pipelines/b.yml"}))
+		Expect(t, scheduler.MatchFiles(files, "pipelines/")).To(Equal([]string{"pipelines/a.yaml", "pipelines/b.yml"}))
+		Expect(t, scheduler.MatchFiles(files, "missing/*.yml")).To(HaveLen(0))
+	})
+
+	o.Spec("it returns an error for an include cycle", func(t *TL) {
+		t.files["a.yml"] = "include: [b.yml]"
+		t.files["b.yml"] = "include: [a.yml]"
+
+		_, err := scheduler.LoadPlans(t.Read, "a.yml")
+		Expect(t, err).To(Not(BeNil()))
+		Expect(t, err.Error()).To(ContainSubstring("include cycle"))
+	})
+
+	o.Spec("it returns an error when an include can't be read", func(t *TL) {
+		t.files["a.yml"] = "include: [missing.yml]"
+
+		_, err := scheduler.LoadPlans(t.Read, "a.yml")
+		Expect(t, err).To(Not(BeNil()))
+		Expect(t, err.Error()).To(ContainSubstring("missing.yml"))
+	})
+
+	o.Spec("it names the file that failed to parse", func(t *TL) {
+		t.files["a.yml"] = "include: [b.yml]"
+		t.files["b.yml"] = "\nplans:\n- nmae: typo\n"
+
+		_, err := scheduler.LoadPlans(t.Read, "a.yml")
+		pe, ok := err.(*scheduler.ParseError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, pe.File).To(Equal("b.yml"))
+		Expect(t, pe.Line).To(Equal(3))
+	})
+
+	o.Spec("it fills in tasks from templates", func(t *TL) {
+		t.files["templates.yml"] = `
+templates:
+  go-test:
+    name: test
+    output: binary
+    command: go test ./...
+    parameters:
+      GO_VERSION: "1.10"
+      GOFLAGS: -race
+`
+		t.files["config.yml"] = `
+include: [templates.yml]
+plans:
+- name: some-plan
+  tasks:
+  - template: go-test
+    name: unit
+    parameters:
+      GO_VERSION: "1.11"
+`
+		p, err := scheduler.LoadPlans(t.Read, "config.yml")
+		Expect(t, err).To(BeNil())
+		Expect(t, p.Plans[0].Tasks).To(Equal([]scheduler.Task{
+			{
+				Name:    "unit",
+				Output:  "binary",
+				Command: "go test ./...",
+				Parameters: map[string]string{
+					"GO_VERSION": "1.11",
+					"GOFLAGS":    "-race",
+				},
+			},
+		}))
+	})
+
+	o.Spec("it returns an error for an unknown template", func(t *TL) {
+		t.files["config.yml"] = `
+plans:
+- name: some-plan
+  tasks:
+  - template: missing
+`
+		_, err := scheduler.LoadPlans(t.Read, "config.yml")
+		Expect(t, err).To(Not(BeNil()))
+		Expect(t, err.Error()).To(ContainSubstring(`unknown template "missing"`))
+	})
+
+	o.Spec("it returns an error for a template defined twice", func(t *TL) {
+		t.files["a.yml"] = "include: [b.yml]\ntemplates:\n  x:\n    command: a\n"
+		t.files["b.yml"] = "templates:\n  x:\n    command: b\n"
+
+		_, err := scheduler.LoadPlans(t.Read, "a.yml")
+		Expect(t, err).To(Not(BeNil()))
+		Expect(t, err.Error()).To(ContainSubstring(`template "x" is already defined`))
+	})
+}
@@ -4,37 +4,47 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/poy/triple-c/internal/artifacts"
 	"github.com/poy/triple-c/internal/git"
+	"github.com/poy/triple-c/internal/metrics"
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 type Manager struct {
-	log             *log.Logger
-	m               Metrics
-	successfulTasks func(delta uint64)
-	failedTasks     func(delta uint64)
-	failedRepos     func(delta uint64)
-	dedupedTasks    func(delta uint64)
-	appGuid         string
-	branch          string
-	ps              ParameterStore
+	log     *slog.Logger
+	m       Metrics
+	tracer  *tracing.Tracer
+	appGuid string
+	branch  string
+	apiURL  string
+	ps      ParameterStore
 
 	taskCreator TaskCreator
+	executors   map[string]TaskCreator
 	shaTracker  git.SHATracker
 	transfer    Transfer
+	history     RunHistory
 
-	startWatcher GitWatcher
-	repoRegistry RepoRegistry
+	startWatcher    GitWatcher
+	startTagWatcher TagWatcher
+	repoRegistry    RepoRegistry
 
 	mu   sync.Mutex
 	ctxs map[encodedTask]func()
+
+	// seenTags are the tags read for each plan (and matrix entry) and repo,
+	// kept across the plan being replaced.
+	seenTags map[string]*git.SeenTags
 }
 
 type GitWatcher func(
@@ -43,13 +53,28 @@ type GitWatcher func(
 	branch string,
 	commit func(SHA string),
 	interval time.Duration,
+	maxCommits int,
 	repo git.Repo,
 	shaTracker git.SHATracker,
-	log *log.Logger,
+	log *slog.Logger,
+)
+
+type TagWatcher func(
+	ctx context.Context,
+	lister git.TagLister,
+	patterns []string,
+	seen *git.SeenTags,
+	callback func(tag, SHA string),
+	backoff time.Duration,
+	m git.Metrics,
+	log *slog.Logger,
 )
 
 type TaskCreator interface {
+	// CreateTask runs the command and blocks until it is done. The context
+	// carries the span of the task.
 	CreateTask(
+		ctx context.Context,
 		command string,
 		name string,
 		appGuid string,
@@ -61,7 +86,8 @@ type TaskCreator interface {
 type ParameterStore func(key string) (string, bool)
 
 type Metrics interface {
-	NewCounter(name string) func(delta uint64)
+	NewCounter(name string, labels ...metrics.Labels) func(delta uint64)
+	NewHistogram(name string, buckets []float64, labels ...metrics.Labels) func(value float64)
 }
 
 type RepoRegistry interface {
@@ -69,47 +95,62 @@ type RepoRegistry interface {
 }
 
 type Transfer interface {
-	InitInterconnect(ctx context.Context) string
+	InitInterconnect(ctx context.Context, runID, name string) (upload, download string)
+
+	// InitCache returns the URLs a task of the run saves and restores the
+	// cache with the given ID through.
+	InitCache(ctx context.Context, runID, id string) (upload, download string)
 }
 
+// RunHistory records each run of a plan.
+type RunHistory interface {
+	Start(r metrics.Run) string
+	Skip(r metrics.Run, reason string) string
+	StartTask(id string, index int, name string)
+	FinishTask(id string, index int, err error)
+	Finish(id string, reason string)
+}
+
+// NewManager returns a Manager for the plans of a config branch. tw may be
+// nil, in which case plans triggered by tags are not run from the branch.
 func NewManager(
 	ctx context.Context,
 	appGuid string,
 	branch string,
+	apiURL string,
 	tc TaskCreator,
+	executors map[string]TaskCreator,
 	w GitWatcher,
+	tw TagWatcher,
 	repoRegistry RepoRegistry,
 	ps ParameterStore,
 	shaTracker git.SHATracker,
 	transfer Transfer,
+	history RunHistory,
 	m Metrics,
-	log *log.Logger,
+	tracer *tracing.Tracer,
+	log *slog.Logger,
 ) *Manager {
-
-	successfulTasks := m.NewCounter("SuccessfulTasks")
-	failedTasks := m.NewCounter("FailedTasks")
-	dedupedTasks := m.NewCounter("DedupedTasks")
-	failedRepos := m.NewCounter("FailedRepos")
-
 	return &Manager{
-		log:          log,
-		startWatcher: w,
-		repoRegistry: repoRegistry,
-		appGuid:      appGuid,
-		branch:       branch,
-		m:            m,
-		ps:           ps,
+		log:             log,
+		startWatcher:    w,
+		startTagWatcher: tw,
+		repoRegistry:    repoRegistry,
+		appGuid:         appGuid,
+		branch:          branch,
+		apiURL:          apiURL,
+		m:               m,
+		tracer:          tracer,
+		ps:              ps,
 
 		shaTracker:  shaTracker,
 		taskCreator: tc,
+		executors:   executors,
 		transfer:    transfer,
+		history:     history,
 
-		successfulTasks: successfulTasks,
-		failedTasks:     failedTasks,
-		failedRepos:     failedRepos,
-		dedupedTasks:    dedupedTasks,
-
-		ctxs: make(map[encodedTask]func()),
+		ctxs:     make(map[encodedTask]func()),
+		seenTags: make(map[string]*git.SeenTags),
 	}
 }
 
@@ -117,7 +158,7 @@ func (m *Manager) Add(t MetaPlan) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.log.Printf("Adding task: %+v", t)
+	m.log.Debug("adding plan", "plan", t.Name, "definition", fmt.Sprintf("%+v", t))
 	ctx, cancel := context.WithCancel(context.Background())
 	m.ctxs[encodePlan(t)] = cancel
 
@@ -126,8 +167,8 @@ func (m *Manager) Add(t MetaPlan) {
 	for _, repoPath := range t.RepoPaths {
 		repo, err := m.repoRegistry.FetchRepo(repoPath.Repo)
 		if err != nil {
-			m.log.Printf("failed to fetch repo %s: %s", repoPath, err)
-			m.failedRepos(1)
+			m.log.Error("failed to fetch repo", "plan", t.Name, "repo", repoPath.Repo, "err", err)
+			m.count("FailedRepos", t, m.branch)
 			return
 		}
 
@@ -136,14 +177,53 @@ func (m *Manager) Add(t MetaPlan) {
 			branch = m.branch
 		}
 
+		repoPath := repoPath
+
+		if len(t.On.Tags) > 0 {
+			// Tags are only watched from one config branch, so that a tag
+			// isn't built once per branch the plan is on.
+			if m.startTagWatcher == nil {
+				m.log.Info("not watching tags on this branch", "plan", t.Name, "repo", repoPath.Repo)
+				continue
+			}
+
+			key := fmt.Sprintf("%s/%s/%s", t.Name, matrixID(t.MatrixValues), repoPath.Repo)
+			seen, ok := m.seenTags[key]
+			if !ok {
+				seen = git.NewSeenTags()
+				m.seenTags[key] = seen
+			}
+
+			m.startTagWatcher(
+				ctx,
+				repo,
+				t.On.Tags,
+				seen,
+				func(tag, SHA string) {
+					r := runInfo{SHA: SHA, branch: branch, repo: repoPath.Repo, tag: tag}
+					m.startPlanForSHA(r, "", repoPath, repo, t, &taskLock)
+				},
+				15*time.Second,
+				m.m,
+				m.log,
+			)
+			continue
+		}
+
+		var lastSHA string
+
 		m.startWatcher(
 			ctx,
 			repoPath.Repo,
 			branch,
 			func(SHA string) {
-				m.startPlanForSHA(SHA, branch, t, &taskLock)
+				prevSHA := lastSHA
+				lastSHA = SHA
+				r := runInfo{SHA: SHA, branch: branch, repo: repoPath.Repo}
+				m.startPlanForSHA(r, prevSHA, repoPath, repo, t, &taskLock)
 			},
 			15*time.Second,
+			repoPath.maxCommits(),
 			repo,
 			m.shaTracker,
 			m.log,
@@ -151,118 +231,402 @@ func (m *Manager) Add(t MetaPlan) {
 	}
 }
 
-func (m *Manager) startPlanForSHA(SHA, branch string, t MetaPlan, taskLock *sync.Mutex) {
+func (m *Manager) startPlanForSHA(r runInfo, prevSHA string, repoPath Repo, repo git.Repo, t MetaPlan, taskLock *sync.Mutex) {
 	if !m.checkAndRemove(t, t.DoOnce) {
 		return
 	}
 
-	dupe, err := m.duplicate(branch, SHA, t.ConfigSHA)
+	SHA, branch := r.SHA, r.branch
+	r.ready = time.Now()
+
+	var span *tracing.Span
+	r.ctx, span = m.startRunSpan(r, t)
+	defer span.End()
+	r.log = m.runLog(r, t)
+
+	tc, err := m.executor(t)
 	if err != nil {
-		m.log.Printf("failed deduping tasks: %s", err)
+		r.log.Error("failed to start plan", "err", err)
+		span.RecordError(err)
+		m.count("FailedTasks", t, branch)
+		return
+	}
+
+	_, commitSpan := m.tracer.Start(r.ctx, "git.commit")
+	if c, err := repo.Commit(SHA); err != nil {
+		r.log.Warn("failed to read commit", "err", err)
+		commitSpan.RecordError(err)
+	} else {
+		r.commit = &c
+	}
+	commitSpan.End()
+
+	if reason := m.skipReason(r, repoPath, repo, prevSHA); reason != "" {
+		r.log.Info("skipping plan", "reason", reason)
+		span.SetAttributes("skipped", reason)
+		m.count("SkippedRuns", t, branch)
+		m.history.Skip(m.newRun(r, t), reason)
+		return
+	}
+
+	dupe, err := m.duplicate(tc, r, t)
+	if err != nil {
+		r.log.Error("failed deduping tasks", "err", err)
+		span.RecordError(err)
 		return
 	}
 
 	if dupe {
-		m.log.Printf("skipping task for %s on branch %s", SHA, branch)
-		m.dedupedTasks(1)
+		r.log.Info("skipping plan that has already run")
+		span.SetAttributes("skipped", "duplicate")
+		m.count("DedupedTasks", t, branch)
 		return
 	}
 
 	taskLock.Lock()
 	defer taskLock.Unlock()
 
-	var inputs, outputs []ioAddr
+	m.runPlan(tc, r, t)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
+// skipReason returns why the plan should not run for the SHA, or an empty
+// string if it should. A plan is skipped when the commit message asks for
+// it, or when none of the files changed since the previous SHA pass the
+// repo's path filter.
+func (m *Manager) skipReason(r runInfo, repoPath Repo, repo git.Repo, prevSHA string) string {
+	SHA := r.SHA
+
+	// Tags are pushed deliberately, so a skip directive in the tagged
+	// commit's message does not apply.
+	if r.tag == "" && r.commit != nil && r.commit.SkipCI() {
+		return "commit message contains a skip directive"
+	}
+
+	if repoPath.Paths.Empty() || prevSHA == "" {
+		return ""
+	}
+
+	_, span := m.tracer.Start(r.ctx, "git.diff")
+	files, err := repo.Diff(prevSHA, SHA)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		r.log.Warn("failed to diff, running anyway", "from_sha", prevSHA, "err", err)
+		return ""
+	}
+
+	if repoPath.Paths.Matches(files) {
+		return ""
+	}
+
+	return fmt.Sprintf("no files changed between %s and %s match the path filter", prevSHA, SHA)
+}
+
+// runInfo describes a single run of a plan.
+type runInfo struct {
+	id     string
+	SHA    string
+	branch string
+	repo   string
+
+	// tag is set when the run was triggered by a new tag.
+	tag string
+
+	// taskIndex is the index of the task currently being started.
+	taskIndex int
+
+	// log has the run's plan, branch, repo and SHA, its ID once it has
+	// started and the index of the task currently being started.
+	log *slog.Logger
+
+	// ready is when the task currently being started could have started:
+	// when the run was triggered, or when the previous task finished.
+	ready time.Time
+
+	// commit is the commit that triggered the run. It is nil when the
+	// commit could not be read.
+	commit *git.Commit
+
+	// ctx carries the span of the run or, while a task is being started,
+	// the span of the task.
+	ctx context.Context
+}
+
+// startRunSpan starts the span that covers the whole run, from what
+// triggered it (a commit, a tag or Run) to the last task.
+func (m *Manager) startRunSpan(r runInfo, t MetaPlan) (context.Context, *tracing.Span) {
+	ctx, span := m.tracer.Start(context.Background(), "plan.run")
+	span.SetAttributes("plan", t.Name, "branch", r.branch, "sha", r.SHA)
+
+	switch {
+	case r.tag != "":
+		span.SetAttributes("trigger", "tag", "repo", r.repo, "tag", r.tag)
+	case r.repo != "":
+		span.SetAttributes("trigger", "commit", "repo", r.repo)
+	default:
+		span.SetAttributes("trigger", "run")
+	}
+
+	return ctx, span
+}
+
+// runLog returns the logger of a run, which adds the fields needed to find
+// every message about it.
+func (m *Manager) runLog(r runInfo, t MetaPlan) *slog.Logger {
+	log := m.log.With("plan", t.Name, "branch", r.branch, "sha", r.SHA)
+	if r.repo != "" {
+		log = log.With("repo", r.repo)
+	}
+	if r.tag != "" {
+		log = log.With("tag", r.tag)
+	}
+	if len(t.MatrixValues) > 0 {
+		log = log.With("matrix", matrixID(t.MatrixValues))
+	}
+	if span := tracing.SpanFromContext(r.ctx); span != nil {
+		log = log.With("trace_id", span.TraceID())
+	}
+	return log
+}
+
+func (m *Manager) newRun(r runInfo, t MetaPlan) metrics.Run {
+	return metrics.Run{
+		Plan:      t.Name,
+		Branch:    r.branch,
+		Repo:      r.repo,
+		SHA:       r.SHA,
+		Tag:       r.tag,
+		ConfigSHA: t.ConfigSHA,
+		Matrix:    matrixID(t.MatrixValues),
+	}
+}
+
+// Run runs each of the plan's tasks for the given SHA in order and blocks
+// until they are done. Unlike a commit coming through a watcher, it does not
+// skip tasks that have already been run. It returns false if any task fails.
+func (m *Manager) Run(SHA, branch string, t MetaPlan) bool {
+	r := runInfo{SHA: SHA, branch: branch, ready: time.Now()}
+
+	var span *tracing.Span
+	r.ctx, span = m.startRunSpan(r, t)
+	defer span.End()
+	r.log = m.runLog(r, t)
+
+	tc, err := m.executor(t)
+	if err != nil {
+		r.log.Error("failed to start plan", "err", err)
+		span.RecordError(err)
+		m.count("FailedTasks", t, branch)
+		return false
+	}
+
+	return m.runPlan(tc, r, t)
+}
+
+func (m *Manager) runPlan(tc TaskCreator, r runInfo, t MetaPlan) bool {
+	r.id = m.history.Start(m.newRun(r, t))
+	r.log = r.log.With("run_id", r.id)
+
+	span := tracing.SpanFromContext(r.ctx)
+	span.SetAttributes("run_id", r.id)
+
+	io := make([]taskIO, len(t.Tasks))
+
+	ctx, cancel := context.WithCancel(r.ctx)
 	defer cancel()
 
+	// downloads maps the name of each output to where later tasks get it.
+	downloads := make(map[string]string)
+
 	for taskIndex, task := range t.Tasks {
-		if taskIndex == 0 {
-			inputs = append(inputs, ioAddr{})
-		} else if outputs[taskIndex-1].ioAddr != "" {
-			inputs = append(inputs, ioAddr{
-				ioAddr:   outputs[taskIndex-1].ioAddr,
-				name:     task.Input,
-				fromName: outputs[taskIndex-1].name,
+		var prev *Task
+		if taskIndex > 0 {
+			prev = &t.Tasks[taskIndex-1]
+		}
+
+		for _, in := range task.inputs(prev) {
+			addr, ok := downloads[in.Name]
+			if !ok {
+				r.log.Error("mismatch for inputs and outputs", "task_index", taskIndex, "task", task.Name, "input", in.Name)
+				m.count("FailedTasks", t, r.branch)
+				reason := fmt.Sprintf("task %d (%s) has input %q but no earlier task outputs it", taskIndex, task.Name, in.dir())
+				span.RecordError(errors.New(reason))
+				m.history.Finish(r.id, reason)
+				return false
+			}
+
+			io[taskIndex].inputs = append(io[taskIndex].inputs, ioAddr{
+				addr: addr,
+				name: in.Name,
+				dir:  in.dir(),
+			})
+		}
+
+		for _, out := range task.outputs() {
+			upload, download := m.transfer.InitInterconnect(ctx, r.id, out.Name)
+			downloads[out.Name] = download
+
+			io[taskIndex].outputs = append(io[taskIndex].outputs, ioAddr{
+				addr: upload,
+				name: out.Name,
+				dir:  out.dir(),
 			})
-		} else if task.Input != "" {
-			m.log.Fatalf("mismatch for inputs and outputs: %+v", task)
-		} else {
-			inputs = append(inputs, ioAddr{})
 		}
 
-		if task.Output == "" {
-			outputs = append(outputs, ioAddr{})
-		} else {
-			outputs = append(outputs, ioAddr{
-				ioAddr: m.transfer.InitInterconnect(ctx),
-				name:   task.Output,
+		for _, dir := range task.Caches {
+			id := artifacts.CacheID(t.Name, r.branch, matrixID(t.MatrixValues), task.Name, path.Clean(dir))
+			upload, download := m.transfer.InitCache(ctx, r.id, id)
+			io[taskIndex].caches = append(io[taskIndex].caches, cacheAddr{
+				id:       id,
+				upload:   upload,
+				download: download,
+				dir:      dir,
 			})
 		}
 	}
 
 	for taskIndex, task := range t.Tasks {
-		if task.BranchGuard != "" && task.BranchGuard != branch {
-			m.log.Printf("skipping task for %s on branch %s (BranchGuard %s)", SHA, branch, task.BranchGuard)
+		if task.BranchGuard != "" && task.BranchGuard != r.branch {
+			r.log.Info("skipping task for another branch", "task_index", taskIndex, "task", task.Name, "branch_guard", task.BranchGuard)
 			continue
 		}
 
-		if !m.startTaskForSHA(SHA, branch, task, t, taskIndex, inputs[taskIndex], outputs[taskIndex]) {
-			return
+		if !m.startTaskForSHA(tc, r, task, t, taskIndex, io[taskIndex]) {
+			reason := fmt.Sprintf("task %d (%s) failed", taskIndex, task.Name)
+			span.RecordError(errors.New(reason))
+			m.history.Finish(r.id, reason)
+			return false
 		}
+		r.ready = time.Now()
 	}
+
+	m.history.Finish(r.id, "")
+	return true
 }
 
+// ioAddr describes an artifact passed between tasks: where an input is
+// downloaded from or an output is PUT, and the directory it is extracted to
+// or archived from.
 type ioAddr struct {
-	ioAddr   string
-	name     string
-	fromName string
+	addr string
+	name string
+	dir  string
+}
+
+// cacheAddr describes a cache of a task: where it is restored from and
+// saved to, and the directory it holds.
+type cacheAddr struct {
+	id       string
+	upload   string
+	download string
+	dir      string
+}
+
+// taskIO is everything a task downloads before and uploads after its
+// command.
+type taskIO struct {
+	inputs  []ioAddr
+	outputs []ioAddr
+	caches  []cacheAddr
 }
 
-func (m *Manager) startTaskForSHA(SHA, branch string, task Task, t MetaPlan, taskIndex int, input, output ioAddr) bool {
-	m.log.Printf("starting task for %s on branch %s", SHA, branch)
-	defer m.log.Printf("done with task for %s on branch %s", SHA, branch)
+func (m *Manager) startTaskForSHA(tc TaskCreator, r runInfo, task Task, t MetaPlan, taskIndex int, io taskIO) bool {
+	SHA, branch := r.SHA, r.branch
+	r.log = r.log.With("task_index", taskIndex, "task", task.Name)
+	r.log.Info("starting task")
+	defer r.log.Info("done with task")
 
 	name, err := json.Marshal(struct {
 		SHA       string `json:"sha"`
 		Branch    string `json:"branch"`
 		TaskIndex int    `json:"task_index"`
 		ConfigSHA string `json:"config_sha"`
+		Matrix    string `json:"matrix,omitempty"`
+		Tag       string `json:"tag,omitempty"`
 	}{
 		SHA:       SHA,
 		Branch:    branch,
 		TaskIndex: taskIndex,
 		ConfigSHA: t.ConfigSHA,
+		Matrix:    matrixID(t.MatrixValues),
+		Tag:       r.tag,
 	})
 	if err != nil {
-		m.log.Printf("failed to marshal task name: %s", err)
+		r.log.Error("failed to marshal task name", "err", err)
 		return false
 	}
 
-	err = m.taskCreator.CreateTask(
-		m.fetchRepo(t, task, branch, m.ps, input, output),
+	r.taskIndex = taskIndex
+	m.history.StartTask(r.id, taskIndex, task.Name)
+
+	var span *tracing.Span
+	r.ctx, span = m.tracer.Start(r.ctx, "task")
+	span.SetAttributes("task", task.Name, "task_index", taskIndex)
+	defer span.End()
+
+	labels := []metrics.Labels{planLabels(t, branch), {"task": task.Name}}
+	start := time.Now()
+	m.m.NewHistogram("TaskQueueSeconds", metrics.DurationBuckets, labels...)(start.Sub(r.ready).Seconds())
+
+	err = tc.CreateTask(
+		r.ctx,
+		m.fetchRepo(t, task, r, m.ps, io),
 		base64.StdEncoding.EncodeToString(name),
 		m.appGuid,
 	)
+	m.m.NewHistogram("TaskDurationSeconds", metrics.DurationBuckets, labels...)(time.Since(start).Seconds())
+	m.history.FinishTask(r.id, taskIndex, err)
 	if err != nil {
-		m.log.Printf("task for %s failed: %s", SHA, err)
-		m.failedTasks(1)
+		r.log.Error("task failed", "err", err)
+		span.RecordError(err)
+		m.count("FailedTasks", t, branch)
 		return false
 	}
 
-	m.log.Printf("task for %s on branch %s succeeded", SHA, branch)
-	m.successfulTasks(1)
+	r.log.Info("task succeeded")
+	m.count("SuccessfulTasks", t, branch)
 	return true
 }
 
-func (m *Manager) duplicate(branch, SHA, configSHA string) (bool, error) {
-	tasks, err := m.taskCreator.ListTasks(m.appGuid)
+// count increments the plan's counter with the given name. Counters are
+// labeled with the plan and branch so that, e.g., failure rates can be told
+// apart per plan.
+func (m *Manager) count(name string, t MetaPlan, branch string) {
+	m.m.NewCounter(name, planLabels(t, branch))(1)
+}
+
+func planLabels(t MetaPlan, branch string) metrics.Labels {
+	return metrics.Labels{"plan": t.Name, "branch": branch}
+}
+
+// executor returns the TaskCreator selected by the plan. Plans that don't
+// name an executor use the default one.
+func (m *Manager) executor(t MetaPlan) (TaskCreator, error) {
+	if t.Executor == "" {
+		return m.taskCreator, nil
+	}
+
+	tc, ok := m.executors[t.Executor]
+	if !ok {
+		return nil, fmt.Errorf("unknown executor %q", t.Executor)
+	}
+
+	return tc, nil
+}
+
+func (m *Manager) duplicate(tc TaskCreator, r runInfo, t MetaPlan) (bool, error) {
+	_, span := m.tracer.Start(r.ctx, "tasks.list")
+	tasks, err := tc.ListTasks(m.appGuid)
+	span.SetAttributes("tasks", len(tasks))
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		return false, err
 	}
 
-	for _, t := range tasks {
-		data, err := base64.StdEncoding.DecodeString(t)
+	matrix := matrixID(t.MatrixValues)
+	for _, name := range tasks {
+		data, err := base64.StdEncoding.DecodeString(name)
 		if err != nil {
 			continue
 		}
@@ -271,12 +635,18 @@ func (m *Manager) duplicate(branch, SHA, configSHA string) (bool, error) {
 			SHA       string `json:"sha"`
 			Branch    string `json:"branch"`
 			ConfigSHA string `json:"config_sha"`
+			Matrix    string `json:"matrix"`
+			Tag       string `json:"tag"`
 		}
 		if err := json.Unmarshal(data, &taskMeta); err != nil {
 			continue
 		}
 
-		if taskMeta.Branch == branch && taskMeta.SHA == SHA && taskMeta.ConfigSHA == configSHA {
+		if taskMeta.Branch == r.branch &&
+			taskMeta.SHA == r.SHA &&
+			taskMeta.ConfigSHA == t.ConfigSHA &&
+			taskMeta.Matrix == matrix &&
+			taskMeta.Tag == r.tag {
 			return true, nil
 		}
 	}
@@ -311,14 +681,41 @@ type encodedTask string
 func encodePlan(p MetaPlan) encodedTask {
 	parameters := []string{
 		p.Name,
+		p.Executor,
 	}
 
 	for k, v := range p.RepoPaths {
 		parameters = append(parameters, k, v.Repo, v.Branch)
+		for _, pattern := range v.Paths.Include {
+			parameters = append(parameters, fmt.Sprintf("include:%s:%s", k, pattern))
+		}
+		for _, pattern := range v.Paths.Exclude {
+			parameters = append(parameters, fmt.Sprintf("exclude:%s:%s", k, pattern))
+		}
+		if v.EveryCommit {
+			parameters = append(parameters, fmt.Sprintf("every_commit:%s:%d", k, v.maxCommits()))
+		}
+	}
+
+	if len(p.MatrixValues) > 0 {
+		parameters = append(parameters, "matrix:"+matrixID(p.MatrixValues))
+	}
+
+	for _, tag := range p.On.Tags {
+		parameters = append(parameters, "tag:"+tag)
 	}
 
 	for _, t := range p.Tasks {
 		parameters = append(parameters, t.Command, t.Name)
+		for _, in := range t.inputs(nil) {
+			parameters = append(parameters, fmt.Sprintf("in:%s:%s", in.Name, in.dir()))
+		}
+		for _, out := range t.outputs() {
+			parameters = append(parameters, fmt.Sprintf("out:%s:%s", out.Name, out.dir()))
+		}
+		for _, dir := range t.Caches {
+			parameters = append(parameters, "cache:"+dir)
+		}
 		for k, v := range t.Parameters {
 			parameters = append(parameters, fmt.Sprintf("%s=%s", k, v))
 		}
@@ -329,8 +726,24 @@ func encodePlan(p MetaPlan) encodedTask {
 }
 
 // fetchRepo adds the cloning of a repo to the given command
-func (m *Manager) fetchRepo(p MetaPlan, t Task, branch string, ps ParameterStore, input, output ioAddr) string {
+func (m *Manager) fetchRepo(p MetaPlan, t Task, r runInfo, ps ParameterStore, io taskIO) string {
+	branch := r.branch
+
 	var parameters string
+	for _, kv := range m.standardEnv(p, t, r) {
+		parameters = fmt.Sprintf("%sexport %s=%s\n", parameters, kv[0], shellQuote(kv[1]))
+	}
+
+	var axes []string
+	for k := range p.MatrixValues {
+		axes = append(axes, k)
+	}
+	sort.Strings(axes)
+
+	for _, k := range axes {
+		parameters = fmt.Sprintf("%sexport %s=%s\n", parameters, k, shellQuote(p.MatrixValues[k]))
+	}
+
 	for k, v := range t.Parameters {
 		if !strings.HasPrefix(v, "((") || !strings.HasSuffix(v, "))") {
 			parameters = fmt.Sprintf("%sexport %s=%s\n", parameters, k, v)
@@ -351,6 +764,10 @@ func (m *Manager) fetchRepo(p MetaPlan, t Task, branch string, ps ParameterStore
 			b = branch
 		}
 
+		if r.tag != "" && repoPath.Repo == r.repo {
+			b = r.tag
+		}
+
 		clones = fmt.Sprintf(`
 %s
 rm -rf %s
@@ -371,56 +788,110 @@ set +x
 			path.Base(repoPath.Repo),
 			repoPath.Repo,
 			path.Base(repoPath.Repo),
-			b,
+			shellQuote(b),
 		)
 	}
 
+	// Transfers carry the task's span, so that they are part of its trace.
+	var wgetHeader, curlHeader string
+	if span := tracing.SpanFromContext(r.ctx); span != nil {
+		wgetHeader = " --header " + shellQuote("traceparent: "+span.TraceParent())
+		curlHeader = " -H " + shellQuote("traceparent: "+span.TraceParent())
+	}
+
+	// The input, output and cache URLs carry tokens (or are presigned), so
+	// they are fetched without -x to keep them out of the task's log.
 	var gatherInput string
-	if input.ioAddr != "" {
-		gatherInput = fmt.Sprintf(`
-set -ex
-pushd /home/vcap/app
-  wget %s -O input.tgz --quiet
-  ls -alh
-  tar -xzf input.tgz
-  if [ '%s' != '%s' ]; then
-    mv %s %s
-  fi
+	for _, in := range io.inputs {
+		gatherInput = fmt.Sprintf(`%s
+set -e
+set +x
+pushd $TRIPLE_C_WORKDIR
+  wget %s -O input-%s.tgz --quiet%s
+  mkdir -p %s
+  tar -xzf input-%s.tgz -C %s
+  ls -alh %s
 popd
-set +ex
-`, input.ioAddr, input.fromName, input.name, input.fromName, input.name)
+`, gatherInput, shellQuote(in.addr), in.name, wgetHeader, shellQuote(in.dir), in.name, shellQuote(in.dir), shellQuote(in.dir))
 	}
 
 	var gatherOutput, mkOutput string
-	if output.ioAddr != "" {
-		gatherOutput = fmt.Sprintf(`
+	for _, out := range io.outputs {
+		gatherOutput = fmt.Sprintf(`%s
 set -e
-pushd /home/vcap/app
-  tar -czf output.tgz %s
-  ls -alh
-  curl -s -X POST %s --data-binary @output.tgz
+pushd $TRIPLE_C_WORKDIR
+  tar -czf output-%s.tgz -C %s .
+  ls -alh output-%s.tgz
+  curl -s -f -X PUT --upload-file output-%s.tgz -H "X-Checksum-Sha256: $(sha256sum output-%s.tgz | cut -d ' ' -f 1)" %s%s
 popd
 set +e
-`, output.name, output.ioAddr)
+`, gatherOutput, out.name, shellQuote(out.dir), out.name, out.name, out.name, shellQuote(out.addr), curlHeader)
 
-		mkOutput = fmt.Sprintf(`
+		mkOutput = fmt.Sprintf(`%s
 set -e
-pushd /home/vcap/app
-	mkdir %s
+pushd $TRIPLE_C_WORKDIR
+	mkdir -p %s
 popd
+`, mkOutput, shellQuote(out.dir))
+	}
+
+	// A cache that can't be restored or saved doesn't fail the task.
+	var restoreCaches, saveCaches string
+	for _, c := range io.caches {
+		restoreCaches = fmt.Sprintf(`%s
 set +e
-`, output.name)
+pushd $TRIPLE_C_WORKDIR
+  mkdir -p %s
+  if wget %s -O cache-%s.tgz --quiet%s; then
+    tar -xzf cache-%s.tgz -C %s && echo "restored cache %s (%s)"
+  else
+    echo "no cache for %s (%s)"
+  fi
+  rm -f cache-%s.tgz
+popd
+set -e
+`, restoreCaches, shellQuote(c.dir), shellQuote(c.download), c.id, wgetHeader, c.id, shellQuote(c.dir), c.dir, c.id, c.dir, c.id, c.id)
+
+		saveCaches = fmt.Sprintf(`%s
+set +e
+pushd $TRIPLE_C_WORKDIR
+  tar -czf cache-%s.tgz -C %s . &&
+    curl -s -f -X PUT --upload-file cache-%s.tgz -H "X-Checksum-Sha256: $(sha256sum cache-%s.tgz | cut -d ' ' -f 1)" %s%s ||
+    echo "failed to save cache %s (%s)"
+  rm -f cache-%s.tgz
+popd
+`, saveCaches, c.id, shellQuote(c.dir), c.id, c.id, shellQuote(c.upload), curlHeader, c.dir, c.id, c.id)
 	}
 
+	// The command runs in a subshell under -e, whatever the blocks before it
+	// left set, and the script stops with its status if it fails. Outputs
+	// and caches are only saved when it succeeds.
+	command := fmt.Sprintf(`set +e
+(
+set -e
+%s
+)
+TRIPLE_C_COMMAND_STATUS=$?
+set -e
+if [ "$TRIPLE_C_COMMAND_STATUS" != 0 ]; then
+  exit $TRIPLE_C_COMMAND_STATUS
+fi`, t.Command)
+
 	return fmt.Sprintf(`#!/bin/bash
 set -ex
 
+# Executors other than CAPI run the task somewhere other than the app dir.
+export TRIPLE_C_WORKDIR=${TRIPLE_C_WORKDIR:-/home/vcap/app}
+
 # Clones
 %s
 
 # Input
 %s
 
+# Restore caches
+%s
+
 # Parameters
 %s
 
@@ -430,13 +901,66 @@ set -ex
 %s
 
 # Output
+%s
+
+# Save caches
 %s
 	`,
 		clones,
 		gatherInput,
+		restoreCaches,
 		parameters,
 		mkOutput,
-		t.Command,
+		command,
 		gatherOutput,
+		saveCaches,
 	)
 }
+
+// standardEnv returns the variables triple-c exports to every task, in the
+// order they are exported.
+func (m *Manager) standardEnv(p MetaPlan, t Task, r runInfo) [][2]string {
+	env := [][2]string{
+		{"TRIPLE_C_PLAN", p.Name},
+		{"TRIPLE_C_TASK", t.Name},
+		{"TRIPLE_C_TASK_INDEX", strconv.Itoa(r.taskIndex)},
+		{"TRIPLE_C_BRANCH", r.branch},
+		{"TRIPLE_C_REPO", r.repo},
+		{"TRIPLE_C_SHA", r.SHA},
+		{"TRIPLE_C_CONFIG_SHA", p.ConfigSHA},
+		{"TRIPLE_C_RUN_ID", r.id},
+		{"TRIPLE_C_API_URL", m.apiURL},
+	}
+
+	if r.tag != "" {
+		env = append(env, [2]string{"TRIPLE_C_TAG", r.tag})
+	}
+
+	// TRACEPARENT is the W3C variable tools that trace read their parent
+	// span from.
+	if span := tracing.SpanFromContext(r.ctx); span != nil {
+		env = append(env, [][2]string{
+			{"TRACEPARENT", span.TraceParent()},
+			{"TRIPLE_C_TRACE_ID", span.TraceID()},
+		}...)
+	}
+
+	if c := r.commit; c != nil {
+		env = append(env, [][2]string{
+			{"TRIPLE_C_COMMIT_SHA", c.SHA},
+			{"TRIPLE_C_COMMIT_MESSAGE", c.Message},
+			{"TRIPLE_C_COMMIT_AUTHOR_NAME", c.AuthorName},
+			{"TRIPLE_C_COMMIT_AUTHOR_EMAIL", c.AuthorEmail},
+			{"TRIPLE_C_COMMIT_COMMITTER_NAME", c.CommitterName},
+			{"TRIPLE_C_COMMIT_COMMITTER_EMAIL", c.CommitterEmail},
+			{"TRIPLE_C_COMMIT_TIMESTAMP", c.Timestamp.Format(time.RFC3339)},
+		}...)
+	}
+
+	return env
+}
+
+// shellQuote quotes s so bash reads it as a single literal word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -1,12 +1,14 @@
 package scheduler_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -14,17 +16,26 @@ import (
 	"github.com/poy/onpar"
 	. "github.com/poy/onpar/expect"
 	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/artifacts"
 	"github.com/poy/triple-c/internal/git"
+	"github.com/poy/triple-c/internal/metrics"
 	"github.com/poy/triple-c/internal/scheduler"
+	"github.com/poy/triple-c/internal/tracing"
 )
 
 type TM struct {
 	*testing.T
 	spyTaskCreator  *spyTaskCreator
+	spyExecutor     *spyTaskCreator
 	spyGitWatcher   *spyGitWatcher
+	spyTagWatcher   *spyTagWatcher
 	spyMetrics      *spyMetrics
 	spyRepoRegistry *spyRepoRegistry
 	spyTransfer     *spyTransfer
+	spyRunHistory   *spyRunHistory
+	spyExporter     *spyExporter
+	tracer          *tracing.Tracer
+	logs            *bytes.Buffer
 	m               *scheduler.Manager
 }
 
@@ -36,23 +47,38 @@ func TestManager(t *testing.T) {
 	o.BeforeEach(func(t *testing.T) TM {
 		spyMetrics := newSpyMetrics()
 		spyTaskCreator := newSpyTaskCreator()
+		spyExecutor := newSpyTaskCreator()
 		spyGitWatcher := newSpyGitWatcher()
+		spyTagWatcher := newSpyTagWatcher()
 		spyRepoRegistry := newSpyRepoRegistry()
 		spyTransfer := newSpyTransfer()
+		spyRunHistory := newSpyRunHistory()
+		spyExporter := &spyExporter{}
+		logs := &bytes.Buffer{}
+		tracer := tracing.New("some-service", spyExporter, 0, slog.New(slog.NewJSONHandler(logs, nil)))
 		return TM{
 			T:               t,
 			spyMetrics:      spyMetrics,
 			spyGitWatcher:   spyGitWatcher,
+			spyTagWatcher:   spyTagWatcher,
 			spyTaskCreator:  spyTaskCreator,
+			spyExecutor:     spyExecutor,
 			spyRepoRegistry: spyRepoRegistry,
 			spyTransfer:     spyTransfer,
+			spyRunHistory:   spyRunHistory,
+			spyExporter:     spyExporter,
+			tracer:          tracer,
+			logs:            logs,
 
 			m: scheduler.NewManager(
 				context.Background(),
 				"some-guid",
 				"some-branch",
+				"http://some-api",
 				spyTaskCreator,
+				map[string]scheduler.TaskCreator{"some-executor": spyExecutor},
 				spyGitWatcher.StartWatcher,
+				spyTagWatcher.StartTagWatcher,
 				spyRepoRegistry,
 				func(key string) (string, bool) {
 					if key == "KNOWN_KEY" {
@@ -62,8 +88,10 @@ func TestManager(t *testing.T) {
 				},
 				nil,
 				spyTransfer,
+				spyRunHistory,
 				spyMetrics,
-				log.New(ioutil.Discard, "", 0),
+				tracer,
+				slog.New(slog.NewJSONHandler(logs, nil)),
 			),
 		}
 	})
@@ -103,37 +131,603 @@ func TestManager(t *testing.T) {
 		Expect(t, t.spyMetrics.GetDelta("FailedTasks")()).To(Equal(uint64(0)))
 	})
 
+	o.Spec("it exports the standard variables", func(t TM) {
+		t.spyRepoRegistry.repo = &spyRepo{commit: git.Commit{SHA: "some-sha"}}
+		t.m.Add(scheduler.MetaPlan{
+			ConfigSHA: "config-sha",
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Name:    "task-a",
+						Command: "some-command",
+					},
+					{
+						Name:    "task-b",
+						Command: "some-other-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		for _, v := range []string{
+			"export TRIPLE_C_PLAN='some-plan'",
+			"export TRIPLE_C_TASK='task-b'",
+			"export TRIPLE_C_TASK_INDEX='1'",
+			"export TRIPLE_C_BRANCH='some-branch'",
+			"export TRIPLE_C_REPO='some-path'",
+			"export TRIPLE_C_SHA='some-sha'",
+			"export TRIPLE_C_CONFIG_SHA='config-sha'",
+			"export TRIPLE_C_RUN_ID='some-run-id'",
+			"export TRIPLE_C_API_URL='http://some-api'",
+		} {
+			Expect(t, t.spyTaskCreator.command).To(ContainSubstring(v))
+		}
+	})
+
+	o.Spec("it traces the run from the commit to each task", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Name: "task-a", Command: "some-command"},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		t.tracer.Flush()
+
+		run, ok := t.spyExporter.Span("plan.run")
+		Expect(t, ok).To(BeTrue())
+		Expect(t, run.ParentSpanID).To(Equal(""))
+		Expect(t, run.Attributes).To(Contain(
+			tracing.Attribute{Key: "plan", Value: "some-plan"},
+			tracing.Attribute{Key: "sha", Value: "some-sha"},
+			tracing.Attribute{Key: "trigger", Value: "commit"},
+			tracing.Attribute{Key: "run_id", Value: "some-run-id"},
+		))
+
+		for _, name := range []string{"git.commit", "tasks.list", "task"} {
+			s, ok := t.spyExporter.Span(name)
+			Expect(t, ok).To(BeTrue())
+			Expect(t, s.TraceID).To(Equal(run.TraceID))
+			Expect(t, s.ParentSpanID).To(Equal(run.SpanID))
+		}
+
+		task, _ := t.spyExporter.Span("task")
+		Expect(t, task.Attributes).To(Contain(tracing.Attribute{Key: "task", Value: "task-a"}))
+		Expect(t, tracing.SpanFromContext(t.spyTaskCreator.ctx).TraceParent()).To(Equal(
+			fmt.Sprintf("00-%s-%s-01", task.TraceID, task.SpanID),
+		))
+	})
+
+	o.Spec("it records failed tasks on their spans", func(t TM) {
+		t.spyTaskCreator.err = errors.New("some-error")
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Command: "some-command"},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		t.tracer.Flush()
+
+		task, _ := t.spyExporter.Span("task")
+		Expect(t, task.Err).To(Equal("some-error"))
+		run, _ := t.spyExporter.Span("plan.run")
+		Expect(t, run.Err).To(Equal("task 0 () failed"))
+	})
+
+	o.Spec("it passes the trace to the task", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Output: "some-out", Command: "some-command"},
+					{Input: "some-out", Command: "some-other-command"},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		span := tracing.SpanFromContext(t.spyTaskCreator.ctx)
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(fmt.Sprintf("export TRACEPARENT='%s'", span.TraceParent())))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(fmt.Sprintf("export TRIPLE_C_TRACE_ID='%s'", span.TraceID())))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(fmt.Sprintf("-O input-some-out.tgz --quiet --header 'traceparent: %s'", span.TraceParent())))
+	})
+
+	o.Spec("it does not pass a trace to the task without a tracer", func(t TM) {
+		m := scheduler.NewManager(
+			context.Background(),
+			"some-guid",
+			"some-branch",
+			"http://some-api",
+			t.spyTaskCreator,
+			nil,
+			t.spyGitWatcher.StartWatcher,
+			t.spyTagWatcher.StartTagWatcher,
+			t.spyRepoRegistry,
+			func(string) (string, bool) { return "", false },
+			nil,
+			t.spyTransfer,
+			t.spyRunHistory,
+			t.spyMetrics,
+			nil,
+			slog.New(slog.NewJSONHandler(t.logs, nil)),
+		)
+		m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Output: "some-out", Command: "some-command"},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(1))
+		Expect(t, t.spyTaskCreator.command).To(Not(ContainSubstring("TRACEPARENT")))
+		Expect(t, t.spyTaskCreator.command).To(Not(ContainSubstring("traceparent")))
+	})
+
+	o.Spec("it uses the executor named by the plan", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Executor:  "some-executor",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyExecutor.listResults = []string{
+			base64.StdEncoding.EncodeToString([]byte(`{"sha":"other-sha","branch":"some-branch"}`)),
+		}
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(0))
+		Expect(t, t.spyExecutor.called).To(Equal(1))
+		Expect(t, t.spyExecutor.command).To(ContainSubstring("some-command"))
+		Expect(t, t.spyExecutor.listAppGuid).To(Equal("some-guid"))
+	})
+
+	o.Spec("it fails the plan when the executor is unknown", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Executor:  "unknown-executor",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(0))
+		Expect(t, t.spyExecutor.called).To(Equal(0))
+		Expect(t, t.spyMetrics.GetDelta("FailedTasks")()).To(Equal(uint64(1)))
+	})
+
+	o.Spec("Run runs every task without waiting for a commit or deduping", func(t TM) {
+		t.spyTaskCreator.listResults = []string{
+			base64.StdEncoding.EncodeToString([]byte(`{"sha":"some-sha","branch":"some-branch"}`)),
+		}
+
+		ok := t.m.Run("some-sha", "some-branch", scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+					{
+						Command: "some-other-command",
+					},
+				},
+			},
+		})
+
+		Expect(t, ok).To(BeTrue())
+		Expect(t, t.spyGitWatcher.called).To(Equal(0))
+		Expect(t, t.spyTaskCreator.called).To(Equal(2))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("some-other-command"))
+	})
+
+	o.Spec("Run returns false when a task fails", func(t TM) {
+		t.spyTaskCreator.err = errors.New("some-error")
+
+		ok := t.m.Run("some-sha", "some-branch", scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		Expect(t, ok).To(BeFalse())
+	})
+
+	o.Spec("it fails the plan when an input has no output", func(t TM) {
+		ok := t.m.Run("some-sha", "some-branch", scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+					{
+						Input:   "some-input",
+						Command: "some-other-command",
+					},
+				},
+			},
+		})
+
+		Expect(t, ok).To(BeFalse())
+		Expect(t, t.spyTaskCreator.called).To(Equal(0))
+		Expect(t, t.spyMetrics.GetDelta("FailedTasks")()).To(Equal(uint64(1)))
+	})
+
 	o.Spec("it guards for certain branches", func(t TM) {
 		t.m.Add(scheduler.MetaPlan{
 			Plan: scheduler.Plan{
 				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
 				Tasks: []scheduler.Task{
 					{
-						Command:     "some-other-command",
-						BranchGuard: "some-other-branch",
-					},
-					{
-						Command:     "some-command",
-						BranchGuard: "some-branch",
+						Command:     "some-other-command",
+						BranchGuard: "some-other-branch",
+					},
+					{
+						Command:     "some-command",
+						BranchGuard: "some-branch",
+					},
+				},
+			},
+		})
+
+		Expect(t, t.spyGitWatcher.commit).To(Not(BeNil()))
+		Expect(t, t.spyGitWatcher.branch).To(Equal("some-branch"))
+		Expect(t, t.spyGitWatcher.repoName).To(Equal("some-path"))
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("some-command"))
+		Expect(t, t.spyTaskCreator.appGuid).To(Equal("some-guid"))
+		Expect(t, t.spyMetrics.GetDelta("SuccessfulTasks")()).To(Equal(uint64(1)))
+	})
+
+	o.Spec("it handles multiple RepoPaths", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}, "some-other-repo": scheduler.Repo{Repo: "some-other-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		Expect(t, t.spyGitWatcher.called).To(Equal(2))
+	})
+
+	o.Spec("it starts the next task", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+					{
+						Command: "some-other-command",
+					},
+				},
+			},
+		})
+
+		Expect(t, t.spyGitWatcher.commit).To(Not(BeNil()))
+		Expect(t, t.spyGitWatcher.branch).To(Equal("some-branch"))
+		Expect(t, t.spyGitWatcher.repoName).To(Equal("some-path"))
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("some-other-command"))
+	})
+
+	o.Spec("it does not start the next task if the first fails", func(t TM) {
+		t.spyTaskCreator.err = errors.New("some-error")
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+					{
+						Command: "some-other-command",
+					},
+				},
+			},
+		})
+
+		Expect(t, t.spyGitWatcher.commit).To(Not(BeNil()))
+		Expect(t, t.spyGitWatcher.branch).To(Equal("some-branch"))
+		Expect(t, t.spyGitWatcher.repoName).To(Equal("some-path"))
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.command).To(Not(ContainSubstring("some-other-command")))
+		Expect(t, t.spyTransfer.ctx).To(BeNil())
+	})
+
+	o.Spec("it records each run in the history", func(t TM) {
+		t.spyTaskCreator.err = errors.New("some-error")
+		t.m.Add(scheduler.MetaPlan{
+			ConfigSHA: "config-sha",
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Name:    "some-task",
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyRunHistory.started).To(Equal([]metrics.Run{{
+			Plan:      "some-plan",
+			Branch:    "some-branch",
+			Repo:      "some-path",
+			SHA:       "some-sha",
+			ConfigSHA: "config-sha",
+		}}))
+		Expect(t, t.spyRunHistory.tasks).To(Equal([]string{"some-task"}))
+		Expect(t, t.spyRunHistory.finished).To(Equal([]string{`task 0 (some-task) failed`}))
+	})
+
+	o.Spec("it skips a commit that changes no files in the path filter", func(t TM) {
+		spyRepo := &spyRepo{files: []string{"docs/index.md"}}
+		t.spyRepoRegistry.repo = spyRepo
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name: "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{
+					Repo:  "some-path",
+					Paths: scheduler.PathFilter{Include: []string{"src/**"}},
+				}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("sha-1")
+		Expect(t, t.spyTaskCreator.called).To(Equal(1))
+
+		t.spyGitWatcher.commit("sha-2")
+		Expect(t, spyRepo.fromSHA).To(Equal("sha-1"))
+		Expect(t, spyRepo.toSHA).To(Equal("sha-2"))
+		Expect(t, t.spyTaskCreator.called).To(Equal(1))
+		Expect(t, t.spyMetrics.GetDelta("SkippedRuns")()).To(Equal(uint64(1)))
+		Expect(t, t.spyRunHistory.skipped).To(HaveLen(1))
+		Expect(t, t.spyRunHistory.reasons[0]).To(ContainSubstring("path filter"))
+
+		spyRepo.files = []string{"docs/index.md", "src/main.go"}
+		t.spyGitWatcher.commit("sha-3")
+		Expect(t, t.spyTaskCreator.called).To(Equal(2))
+	})
+
+	o.Spec("it skips a commit that asks to skip CI", func(t TM) {
+		t.spyRepoRegistry.repo = &spyRepo{commit: git.Commit{Message: "Update docs [skip ci]"}}
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(0))
+		Expect(t, t.spyMetrics.GetDelta("SkippedRuns")()).To(Equal(uint64(1)))
+		Expect(t, t.spyRunHistory.reasons).To(Equal([]string{"commit message contains a skip directive"}))
+	})
+
+	o.Spec("it exports the commit to the tasks", func(t TM) {
+		t.spyRepoRegistry.repo = &spyRepo{commit: git.Commit{
+			SHA:         "some-sha",
+			AuthorName:  "Some Author",
+			AuthorEmail: "author@example.com",
+			Timestamp:   time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			Message:     "It's a fix",
+		}}
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(`export TRIPLE_C_COMMIT_MESSAGE='It'\''s a fix'`))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(`export TRIPLE_C_COMMIT_AUTHOR_NAME='Some Author'`))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(`export TRIPLE_C_COMMIT_TIMESTAMP='2019-01-01T00:00:00Z'`))
+	})
+
+	o.Spec("it runs without commit metadata when the commit can not be read", func(t TM) {
+		t.spyRepoRegistry.repo = &spyRepo{commitErr: errors.New("some-error")}
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(1))
+		Expect(t, t.spyTaskCreator.command).To(Not(ContainSubstring("TRIPLE_C_COMMIT_")))
+	})
+
+	o.Spec("it asks the watcher for every commit when EveryCommit is set", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{
+					"some-repo": scheduler.Repo{Repo: "some-path", EveryCommit: true},
+				},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+		Expect(t, t.spyGitWatcher.maxCommits).To(Equal(scheduler.DefaultMaxCommits))
+
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{
+					"some-repo": scheduler.Repo{Repo: "some-path", EveryCommit: true, MaxCommits: 3},
+				},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-other-command",
+					},
+				},
+			},
+		})
+		Expect(t, t.spyGitWatcher.maxCommits).To(Equal(3))
+	})
+
+	o.Spec("it starts a plan with tag triggers for each new tag", func(t TM) {
+		t.spyRepoRegistry.repo = &spyRepo{commit: git.Commit{Message: "Release [skip ci]"}}
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "release",
+				On:        scheduler.Triggers{Tags: []string{"v*"}},
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
 					},
 				},
 			},
 		})
 
-		Expect(t, t.spyGitWatcher.commit).To(Not(BeNil()))
-		Expect(t, t.spyGitWatcher.branch).To(Equal("some-branch"))
-		Expect(t, t.spyGitWatcher.repoName).To(Equal("some-path"))
+		Expect(t, t.spyGitWatcher.called).To(Equal(0))
+		Expect(t, t.spyTagWatcher.called).To(Equal(1))
+		Expect(t, t.spyTagWatcher.lister).To(Equal(t.spyRepoRegistry.repo))
+		Expect(t, t.spyTagWatcher.patterns).To(Equal([]string{"v*"}))
 
-		t.spyGitWatcher.commit("some-sha")
-		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("some-command"))
-		Expect(t, t.spyTaskCreator.appGuid).To(Equal("some-guid"))
-		Expect(t, t.spyMetrics.GetDelta("SuccessfulTasks")()).To(Equal(uint64(1)))
+		t.spyTagWatcher.callback("v1.0.0", "some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(1))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("export TRIPLE_C_TAG='v1.0.0'"))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("git checkout 'v1.0.0'"))
+		Expect(t, t.spyRunHistory.started[0].Tag).To(Equal("v1.0.0"))
+
+		dataName, err := base64.StdEncoding.DecodeString(t.spyTaskCreator.name)
+		Expect(t, err).To(BeNil())
+
+		var m map[string]interface{}
+		Expect(t, json.Unmarshal(dataName, &m)).To(BeNil())
+		Expect(t, m["tag"]).To(Equal("v1.0.0"))
+		Expect(t, m["sha"]).To(Equal("some-sha"))
+
+		t.spyTagWatcher.callback("v2$(touch pwned)", "some-other-sha")
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("git checkout 'v2$(touch pwned)'"))
 	})
 
-	o.Spec("it handles multiple RepoPaths", func(t TM) {
+	o.Spec("it keeps the tags seen when a plan with tag triggers is replaced", func(t TM) {
+		plan := func(command string) scheduler.MetaPlan {
+			return scheduler.MetaPlan{
+				Plan: scheduler.Plan{
+					Name:      "release",
+					On:        scheduler.Triggers{Tags: []string{"v*"}},
+					RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+					Tasks:     []scheduler.Task{{Command: command}},
+				},
+			}
+		}
+
+		t.m.Add(plan("some-command"))
+		t.m.Remove(plan("some-command"))
+		t.m.Add(plan("some-other-command"))
+		other := plan("some-command")
+		other.Name = "other-release"
+		t.m.Add(other)
+
+		Expect(t, t.spyTagWatcher.seen).To(HaveLen(3))
+		Expect(t, t.spyTagWatcher.seen[1] == t.spyTagWatcher.seen[0]).To(BeTrue())
+		Expect(t, t.spyTagWatcher.seen[2] == t.spyTagWatcher.seen[0]).To(BeFalse())
+	})
+
+	o.Spec("it ignores tag triggers without a tag watcher", func(t TM) {
+		m := scheduler.NewManager(
+			context.Background(),
+			"some-guid",
+			"some-other-branch",
+			"http://some-api",
+			t.spyTaskCreator,
+			nil,
+			t.spyGitWatcher.StartWatcher,
+			nil,
+			t.spyRepoRegistry,
+			func(string) (string, bool) { return "", false },
+			nil,
+			t.spyTransfer,
+			t.spyRunHistory,
+			t.spyMetrics,
+			nil,
+			slog.New(slog.NewJSONHandler(t.logs, nil)),
+		)
+		m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "release",
+				On:        scheduler.Triggers{Tags: []string{"v*"}},
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks:     []scheduler.Task{{Command: "some-command"}},
+			},
+		})
+
+		Expect(t, t.spyGitWatcher.called).To(Equal(0))
+		Expect(t, t.spyTagWatcher.called).To(Equal(0))
+		Expect(t, t.logs.String()).To(ContainSubstring("not watching tags on this branch"))
+	})
+
+	o.Spec("it runs anyway when the diff fails", func(t TM) {
+		t.spyRepoRegistry.repo = &spyRepo{err: errors.New("some-error")}
 		t.m.Add(scheduler.MetaPlan{
 			Plan: scheduler.Plan{
-				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}, "some-other-repo": scheduler.Repo{Repo: "some-other-path"}},
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{
+					Repo:  "some-path",
+					Paths: scheduler.PathFilter{Include: []string{"src/**"}},
+				}},
 				Tasks: []scheduler.Task{
 					{
 						Command: "some-command",
@@ -142,15 +736,18 @@ func TestManager(t *testing.T) {
 			},
 		})
 
-		Expect(t, t.spyGitWatcher.called).To(Equal(2))
+		t.spyGitWatcher.commit("sha-1")
+		t.spyGitWatcher.commit("sha-2")
+		Expect(t, t.spyTaskCreator.called).To(Equal(2))
 	})
 
-	o.Spec("it starts the next task", func(t TM) {
+	o.Spec("it uses the transfer to get a name", func(t TM) {
 		t.m.Add(scheduler.MetaPlan{
 			Plan: scheduler.Plan{
 				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
 				Tasks: []scheduler.Task{
 					{
+						Output:  "some-out",
 						Command: "some-command",
 					},
 					{
@@ -160,57 +757,147 @@ func TestManager(t *testing.T) {
 			},
 		})
 
-		Expect(t, t.spyGitWatcher.commit).To(Not(BeNil()))
-		Expect(t, t.spyGitWatcher.branch).To(Equal("some-branch"))
-		Expect(t, t.spyGitWatcher.repoName).To(Equal("some-path"))
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTransfer.ctx).To(Not(BeNil()))
+		Expect(t, t.spyTransfer.runID).To(Equal("some-run-id"))
+		Expect(t, t.spyTransfer.name).To(Equal("some-out"))
+	})
 
+	o.Spec("it uploads outputs and downloads inputs with separate URLs", func(t TM) {
+		t.spyTransfer.upload = "https://some-bucket/upload?sig=a"
+		t.spyTransfer.download = "https://some-bucket/download?sig=b"
+		plan := scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Output: "some-out", Command: "some-command"},
+				},
+			},
+		}
+		t.m.Add(plan)
 		t.spyGitWatcher.commit("some-sha")
-		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("some-other-command"))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(`curl -s -f -X PUT --upload-file output-some-out.tgz -H "X-Checksum-Sha256: $(sha256sum output-some-out.tgz | cut -d ' ' -f 1)" 'https://some-bucket/upload?sig=a'`))
+
+		plan.Tasks = append(plan.Tasks, scheduler.Task{Input: "some-in", Command: "some-other-command"})
+		t.m.Add(plan)
+		t.spyGitWatcher.commit("some-other-sha")
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("wget 'https://some-bucket/download?sig=b' -O input-some-out.tgz"))
 	})
 
-	o.Spec("it does not start the next task if the first fails", func(t TM) {
-		t.spyTaskCreator.err = errors.New("some-error")
+	o.Spec("it allocates a transfer for every output", func(t TM) {
 		t.m.Add(scheduler.MetaPlan{
 			Plan: scheduler.Plan{
 				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
 				Tasks: []scheduler.Task{
 					{
 						Command: "some-command",
+						Outputs: []scheduler.Artifact{{Name: "binary"}, {Name: "report", Path: "out/report"}},
 					},
 					{
 						Command: "some-other-command",
+						Inputs:  []scheduler.Artifact{{Name: "binary", Path: "bin"}},
 					},
 				},
 			},
 		})
 
-		Expect(t, t.spyGitWatcher.commit).To(Not(BeNil()))
-		Expect(t, t.spyGitWatcher.branch).To(Equal("some-branch"))
-		Expect(t, t.spyGitWatcher.repoName).To(Equal("some-path"))
-
 		t.spyGitWatcher.commit("some-sha")
-		Expect(t, t.spyTaskCreator.command).To(Not(ContainSubstring("some-other-command")))
-		Expect(t, t.spyTransfer.ctx).To(BeNil())
+		Expect(t, t.spyTransfer.names).To(Equal([]string{"binary", "report"}))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("wget 'download-binary' -O input-binary.tgz --quiet"))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("tar -xzf input-binary.tgz -C 'bin'"))
+		Expect(t, t.spyTaskCreator.command).To(Not(ContainSubstring("report")))
+
+		// The download URL carries a token, so it isn't traced into the log.
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("set +x\npushd $TRIPLE_C_WORKDIR\n  wget 'download-binary'"))
+		Expect(t, strings.Count(t.spyTaskCreator.command, "set -ex")).To(Equal(1))
 	})
 
-	o.Spec("it uses the transfer to get a name", func(t TM) {
+	o.Spec("it archives each output from its own directory", func(t TM) {
 		t.m.Add(scheduler.MetaPlan{
 			Plan: scheduler.Plan{
 				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
 				Tasks: []scheduler.Task{
 					{
-						Output:  "some-out",
 						Command: "some-command",
-					},
-					{
-						Command: "some-other-command",
+						Outputs: []scheduler.Artifact{{Name: "binary"}, {Name: "report", Path: "out/report"}},
 					},
 				},
 			},
 		})
 
 		t.spyGitWatcher.commit("some-sha")
-		Expect(t, t.spyTransfer.ctx).To(Not(BeNil()))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("mkdir -p 'out/report'"))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("tar -czf output-binary.tgz -C 'binary' ."))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("tar -czf output-report.tgz -C 'out/report' ."))
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring("'upload-report'"))
+	})
+
+	o.Spec("it restores and saves caches", func(t TM) {
+		plan := scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Name: "build", Command: "some-command", Caches: []string{"go/pkg/mod"}},
+				},
+			},
+		}
+		t.m.Add(plan)
+		t.spyGitWatcher.commit("some-sha")
+		t.spyGitWatcher.commit("some-other-sha")
+
+		Expect(t, t.spyTransfer.cacheIDs).To(HaveLen(2))
+		id := t.spyTransfer.cacheIDs[0]
+		Expect(t, t.spyTransfer.cacheIDs[1]).To(Equal(id))
+		Expect(t, id).To(StartWith(artifacts.CachePrefix("some-plan")))
+
+		command := t.spyTaskCreator.command
+		Expect(t, command).To(ContainSubstring(fmt.Sprintf("if wget 'restore-%s' -O cache-%s.tgz --quiet --header 'traceparent: ", id, id)))
+		Expect(t, command).To(ContainSubstring(fmt.Sprintf("tar -xzf cache-%s.tgz -C 'go/pkg/mod'", id)))
+		Expect(t, command).To(ContainSubstring("popd\nset -e\n"))
+		Expect(t, command).To(ContainSubstring("set +e\n(\nset -e\nsome-command\n)\nTRIPLE_C_COMMAND_STATUS=$?"))
+		Expect(t, command).To(ContainSubstring(`if [ "$TRIPLE_C_COMMAND_STATUS" != 0 ]`))
+		Expect(t, command).To(ContainSubstring(fmt.Sprintf("'save-%s'", id)))
+		Expect(t, command).To(ContainSubstring("exit $TRIPLE_C_COMMAND_STATUS"))
+		Expect(t, strings.Index(command, "restore-") < strings.Index(command, "some-command")).To(BeTrue())
+		Expect(t, strings.Index(command, "save-") > strings.Index(command, "some-command")).To(BeTrue())
+	})
+
+	o.Spec("it keeps caches separate per branch", func(t TM) {
+		plan := scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Name: "build", Command: "some-command", Caches: []string{"go/pkg/mod"}},
+				},
+			},
+		}
+		t.m.Add(plan)
+		t.spyGitWatcher.commit("some-sha")
+
+		ids := t.spyTransfer.cacheIDs
+		Expect(t, ids).To(HaveLen(1))
+		Expect(t, ids[0]).To(Equal(artifacts.CacheID("some-plan", "some-branch", "", "build", "go/pkg/mod")))
+		Expect(t, ids[0]).To(Not(Equal(artifacts.CacheID("some-plan", "other-branch", "", "build", "go/pkg/mod"))))
+	})
+
+	o.Spec("it keeps -e in force for the command when the task has outputs", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks:     []scheduler.Task{{Command: "some-command", Output: "out"}},
+			},
+		})
+		t.spyGitWatcher.commit("some-sha")
+
+		command := t.spyTaskCreator.command
+		Expect(t, command).To(ContainSubstring("(\nset -e\nsome-command\n)\nTRIPLE_C_COMMAND_STATUS=$?"))
+		Expect(t, command).To(ContainSubstring("if [ \"$TRIPLE_C_COMMAND_STATUS\" != 0 ]; then\n  exit $TRIPLE_C_COMMAND_STATUS\nfi"))
+
+		// The outputs are only uploaded once the command has succeeded.
+		Expect(t, strings.Index(command, "mkdir -p 'out'") < strings.Index(command, "some-command")).To(BeTrue())
+		Expect(t, strings.Index(command, "exit $TRIPLE_C_COMMAND_STATUS") < strings.Index(command, "upload-out")).To(BeTrue())
 	})
 
 	o.Spec("it starts a task once if the DoOnce is set", func(t TM) {
@@ -360,6 +1047,78 @@ func TestManager(t *testing.T) {
 		Expect(t, t.spyMetrics.GetDelta("DedupedTasks")()).To(Equal(uint64(0)))
 	})
 
+	o.Spec("it quotes matrix values", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			MatrixValues: map[string]string{"NAME": "it's $HOME"},
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks:     []scheduler.Task{{Command: "some-command"}},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.command).To(ContainSubstring(`export NAME='it'\''s $HOME'`))
+	})
+
+	o.Spec("it exports matrix values and records them in the task name", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			MatrixValues: map[string]string{"GO_VERSION": "1.10", "STACK": "cflinuxfs3"},
+			Plan: scheduler.Plan{
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+
+		t.spyGitWatcher.commit("some-sha")
+		Expect(t, t.spyTaskCreator.command).To(And(
+			ContainSubstring("export GO_VERSION='1.10'"),
+			ContainSubstring("export STACK='cflinuxfs3'"),
+		))
+
+		dataName, err := base64.StdEncoding.DecodeString(t.spyTaskCreator.name)
+		Expect(t, err).To(BeNil())
+
+		var m map[string]interface{}
+		Expect(t, json.Unmarshal(dataName, &m)).To(BeNil())
+		Expect(t, m["matrix"]).To(Equal("GO_VERSION=1.10,STACK=cflinuxfs3"))
+	})
+
+	o.Spec("it only dedupes tasks for the same matrix values", func(t TM) {
+		plan := scheduler.Plan{
+			RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+			Tasks: []scheduler.Task{
+				{
+					Command: "some-command",
+				},
+			},
+		}
+		t.m.Add(scheduler.MetaPlan{
+			Plan:         plan,
+			MatrixValues: map[string]string{"GO_VERSION": "1.10"},
+		})
+		commit110 := t.spyGitWatcher.commit
+
+		t.m.Add(scheduler.MetaPlan{
+			Plan:         plan,
+			MatrixValues: map[string]string{"GO_VERSION": "1.11"},
+		})
+		commit111 := t.spyGitWatcher.commit
+
+		t.spyTaskCreator.listResults = []string{
+			base64.StdEncoding.EncodeToString([]byte(`{"sha":"some-sha","branch":"some-branch","matrix":"GO_VERSION=1.10"}`)),
+		}
+
+		commit110("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(0))
+
+		commit111("some-sha")
+		Expect(t, t.spyTaskCreator.called).To(Equal(1))
+	})
+
 	o.Spec("it increments FailedTasks when a task fails", func(t TM) {
 		t.spyTaskCreator.err = errors.New("some-error")
 		t.m.Add(scheduler.MetaPlan{
@@ -379,6 +1138,84 @@ func TestManager(t *testing.T) {
 		Expect(t, t.spyMetrics.GetDelta("FailedTasks")()).To(Equal(uint64(1)))
 	})
 
+	o.Spec("it labels the task counters with the plan and branch", func(t TM) {
+		t.spyTaskCreator.err = errors.New("some-error")
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{
+						Command: "some-command",
+					},
+				},
+			},
+		})
+		t.spyGitWatcher.commit("some-sha")
+
+		Expect(t, t.spyMetrics.GetLabels("FailedTasks")).To(Equal(metrics.Labels{
+			"plan":   "some-plan",
+			"branch": "some-branch",
+		}))
+	})
+
+	o.Spec("it observes how long each task queued and ran", func(t TM) {
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Name: "some-task", Command: "some-command"},
+					{Name: "other-task", Command: "other-command"},
+				},
+			},
+		})
+		t.spyGitWatcher.commit("some-sha")
+
+		expected := []metrics.Labels{
+			{"plan": "some-plan", "branch": "some-branch", "task": "some-task"},
+			{"plan": "some-plan", "branch": "some-branch", "task": "other-task"},
+		}
+		Expect(t, t.spyMetrics.Observed("TaskQueueSeconds")).To(Equal(expected))
+		Expect(t, t.spyMetrics.Observed("TaskDurationSeconds")).To(Equal(expected))
+	})
+
+	o.Spec("it logs with the fields of the run and task", func(t TM) {
+		t.spyTaskCreator.err = errors.New("some-error")
+		t.m.Add(scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name:      "some-plan",
+				RepoPaths: map[string]scheduler.Repo{"some-repo": scheduler.Repo{Repo: "some-path"}},
+				Tasks: []scheduler.Task{
+					{Name: "some-task", Command: "some-command"},
+				},
+			},
+		})
+		t.spyGitWatcher.commit("some-sha")
+
+		var found bool
+		for _, line := range strings.Split(strings.TrimSpace(t.logs.String()), "\n") {
+			var entry map[string]interface{}
+			Expect(t, json.Unmarshal([]byte(line), &entry)).To(BeNil())
+			if entry["msg"] != "task failed" {
+				continue
+			}
+			found = true
+
+			Expect(t, entry["level"]).To(Equal("ERROR"))
+			Expect(t, entry["plan"]).To(Equal("some-plan"))
+			Expect(t, entry["branch"]).To(Equal("some-branch"))
+			Expect(t, entry["repo"]).To(Equal("some-path"))
+			Expect(t, entry["sha"]).To(Equal("some-sha"))
+			Expect(t, entry["run_id"]).To(Equal("some-run-id"))
+			Expect(t, entry["trace_id"]).To(HaveLen(32))
+			Expect(t, entry["task_index"]).To(Equal(0.0))
+			Expect(t, entry["task"]).To(Equal("some-task"))
+			Expect(t, entry["err"]).To(Equal("some-error"))
+		}
+		Expect(t, found).To(BeTrue())
+	})
+
 	o.Spec("it increments FailedRepos when a repo fails to be fetched", func(t TM) {
 		t.spyRepoRegistry.err = errors.New("some-err")
 		t.m.Add(scheduler.MetaPlan{
@@ -488,6 +1325,7 @@ func TestManager(t *testing.T) {
 
 type spyTaskCreator struct {
 	called  int
+	ctx     context.Context
 	command string
 	name    string
 	appGuid string
@@ -504,11 +1342,13 @@ func newSpyTaskCreator() *spyTaskCreator {
 }
 
 func (s *spyTaskCreator) CreateTask(
+	ctx context.Context,
 	command string,
 	name string,
 	appGuid string,
 ) error {
 	s.called++
+	s.ctx = ctx
 	s.command = command
 	s.name = name
 	s.appGuid = appGuid
@@ -528,9 +1368,10 @@ type spyGitWatcher struct {
 	branch     string
 	commit     func(SHA string)
 	interval   time.Duration
+	maxCommits int
 	repo       git.Repo
 	shaTracker git.SHATracker
-	log        *log.Logger
+	log        *slog.Logger
 }
 
 func newSpyGitWatcher() *spyGitWatcher {
@@ -543,9 +1384,10 @@ func (s *spyGitWatcher) StartWatcher(
 	branch string,
 	commit func(SHA string),
 	interval time.Duration,
+	maxCommits int,
 	repo git.Repo,
 	shaTracker git.SHATracker,
-	log *log.Logger,
+	log *slog.Logger,
 ) {
 	s.called++
 	s.ctx = ctx
@@ -553,28 +1395,97 @@ func (s *spyGitWatcher) StartWatcher(
 	s.branch = branch
 	s.commit = commit
 	s.interval = interval
+	s.maxCommits = maxCommits
 	s.repo = repo
 	s.shaTracker = shaTracker
 	s.log = log
 }
 
+type spyTagWatcher struct {
+	called   int
+	ctx      context.Context
+	lister   git.TagLister
+	patterns []string
+	seen     []*git.SeenTags
+	callback func(tag, SHA string)
+}
+
+func newSpyTagWatcher() *spyTagWatcher {
+	return &spyTagWatcher{}
+}
+
+func (s *spyTagWatcher) StartTagWatcher(
+	ctx context.Context,
+	lister git.TagLister,
+	patterns []string,
+	seen *git.SeenTags,
+	callback func(tag, SHA string),
+	backoff time.Duration,
+	m git.Metrics,
+	log *slog.Logger,
+) {
+	s.called++
+	s.ctx = ctx
+	s.lister = lister
+	s.patterns = patterns
+	s.seen = append(s.seen, seen)
+	s.callback = callback
+}
+
 type spyMetrics struct {
-	mu sync.Mutex
-	m  map[string]uint64
+	mu     sync.Mutex
+	m      map[string]uint64
+	labels map[string]metrics.Labels
+	h      map[string][]metrics.Labels
 }
 
 func newSpyMetrics() *spyMetrics {
 	return &spyMetrics{
-		m: make(map[string]uint64),
+		m:      make(map[string]uint64),
+		labels: make(map[string]metrics.Labels),
+		h:      make(map[string][]metrics.Labels),
 	}
 }
 
-func (s *spyMetrics) NewCounter(name string) func(uint64) {
+func (s *spyMetrics) NewCounter(name string, labels ...metrics.Labels) func(uint64) {
 	return func(delta uint64) {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 		s.m[name] += delta
+		s.labels[name] = mergeLabels(labels)
+	}
+}
+
+func (s *spyMetrics) NewHistogram(name string, buckets []float64, labels ...metrics.Labels) func(float64) {
+	return func(float64) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.h[name] = append(s.h[name], mergeLabels(labels))
+	}
+}
+
+// GetLabels returns the labels the counter was last incremented with.
+func (s *spyMetrics) GetLabels(name string) metrics.Labels {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.labels[name]
+}
+
+// Observed returns the labels of each observation of the histogram.
+func (s *spyMetrics) Observed(name string) []metrics.Labels {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h[name]
+}
+
+func mergeLabels(labels []metrics.Labels) metrics.Labels {
+	merged := make(metrics.Labels)
+	for _, l := range labels {
+		for k, v := range l {
+			merged[k] = v
+		}
 	}
+	return merged
 }
 
 func (s *spyMetrics) GetDelta(name string) func() uint64 {
@@ -593,7 +1504,9 @@ type spyRepoRegistry struct {
 }
 
 func newSpyRepoRegistry() *spyRepoRegistry {
-	return &spyRepoRegistry{}
+	return &spyRepoRegistry{
+		repo: &spyRepo{},
+	}
 }
 
 func (s *spyRepoRegistry) FetchRepo(path string) (git.Repo, error) {
@@ -602,15 +1515,120 @@ func (s *spyRepoRegistry) FetchRepo(path string) (git.Repo, error) {
 }
 
 type spyTransfer struct {
-	ctx    context.Context
-	result string
+	ctx      context.Context
+	runID    string
+	name     string
+	names    []string
+	cacheIDs []string
+	upload   string
+	download string
 }
 
 func newSpyTransfer() *spyTransfer {
 	return &spyTransfer{}
 }
 
-func (s *spyTransfer) InitInterconnect(ctx context.Context) string {
+func (s *spyTransfer) InitInterconnect(ctx context.Context, runID, name string) (string, string) {
 	s.ctx = ctx
-	return s.result
+	s.runID = runID
+	s.name = name
+	s.names = append(s.names, name)
+	if s.upload == "" {
+		return "upload-" + name, "download-" + name
+	}
+	return s.upload, s.download
+}
+
+func (s *spyTransfer) InitCache(ctx context.Context, runID, id string) (string, string) {
+	s.cacheIDs = append(s.cacheIDs, id)
+	return "save-" + id, "restore-" + id
+}
+
+type spyRepo struct {
+	git.Repo
+
+	fromSHA string
+	toSHA   string
+	files   []string
+	err     error
+
+	commit    git.Commit
+	commitErr error
+}
+
+func (s *spyRepo) Commit(SHA string) (git.Commit, error) {
+	return s.commit, s.commitErr
+}
+
+func (s *spyRepo) Diff(fromSHA, toSHA string) ([]string, error) {
+	s.fromSHA = fromSHA
+	s.toSHA = toSHA
+	return s.files, s.err
+}
+
+type spyRunHistory struct {
+	mu sync.Mutex
+
+	started  []metrics.Run
+	skipped  []metrics.Run
+	reasons  []string
+	tasks    []string
+	finished []string
+}
+
+func newSpyRunHistory() *spyRunHistory {
+	return &spyRunHistory{}
+}
+
+func (s *spyRunHistory) Start(r metrics.Run) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = append(s.started, r)
+	return "some-run-id"
+}
+
+func (s *spyRunHistory) Skip(r metrics.Run, reason string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped = append(s.skipped, r)
+	s.reasons = append(s.reasons, reason)
+	return "some-run-id"
+}
+
+func (s *spyRunHistory) StartTask(id string, index int, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, name)
+}
+
+func (s *spyRunHistory) FinishTask(id string, index int, err error) {}
+
+func (s *spyRunHistory) Finish(id string, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = append(s.finished, reason)
+}
+
+type spyExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (s *spyExporter) Export(ctx context.Context, service string, spans []tracing.SpanData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = append(s.spans, spans...)
+	return nil
+}
+
+// Span returns the first exported span with the given name.
+func (s *spyExporter) Span(name string) (tracing.SpanData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.spans {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return tracing.SpanData{}, false
 }
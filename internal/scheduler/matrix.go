@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ExpandMatrix returns a MetaPlan for every combination of the plan's matrix
+// axes. A plan without a matrix is returned as is. Combinations are ordered
+// by the sorted axis names and then the order the values are listed in.
+func ExpandMatrix(p MetaPlan) []MetaPlan {
+	if len(p.Matrix) == 0 {
+		return []MetaPlan{p}
+	}
+
+	var axes []string
+	for axis := range p.Matrix {
+		axes = append(axes, axis)
+	}
+	sort.Strings(axes)
+
+	combinations := []map[string]string{{}}
+	for _, axis := range axes {
+		var next []map[string]string
+		for _, c := range combinations {
+			for _, v := range p.Matrix[axis] {
+				nc := make(map[string]string, len(c)+1)
+				for k, cv := range c {
+					nc[k] = cv
+				}
+				nc[axis] = v
+				next = append(next, nc)
+			}
+		}
+		combinations = next
+	}
+
+	var results []MetaPlan
+	for _, c := range combinations {
+		mp := p
+		mp.MatrixValues = c
+		results = append(results, mp)
+	}
+
+	return results
+}
+
+// matrixID returns a stable identity for a combination of matrix values
+// (e.g. GO_VERSION=1.10,STACK=cflinuxfs3).
+func matrixID(values map[string]string) string {
+	var pairs []string
+	for k, v := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,46 @@
+package scheduler_test
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/scheduler"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it returns a plan without a matrix as is", func(t *testing.T) {
+		p := scheduler.MetaPlan{Plan: scheduler.Plan{Name: "some-plan"}}
+		Expect(t, scheduler.ExpandMatrix(p)).To(Equal([]scheduler.MetaPlan{p}))
+	})
+
+	o.Spec("it returns a plan for every combination in a stable order", func(t *testing.T) {
+		p := scheduler.MetaPlan{
+			Plan: scheduler.Plan{
+				Name: "some-plan",
+				Matrix: map[string][]string{
+					"STACK":      {"cflinuxfs2", "cflinuxfs3"},
+					"GO_VERSION": {"1.10", "1.11"},
+				},
+			},
+		}
+
+		var values []map[string]string
+		for _, mp := range scheduler.ExpandMatrix(p) {
+			Expect(t, mp.Name).To(Equal("some-plan"))
+			values = append(values, mp.MatrixValues)
+		}
+
+		Expect(t, values).To(Equal([]map[string]string{
+			{"GO_VERSION": "1.10", "STACK": "cflinuxfs2"},
+			{"GO_VERSION": "1.10", "STACK": "cflinuxfs3"},
+			{"GO_VERSION": "1.11", "STACK": "cflinuxfs2"},
+			{"GO_VERSION": "1.11", "STACK": "cflinuxfs3"},
+		}))
+	})
+}
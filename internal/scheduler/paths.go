@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"path"
+	"strings"
+)
+
+// PathFilter selects files by their path within a repo. Patterns use
+// path.Match syntax and are matched against the full path. A pattern ending
+// in "/**" or "/" matches everything beneath that directory.
+type PathFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Empty reports whether the filter has no patterns, in which case every
+// change is relevant.
+func (f PathFilter) Empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Matches reports whether any of the files is included and not excluded. An
+// empty Include list includes every file.
+func (f PathFilter) Matches(files []string) bool {
+	for _, file := range files {
+		if len(f.Include) > 0 && !matchAny(f.Include, file) {
+			continue
+		}
+
+		if matchAny(f.Exclude, file) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func matchAny(patterns []string, file string) bool {
+	for _, p := range patterns {
+		if matchPath(p, file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPath(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		pattern = strings.TrimSuffix(pattern, "**")
+	}
+
+	// A directory pattern matches the file's leading directories, which
+	// may themselves use wildcards (e.g., services/*/docs/).
+	if strings.HasSuffix(pattern, "/") {
+		dirs := strings.Count(pattern, "/")
+		parts := strings.SplitN(file, "/", dirs+1)
+		if len(parts) <= dirs {
+			return false
+		}
+
+		ok, _ := path.Match(strings.TrimSuffix(pattern, "/"), strings.Join(parts[:dirs], "/"))
+		return ok
+	}
+
+	ok, _ := path.Match(pattern, file)
+	return ok
+}
+
+// validPattern reports whether the pattern is well formed.
+func validPattern(pattern string) bool {
+	_, err := path.Match(strings.TrimSuffix(pattern, "/**"), "")
+	return err == nil
+}
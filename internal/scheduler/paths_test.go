@@ -0,0 +1,57 @@
+package scheduler_test
+
+import (
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/scheduler"
+)
+
+func TestPathFilter(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it matches every file when empty", func(t *testing.T) {
+		f := scheduler.PathFilter{}
+		Expect(t, f.Empty()).To(BeTrue())
+		Expect(t, f.Matches([]string{"README.md"})).To(BeTrue())
+	})
+
+	o.Spec("it matches globs against the full path", func(t *testing.T) {
+		f := scheduler.PathFilter{Include: []string{"*.go"}}
+		Expect(t, f.Matches([]string{"main.go"})).To(BeTrue())
+		Expect(t, f.Matches([]string{"cmd/main.go"})).To(BeFalse())
+	})
+
+	o.Spec("it matches everything beneath a directory", func(t *testing.T) {
+		f := scheduler.PathFilter{Include: []string{"src/**", "docs/"}}
+		Expect(t, f.Matches([]string{"src/a/b.go"})).To(BeTrue())
+		Expect(t, f.Matches([]string{"docs/index.md"})).To(BeTrue())
+		Expect(t, f.Matches([]string{"srcs/b.go"})).To(BeFalse())
+	})
+
+	o.Spec("it matches everything beneath directories with wildcards", func(t *testing.T) {
+		f := scheduler.PathFilter{Include: []string{"services/*/docs/**"}}
+		Expect(t, f.Matches([]string{"services/api/docs/index.md"})).To(BeTrue())
+		Expect(t, f.Matches([]string{"services/api/docs/v1/index.md"})).To(BeTrue())
+		Expect(t, f.Matches([]string{"services/api/main.go"})).To(BeFalse())
+		Expect(t, f.Matches([]string{"services/api/v1/docs/index.md"})).To(BeFalse())
+		Expect(t, f.Matches([]string{"services/docs"})).To(BeFalse())
+	})
+
+	o.Spec("it ignores excluded files", func(t *testing.T) {
+		f := scheduler.PathFilter{
+			Include: []string{"src/**"},
+			Exclude: []string{"src/*.md"},
+		}
+		Expect(t, f.Matches([]string{"src/README.md"})).To(BeFalse())
+		Expect(t, f.Matches([]string{"src/README.md", "src/main.go"})).To(BeTrue())
+
+		f = scheduler.PathFilter{Exclude: []string{"docs/**"}}
+		Expect(t, f.Matches([]string{"docs/index.md"})).To(BeFalse())
+		Expect(t, f.Matches([]string{"main.go"})).To(BeTrue())
+	})
+}
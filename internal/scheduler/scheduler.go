@@ -10,30 +10,145 @@ type MetaPlan struct {
 	Plan
 	DoOnce    bool
 	ConfigSHA string
+
+	// MatrixValues is the combination of matrix values this run of the plan
+	// is for. See ExpandMatrix.
+	MatrixValues map[string]string
 }
 
 type Plans struct {
-	Plans []Plan `yaml:"plans"`
+	Include   []string        `yaml:"include"`
+	Templates map[string]Task `yaml:"templates"`
+	Plans     []Plan          `yaml:"plans"`
 }
 
 type Repo struct {
 	Repo   string `yaml:"repo"`
 	Branch string `yaml:"branch"`
+
+	// Paths limits the plan to commits that change matching files.
+	Paths PathFilter `yaml:"paths"`
+
+	// EveryCommit runs the plan for each commit that landed since the last
+	// poll, oldest first, instead of only the newest.
+	EveryCommit bool `yaml:"every_commit"`
+
+	// MaxCommits caps how many commits EveryCommit runs per poll; the
+	// newest are kept. Defaults to DefaultMaxCommits.
+	MaxCommits int `yaml:"max_commits"`
+}
+
+// DefaultMaxCommits is the MaxCommits used when a repo sets EveryCommit
+// without a cap.
+const DefaultMaxCommits = 10
+
+// maxCommits returns how many commits per poll the watcher should report.
+func (r Repo) maxCommits() int {
+	if !r.EveryCommit {
+		return 0
+	}
+
+	if r.MaxCommits <= 0 {
+		return DefaultMaxCommits
+	}
+
+	return r.MaxCommits
 }
 
 type Plan struct {
 	Name      string          `yaml:"name"`
 	RepoPaths map[string]Repo `yaml:"repo_paths"`
 	Tasks     []Task          `yaml:"tasks"`
+	Executor  string          `yaml:"executor"`
+
+	// Matrix maps the name of each axis to its values. The plan is run once
+	// for every combination, with the values exported to each task.
+	Matrix map[string][]string `yaml:"matrix"`
+
+	// On selects what triggers the plan. By default it runs for commits on
+	// the branch.
+	On Triggers `yaml:"on"`
+
+	// File is the config file the plan was defined in.
+	File string `yaml:"-"`
+}
+
+type Triggers struct {
+	// Tags runs the plan for each new tag that matches one of the patterns
+	// (see path.Match) instead of for commits.
+	Tags []string `yaml:"tags"`
 }
 
 type Task struct {
 	Name        string            `yaml:"name"`
+	Template    string            `yaml:"template"`
 	Input       string            `yaml:"input"`
 	Output      string            `yaml:"output"`
 	Command     string            `yaml:"command"`
 	Parameters  map[string]string `yaml:"parameters"`
 	BranchGuard string            `yaml:"branch_guard"`
+
+	// Inputs are outputs of earlier tasks in the plan. Each is extracted
+	// into its own directory.
+	Inputs []Artifact `yaml:"inputs"`
+
+	// Outputs are directories the task fills and that are uploaded, each as
+	// its own artifact, when it succeeds.
+	Outputs []Artifact `yaml:"outputs"`
+
+	// Caches are directories saved when the task succeeds and restored
+	// before it runs again for the same plan, branch and matrix values.
+	Caches []string `yaml:"caches"`
+}
+
+// Artifact is a named input or output of a task. Path is the directory,
+// relative to the task's working directory, it is extracted to or archived
+// from and defaults to the name. In YAML it may be written as just the
+// name.
+type Artifact struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+func (a *Artifact) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&a.Name); err == nil {
+		return nil
+	}
+
+	type plain Artifact
+	return unmarshal((*plain)(a))
+}
+
+func (a Artifact) dir() string {
+	if a.Path == "" {
+		return a.Name
+	}
+	return a.Path
+}
+
+// inputs returns every input of the task. Input is the output of the
+// previous task, extracted to a directory named Input.
+func (t Task) inputs(prev *Task) []Artifact {
+	var inputs []Artifact
+	if t.Input != "" {
+		var name string
+		if prev != nil {
+			name = prev.Output
+		}
+		inputs = append(inputs, Artifact{Name: name, Path: t.Input})
+	}
+
+	return append(inputs, t.Inputs...)
+}
+
+// outputs returns every output of the task, including Output.
+func (t Task) outputs() []Artifact {
+	var outputs []Artifact
+	if t.Output != "" {
+		outputs = append(outputs, Artifact{Name: t.Output})
+	}
+
+	return append(outputs, t.Outputs...)
 }
 
 type TaskManager interface {
@@ -51,12 +166,12 @@ func (s *Scheduler) SetPlans(plans []MetaPlan) {
 	var newCurrent []MetaPlan
 
 	for _, t := range plans {
-		if s.findPlan(t, s.currentPlans) {
-			continue
-		}
 		if !t.DoOnce {
 			newCurrent = append(newCurrent, t)
 		}
+		if s.findPlan(t, s.currentPlans) {
+			continue
+		}
 		s.m.Add(t)
 	}
 
@@ -81,6 +81,16 @@ func TestScheduler(t *testing.T) {
 			},
 		})
 
+		t.s.SetPlans([]scheduler.MetaPlan{
+			{
+				Plan: scheduler.Plan{
+					RepoPaths: map[string]scheduler.Repo{"repo-a": scheduler.Repo{Repo: "a"}},
+					Tasks:     []scheduler.Task{},
+				},
+			},
+		})
+
+		Expect(t, t.spyTaskManager.removes).To(HaveLen(0))
 		Expect(t, t.spyTaskManager.adds).To(HaveLen(1))
 		Expect(t, t.spyTaskManager.adds).To(Contain(
 			scheduler.MetaPlan{
@@ -115,6 +125,46 @@ func TestScheduler(t *testing.T) {
 		Expect(t, t.spyTaskManager.adds).To(HaveLen(2))
 	})
 
+	o.Spec("it replaces a plan whose path filters changed", func(t TS) {
+		plan := func(paths scheduler.PathFilter) scheduler.MetaPlan {
+			return scheduler.MetaPlan{
+				Plan: scheduler.Plan{
+					RepoPaths: map[string]scheduler.Repo{"repo-a": scheduler.Repo{Repo: "a", Paths: paths}},
+					Tasks:     []scheduler.Task{},
+				},
+			}
+		}
+
+		t.s.SetPlans([]scheduler.MetaPlan{plan(scheduler.PathFilter{Include: []string{"src/**"}})})
+		t.s.SetPlans([]scheduler.MetaPlan{plan(scheduler.PathFilter{Include: []string{"src/**"}, Exclude: []string{"src/docs/**"}})})
+
+		Expect(t, t.spyTaskManager.removes).To(HaveLen(1))
+		Expect(t, t.spyTaskManager.adds).To(HaveLen(2))
+		Expect(t, t.spyTaskManager.adds[1].RepoPaths["repo-a"].Paths.Exclude).To(Equal([]string{"src/docs/**"}))
+	})
+
+	o.Spec("it replaces a plan whose every_commit settings changed", func(t TS) {
+		plan := func(everyCommit bool, maxCommits int) scheduler.MetaPlan {
+			return scheduler.MetaPlan{
+				Plan: scheduler.Plan{
+					RepoPaths: map[string]scheduler.Repo{"repo-a": scheduler.Repo{Repo: "a", EveryCommit: everyCommit, MaxCommits: maxCommits}},
+					Tasks:     []scheduler.Task{},
+				},
+			}
+		}
+
+		t.s.SetPlans([]scheduler.MetaPlan{plan(false, 0)})
+		t.s.SetPlans([]scheduler.MetaPlan{plan(true, 0)})
+		t.s.SetPlans([]scheduler.MetaPlan{plan(true, scheduler.DefaultMaxCommits)})
+		t.s.SetPlans([]scheduler.MetaPlan{plan(true, 3)})
+
+		// A cap of 0 is the default cap, so only the first and last changes
+		// replace the plan.
+		Expect(t, t.spyTaskManager.removes).To(HaveLen(2))
+		Expect(t, t.spyTaskManager.adds).To(HaveLen(3))
+		Expect(t, t.spyTaskManager.adds[2].RepoPaths["repo-a"].MaxCommits).To(Equal(3))
+	})
+
 	o.Spec("it removes stale tasks", func(t TS) {
 		t.s.SetPlans([]scheduler.MetaPlan{
 			{
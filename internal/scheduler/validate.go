@@ -0,0 +1,259 @@
+package scheduler
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ValidationError is a problem found with a plan.
+type ValidationError struct {
+	// File is the plans file that defined the plan. It is empty when the
+	// plan did not come from a file.
+	File string
+	Msg  string
+}
+
+func (e ValidationError) Error() string {
+	return e.Msg
+}
+
+// ValidationErrors is every problem found with a set of plans.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Msg
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ErrorFile returns the file of the first problem that has one.
+func (e ValidationErrors) ErrorFile() string {
+	for _, err := range e {
+		if err.File != "" {
+			return err.File
+		}
+	}
+	return ""
+}
+
+// ParseError is returned by ParsePlans when a plans file is not valid
+// YAML or has unknown keys.
+type ParseError struct {
+	// File is the plans file that failed to parse. It is empty when the
+	// data did not come from a file.
+	File string
+
+	// Line is the first line the YAML parser complained about. It is 0 if
+	// the line is not known.
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+// ErrorLine returns the line the error occurred on.
+func (e *ParseError) ErrorLine() int {
+	return e.Line
+}
+
+// ErrorFile returns the file the error occurred in.
+func (e *ParseError) ErrorFile() string {
+	return e.File
+}
+
+var (
+	yamlLine = regexp.MustCompile(`line (\d+)`)
+	envName  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// outputName is what an output may be called, since it names the
+	// artifact the output is stored as.
+	outputName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+// ParsePlans parses a plans file. Unknown keys are an error.
+func ParsePlans(data []byte) (Plans, error) {
+	var p Plans
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		pe := &ParseError{Err: err}
+		if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+			pe.Line, _ = strconv.Atoi(m[1])
+		}
+		return Plans{}, pe
+	}
+
+	return p, nil
+}
+
+// Validate checks the plans for problems that would keep them from running.
+// It returns ValidationErrors if any are found.
+func Validate(p Plans) error {
+	var (
+		errs ValidationErrors
+		file string
+	)
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, ValidationError{File: file, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	planFiles := make(map[string]string)
+	planIndexes := make(map[string]int)
+	for _, plan := range p.Plans {
+		file = plan.File
+		i := planIndexes[plan.File]
+		planIndexes[plan.File]++
+
+		planID := fmt.Sprintf("plan %d (%q)", i, plan.Name)
+		if plan.File != "" {
+			planID = fmt.Sprintf("%s: %s", plan.File, planID)
+		}
+
+		if plan.Name == "" {
+			addErr("%s: name is required", planID)
+		} else if f, ok := planFiles[plan.Name]; ok {
+			if f != "" {
+				addErr("%s: duplicate plan name (also defined in %s)", planID, f)
+			} else {
+				addErr("%s: duplicate plan name", planID)
+			}
+		} else {
+			planFiles[plan.Name] = plan.File
+		}
+
+		if len(plan.RepoPaths) == 0 {
+			addErr("%s: repo_paths is required", planID)
+		}
+
+		for name, repo := range plan.RepoPaths {
+			if repo.Repo == "" {
+				addErr("%s: repo_paths %q: repo is required", planID, name)
+			}
+
+			for _, pattern := range append(repo.Paths.Include, repo.Paths.Exclude...) {
+				if !validPattern(pattern) {
+					addErr("%s: repo_paths %q: invalid path pattern %q", planID, name, pattern)
+				}
+			}
+
+			if repo.MaxCommits < 0 {
+				addErr("%s: repo_paths %q: max_commits must not be negative", planID, name)
+			} else if repo.MaxCommits > 0 && !repo.EveryCommit {
+				addErr("%s: repo_paths %q: max_commits requires every_commit", planID, name)
+			}
+		}
+
+		if len(plan.Tasks) == 0 {
+			addErr("%s: tasks is required", planID)
+		}
+
+		for _, pattern := range plan.On.Tags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				addErr("%s: invalid tag pattern %q", planID, pattern)
+			}
+		}
+
+		var axes []string
+		for axis := range plan.Matrix {
+			axes = append(axes, axis)
+		}
+		sort.Strings(axes)
+
+		for _, axis := range axes {
+			if !envName.MatchString(axis) {
+				addErr("%s: matrix axis %q must be a valid environment variable name", planID, axis)
+			}
+
+			if len(plan.Matrix[axis]) == 0 {
+				addErr("%s: matrix axis %q has no values", planID, axis)
+			}
+		}
+
+		taskNames := make(map[string]bool)
+		outputs := make(map[string]bool)
+		for j, task := range plan.Tasks {
+			taskID := fmt.Sprintf("%s task %d (%q)", planID, j, task.Name)
+
+			if task.Name != "" && taskNames[task.Name] {
+				addErr("%s: duplicate task name", taskID)
+			}
+			taskNames[task.Name] = true
+
+			if task.Command == "" {
+				addErr("%s: command is required", taskID)
+			}
+
+			var prev *Task
+			if j > 0 {
+				prev = &plan.Tasks[j-1]
+			}
+
+			dirs := make(map[string]bool)
+			checkDir := func(a Artifact) {
+				dir := path.Clean(a.dir())
+				switch {
+				case !validDir(dir):
+					addErr("%s: path %q must be a directory within the working directory", taskID, a.dir())
+				case dirs[dir]:
+					addErr("%s: path %q is used by more than one input, output or cache", taskID, a.dir())
+				}
+				dirs[dir] = true
+			}
+
+			if task.Input != "" && (prev == nil || prev.Output == "") {
+				addErr("%s: input %q has no output from the previous task", taskID, task.Input)
+			}
+
+			for _, in := range task.inputs(prev) {
+				if in.Name != "" && !outputs[in.Name] {
+					addErr("%s: input %q is not an output of an earlier task", taskID, in.Name)
+				}
+				checkDir(in)
+			}
+
+			for _, out := range task.outputs() {
+				if !outputName.MatchString(out.Name) {
+					addErr("%s: output %q may only contain letters, digits, '.', '_' and '-'", taskID, out.Name)
+					continue
+				}
+
+				if outputs[out.Name] {
+					addErr("%s: duplicate output %q", taskID, out.Name)
+				}
+				outputs[out.Name] = true
+				checkDir(out)
+			}
+
+			for _, dir := range task.Caches {
+				checkDir(Artifact{Path: dir})
+			}
+
+			if task.BranchGuard != "" && !strings.HasPrefix(task.BranchGuard, "remotes/origin/") {
+				addErr("%s: branch_guard %q must be a remote branch (e.g. remotes/origin/master)", taskID, task.BranchGuard)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validDir reports whether the cleaned path is a directory below the task's
+// working directory.
+func validDir(dir string) bool {
+	return dir != "." && dir != ".." && !path.IsAbs(dir) && !strings.HasPrefix(dir, "../")
+}
@@ -0,0 +1,237 @@
+package scheduler_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/scheduler"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.Spec("it parses a plans file", func(t *testing.T) {
+		p, err := scheduler.ParsePlans([]byte(`
+plans:
+- name: some-plan
+  repo_paths:
+    some-repo:
+      repo: some-path
+  tasks:
+  - name: some-task
+    command: some-command
+`))
+		Expect(t, err).To(BeNil())
+		Expect(t, p.Plans).To(HaveLen(1))
+		Expect(t, p.Plans[0].Tasks[0].Command).To(Equal("some-command"))
+	})
+
+	o.Spec("it parses tag triggers", func(t *testing.T) {
+		p, err := scheduler.ParsePlans([]byte(`
+plans:
+- name: release
+  on:
+    tags: ["v*"]
+  repo_paths:
+    some-repo:
+      repo: some-path
+  tasks:
+  - command: some-command
+`))
+		Expect(t, err).To(BeNil())
+		Expect(t, p.Plans[0].On.Tags).To(Equal([]string{"v*"}))
+	})
+
+	o.Spec("it parses inputs and outputs by name or with a path", func(t *testing.T) {
+		p, err := scheduler.ParsePlans([]byte(`
+plans:
+- name: some-plan
+  repo_paths:
+    some-repo:
+      repo: some-path
+  tasks:
+  - command: some-command
+    outputs:
+    - binary
+    - name: report
+      path: out/report
+`))
+		Expect(t, err).To(BeNil())
+		Expect(t, p.Plans[0].Tasks[0].Outputs).To(Equal([]scheduler.Artifact{
+			{Name: "binary"},
+			{Name: "report", Path: "out/report"},
+		}))
+	})
+
+	o.Spec("it returns an error for unknown keys in an output", func(t *testing.T) {
+		_, err := scheduler.ParsePlans([]byte(`
+plans:
+- name: some-plan
+  tasks:
+  - outputs:
+    - nme: report
+`))
+		Expect(t, err).To(Not(BeNil()))
+	})
+
+	o.Spec("it returns an error for unknown keys", func(t *testing.T) {
+		_, err := scheduler.ParsePlans([]byte(`
+plans:
+- name: some-plan
+  tasks:
+  - comand: some-command
+`))
+		Expect(t, err).To(Not(BeNil()))
+		Expect(t, err.Error()).To(ContainSubstring("line 5"))
+
+		pe, ok := err.(*scheduler.ParseError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, pe.Line).To(Equal(5))
+	})
+
+	o.Spec("it returns the line of a syntax error", func(t *testing.T) {
+		_, err := scheduler.ParsePlans([]byte("plans:\n- name: some-plan\n  tasks: [\n"))
+		Expect(t, err).To(Not(BeNil()))
+
+		pe, ok := err.(*scheduler.ParseError)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, pe.Line).To(Not(Equal(0)))
+	})
+
+	o.Spec("it accepts valid plans", func(t *testing.T) {
+		err := scheduler.Validate(scheduler.Plans{
+			Plans: []scheduler.Plan{
+				{
+					Name:      "some-plan",
+					RepoPaths: map[string]scheduler.Repo{"some-repo": {Repo: "some-path"}},
+					Tasks: []scheduler.Task{
+						{Name: "a", Command: "some-command", Output: "out"},
+						{Name: "b", Command: "some-command", Input: "in", BranchGuard: "remotes/origin/master"},
+					},
+				},
+			},
+		})
+		Expect(t, err).To(BeNil())
+	})
+
+	o.Spec("it reports every problem", func(t *testing.T) {
+		err := scheduler.Validate(scheduler.Plans{
+			Plans: []scheduler.Plan{
+				{
+					Name:      "some-plan",
+					RepoPaths: map[string]scheduler.Repo{"some-repo": {}},
+					Tasks: []scheduler.Task{
+						{Name: "a", Input: "in", Output: "out"},
+						{Name: "a", Command: "some-command", BranchGuard: "master", Output: "out"},
+						{Name: "c", Command: "some-command", Output: "../out"},
+					},
+				},
+				{
+					Name: "some-plan",
+					Matrix: map[string][]string{
+						"GO VERSION": {"1.10"},
+						"STACK":      nil,
+					},
+				},
+			},
+		})
+
+		errs, ok := err.(scheduler.ValidationErrors)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, strings.Split(errs.Error(), "\n")).To(Equal([]string{
+			`plan 0 ("some-plan"): repo_paths "some-repo": repo is required`,
+			`plan 0 ("some-plan") task 0 ("a"): command is required`,
+			`plan 0 ("some-plan") task 0 ("a"): input "in" has no output from the previous task`,
+			`plan 0 ("some-plan") task 1 ("a"): duplicate task name`,
+			`plan 0 ("some-plan") task 1 ("a"): duplicate output "out"`,
+			`plan 0 ("some-plan") task 1 ("a"): branch_guard "master" must be a remote branch (e.g. remotes/origin/master)`,
+			`plan 0 ("some-plan") task 2 ("c"): output "../out" may only contain letters, digits, '.', '_' and '-'`,
+			`plan 1 ("some-plan"): duplicate plan name`,
+			`plan 1 ("some-plan"): repo_paths is required`,
+			`plan 1 ("some-plan"): tasks is required`,
+			`plan 1 ("some-plan"): matrix axis "GO VERSION" must be a valid environment variable name`,
+			`plan 1 ("some-plan"): matrix axis "STACK" has no values`,
+		}))
+	})
+
+	o.Spec("it points errors at the file that defined the plan", func(t *testing.T) {
+		plan := scheduler.Plan{
+			Name:      "some-plan",
+			RepoPaths: map[string]scheduler.Repo{"some-repo": {Repo: "some-path"}},
+			Tasks:     []scheduler.Task{{Command: "some-command"}},
+		}
+		a, b := plan, plan
+		a.File = "pipelines/a.yml"
+		b.File = "pipelines/b.yml"
+
+		err := scheduler.Validate(scheduler.Plans{Plans: []scheduler.Plan{a, b}})
+		Expect(t, err.Error()).To(Equal(
+			`pipelines/b.yml: plan 0 ("some-plan"): duplicate plan name (also defined in pipelines/a.yml)`,
+		))
+		Expect(t, err.(scheduler.ValidationErrors).ErrorFile()).To(Equal("pipelines/b.yml"))
+	})
+
+	o.Spec("it checks inputs and outputs", func(t *testing.T) {
+		err := scheduler.Validate(scheduler.Plans{Plans: []scheduler.Plan{{
+			Name:      "some-plan",
+			RepoPaths: map[string]scheduler.Repo{"some-repo": {Repo: "some-path"}},
+			Tasks: []scheduler.Task{
+				{
+					Name:    "a",
+					Command: "some-command",
+					Inputs:  []scheduler.Artifact{{Name: "binary"}},
+					Outputs: []scheduler.Artifact{{Name: "binary"}, {Name: "report", Path: "../report"}},
+				},
+				{
+					Name:    "b",
+					Command: "some-command",
+					Inputs:  []scheduler.Artifact{{Name: "binary", Path: "out"}, {Name: "report", Path: "out/"}},
+					Caches:  []string{"/go"},
+				},
+			},
+		}}})
+
+		errs, ok := err.(scheduler.ValidationErrors)
+		Expect(t, ok).To(BeTrue())
+		Expect(t, strings.Split(errs.Error(), "\n")).To(Equal([]string{
+			`plan 0 ("some-plan") task 0 ("a"): input "binary" is not an output of an earlier task`,
+			`plan 0 ("some-plan") task 0 ("a"): path "binary" is used by more than one input, output or cache`,
+			`plan 0 ("some-plan") task 0 ("a"): path "../report" must be a directory within the working directory`,
+			`plan 0 ("some-plan") task 1 ("b"): path "out/" is used by more than one input, output or cache`,
+			`plan 0 ("some-plan") task 1 ("b"): path "/go" must be a directory within the working directory`,
+		}))
+	})
+
+	o.Spec("it rejects invalid path patterns", func(t *testing.T) {
+		err := scheduler.Validate(scheduler.Plans{Plans: []scheduler.Plan{{
+			Name: "some-plan",
+			RepoPaths: map[string]scheduler.Repo{"some-repo": {
+				Repo:  "some-path",
+				Paths: scheduler.PathFilter{Include: []string{"src/[a-"}},
+			}},
+			Tasks: []scheduler.Task{{Command: "some-command"}},
+		}}})
+		Expect(t, err.Error()).To(Equal(
+			`plan 0 ("some-plan"): repo_paths "some-repo": invalid path pattern "src/[a-"`,
+		))
+	})
+
+	o.Spec("it requires every_commit for max_commits", func(t *testing.T) {
+		err := scheduler.Validate(scheduler.Plans{Plans: []scheduler.Plan{{
+			Name: "some-plan",
+			RepoPaths: map[string]scheduler.Repo{"some-repo": {
+				Repo:       "some-path",
+				MaxCommits: 5,
+			}},
+			Tasks: []scheduler.Task{{Command: "some-command"}},
+		}}})
+		Expect(t, err.Error()).To(Equal(
+			`plan 0 ("some-plan"): repo_paths "some-repo": max_commits requires every_commit`,
+		))
+	})
+}
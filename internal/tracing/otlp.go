@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector with OTLP over
+// HTTP, encoded as JSON.
+type OTLPExporter struct {
+	endpoint string
+	doer     Doer
+}
+
+// Doer sends HTTP requests.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// NewOTLPExporter returns an OTLPExporter for the collector at endpoint
+// (e.g., http://localhost:4318). Spans are POSTed to its /v1/traces.
+func NewOTLPExporter(endpoint string, d Doer) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		doer:     d,
+	}
+}
+
+// Export sends the spans to the collector.
+func (e *OTLPExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	data, err := json.Marshal(otlpRequest(service, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.doer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// The types below are the JSON encoding of an OTLP
+// ExportTraceServiceRequest.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func otlpRequest(service string, spans []SpanData) exportRequest {
+	var ss []span
+	for _, d := range spans {
+		s := span{
+			TraceID:           d.TraceID,
+			SpanID:            d.SpanID,
+			ParentSpanID:      d.ParentSpanID,
+			Name:              d.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+		}
+
+		for _, a := range d.Attributes {
+			s.Attributes = append(s.Attributes, keyValue{Key: a.Key, Value: toAnyValue(a.Value)})
+		}
+
+		if d.Err != "" {
+			s.Status = status{Code: statusCodeError, Message: d.Err}
+		}
+
+		ss = append(ss, s)
+	}
+
+	return exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: []keyValue{{Key: "service.name", Value: toAnyValue(service)}},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: "github.com/poy/triple-c"},
+				Spans: ss,
+			}},
+		}},
+	}
+}
+
+func toAnyValue(v interface{}) anyValue {
+	switch x := v.(type) {
+	case string:
+		return anyValue{StringValue: &x}
+	case bool:
+		return anyValue{BoolValue: &x}
+	case int:
+		s := strconv.Itoa(x)
+		return anyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		return anyValue{IntValue: &s}
+	case float64:
+		return anyValue{DoubleValue: &x}
+	default:
+		s := fmt.Sprint(x)
+		return anyValue{StringValue: &s}
+	}
+}
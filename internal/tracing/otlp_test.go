@@ -0,0 +1,148 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/tracing"
+)
+
+type TO struct {
+	*testing.T
+	collector *collector
+	t         *tracing.Tracer
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TO {
+		c := newCollector()
+		e := tracing.NewOTLPExporter(c.server.URL+"/", http.DefaultClient)
+		return TO{
+			T:         t,
+			collector: c,
+			t:         tracing.New("some-service", e, 0, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+		}
+	})
+
+	o.AfterEach(func(t TO) {
+		t.collector.server.Close()
+	})
+
+	o.Spec("it posts spans to the collector as OTLP JSON", func(t TO) {
+		ctx, parent := t.t.Start(context.Background(), "parent")
+		parent.SetAttributes("plan", "some-plan", "task_index", 1, "ok", true)
+		_, child := t.t.Start(ctx, "child")
+		child.RecordError(errors.New("some-error"))
+		child.End()
+		parent.End()
+		t.t.Flush()
+
+		Expect(t, t.collector.paths).To(Equal([]string{"/v1/traces"}))
+		Expect(t, t.collector.contentType).To(Equal("application/json"))
+
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []struct {
+						Key   string `json:"key"`
+						Value struct {
+							StringValue string `json:"stringValue"`
+						} `json:"value"`
+					} `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceID           string `json:"traceId"`
+						SpanID            string `json:"spanId"`
+						ParentSpanID      string `json:"parentSpanId"`
+						Name              string `json:"name"`
+						Kind              int    `json:"kind"`
+						StartTimeUnixNano string `json:"startTimeUnixNano"`
+						EndTimeUnixNano   string `json:"endTimeUnixNano"`
+						Attributes        []struct {
+							Key   string                 `json:"key"`
+							Value map[string]interface{} `json:"value"`
+						} `json:"attributes"`
+						Status struct {
+							Code    int    `json:"code"`
+							Message string `json:"message"`
+						} `json:"status"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		Expect(t, json.Unmarshal(t.collector.bodies[0], &req)).To(BeNil())
+		Expect(t, req.ResourceSpans).To(HaveLen(1))
+
+		rs := req.ResourceSpans[0]
+		Expect(t, rs.Resource.Attributes).To(HaveLen(1))
+		Expect(t, rs.Resource.Attributes[0].Key).To(Equal("service.name"))
+		Expect(t, rs.Resource.Attributes[0].Value.StringValue).To(Equal("some-service"))
+
+		spans := rs.ScopeSpans[0].Spans
+		Expect(t, spans).To(HaveLen(2))
+
+		c, p := spans[0], spans[1]
+		Expect(t, c.Name).To(Equal("child"))
+		Expect(t, c.TraceID).To(Equal(parent.TraceID()))
+		Expect(t, c.ParentSpanID).To(Equal(p.SpanID))
+		Expect(t, c.Kind).To(Equal(1))
+		Expect(t, c.Status.Code).To(Equal(2))
+		Expect(t, c.Status.Message).To(Equal("some-error"))
+		Expect(t, c.StartTimeUnixNano).To(MatchRegexp(`^[0-9]+$`))
+		Expect(t, c.EndTimeUnixNano).To(MatchRegexp(`^[0-9]+$`))
+
+		Expect(t, p.ParentSpanID).To(Equal(""))
+		Expect(t, p.Status.Code).To(Equal(0))
+		Expect(t, p.Attributes).To(HaveLen(3))
+		Expect(t, p.Attributes[0].Value).To(Equal(map[string]interface{}{"stringValue": "some-plan"}))
+		Expect(t, p.Attributes[1].Value).To(Equal(map[string]interface{}{"intValue": "1"}))
+		Expect(t, p.Attributes[2].Value).To(Equal(map[string]interface{}{"boolValue": true}))
+	})
+
+	o.Spec("it returns an error for a non-2XX response", func(t TO) {
+		t.collector.status = http.StatusServiceUnavailable
+		e := tracing.NewOTLPExporter(t.collector.server.URL, http.DefaultClient)
+		err := e.Export(context.Background(), "some-service", []tracing.SpanData{{Name: "some-span"}})
+		Expect(t, err).To(Not(BeNil()))
+	})
+}
+
+type collector struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	status      int
+	paths       []string
+	contentType string
+	bodies      [][]byte
+}
+
+func newCollector() *collector {
+	c := &collector{status: http.StatusOK}
+	c.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.paths = append(c.paths, r.URL.Path)
+		c.contentType = r.Header.Get("Content-Type")
+		c.bodies = append(c.bodies, body)
+		w.WriteHeader(c.status)
+	}))
+	return c
+}
@@ -0,0 +1,283 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer records spans and hands them, in batches, to an Exporter. A nil
+// Tracer records nothing.
+type Tracer struct {
+	service  string
+	exporter Exporter
+	log      *slog.Logger
+
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// Exporter sends finished spans somewhere (e.g., an OTLP collector).
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []SpanData) error
+}
+
+const (
+	// maxBatch is how many spans are exported at once. A full batch is
+	// exported straight away.
+	maxBatch = 512
+
+	// maxQueued is how many spans are kept while waiting to be exported.
+	// Any more are dropped.
+	maxQueued = 8 * maxBatch
+)
+
+// New returns a Tracer for the service. If the exporter is nil, spans are
+// still created (so their IDs can be handed out) but they are dropped when
+// they end. Otherwise spans are exported every interval and whenever a
+// batch fills up.
+func New(service string, e Exporter, interval time.Duration, log *slog.Logger) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: e,
+		log:      log,
+	}
+
+	if e != nil && interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				t.Flush()
+			}
+		}()
+	}
+
+	return t
+}
+
+// Start starts a span that is a child of the span (or remote parent) in the
+// context, if there is one. The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		t: t,
+		data: SpanData{
+			Name:  name,
+			Start: time.Now(),
+		},
+	}
+
+	if parent, ok := spanContext(ctx); ok {
+		s.data.TraceID = parent.TraceID
+		s.data.ParentSpanID = parent.SpanID
+	} else {
+		s.data.TraceID = newID(16)
+	}
+	s.data.SpanID = newID(8)
+
+	return context.WithValue(ctx, parentKey{}, s), s
+}
+
+// Flush exports every span that has ended since the last export.
+func (t *Tracer) Flush() {
+	if t == nil || t.exporter == nil {
+		return
+	}
+
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > maxBatch {
+			n = maxBatch
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.exporter.Export(ctx, t.service, spans[:n]); err != nil {
+			t.log.Warn("failed to export spans", "spans", n, "err", err)
+		}
+		cancel()
+
+		spans = spans[n:]
+	}
+}
+
+func (t *Tracer) end(d SpanData) {
+	if t.exporter == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if len(t.spans) >= maxQueued {
+		t.mu.Unlock()
+		return
+	}
+	t.spans = append(t.spans, d)
+	full := len(t.spans) >= maxBatch
+	t.mu.Unlock()
+
+	if full {
+		go t.Flush()
+	}
+}
+
+// Span is a single operation within a trace. A nil Span does nothing.
+type Span struct {
+	t *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanData is a finished span.
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+
+	// Err is set when the operation failed.
+	Err string
+}
+
+// Attribute describes a span. Values are strings, bools, ints or float64s.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SetAttributes adds key-value pairs to the span (e.g., "plan", "deploy").
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.data.Attributes = append(s.data.Attributes, Attribute{
+			Key:   fmt.Sprint(kv[i]),
+			Value: kv[i+1],
+		})
+	}
+}
+
+// RecordError marks the span as failed, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err.Error()
+}
+
+// End ends the span. Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	s.mu.Unlock()
+
+	s.t.end(d)
+}
+
+// TraceID returns the hex encoded ID of the span's trace.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.data.TraceID
+}
+
+// TraceParent returns the W3C traceparent header that makes the span the
+// parent of another (e.g., one started by a task).
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", s.data.TraceID, s.data.SpanID)
+}
+
+// parentKey is the context key of the parent of new spans: either a *Span or,
+// after ContextWithTraceParent, a remoteParent.
+type parentKey struct{}
+
+type remoteParent struct {
+	TraceID string
+	SpanID  string
+}
+
+// ContextWithTraceParent returns a context whose spans are children of the
+// span described by the W3C traceparent header, rather than of any span
+// already in the context. An invalid header is ignored.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+
+	for _, p := range parts[1:3] {
+		if _, err := hex.DecodeString(p); err != nil || strings.Trim(p, "0") == "" {
+			return ctx
+		}
+	}
+
+	return context.WithValue(ctx, parentKey{}, remoteParent{
+		TraceID: strings.ToLower(parts[1]),
+		SpanID:  strings.ToLower(parts[2]),
+	})
+}
+
+// SpanFromContext returns the span in the context, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(parentKey{}).(*Span)
+	return s
+}
+
+func spanContext(ctx context.Context) (remoteParent, bool) {
+	switch p := ctx.Value(parentKey{}).(type) {
+	case *Span:
+		return remoteParent{TraceID: p.data.TraceID, SpanID: p.data.SpanID}, true
+	case remoteParent:
+		return p, true
+	default:
+		return remoteParent{}, false
+	}
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
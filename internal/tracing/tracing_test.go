@@ -0,0 +1,187 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/poy/onpar"
+	. "github.com/poy/onpar/expect"
+	. "github.com/poy/onpar/matchers"
+	"github.com/poy/triple-c/internal/tracing"
+)
+
+type TT struct {
+	*testing.T
+	spyExporter *spyExporter
+	t           *tracing.Tracer
+}
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+	o := onpar.New()
+	defer o.Run(t)
+
+	o.BeforeEach(func(t *testing.T) TT {
+		spyExporter := &spyExporter{}
+		return TT{
+			T:           t,
+			spyExporter: spyExporter,
+			t:           tracing.New("some-service", spyExporter, 0, slog.New(slog.NewTextHandler(ioutil.Discard, nil))),
+		}
+	})
+
+	o.Spec("it exports ended spans when flushed", func(t TT) {
+		_, s := t.t.Start(context.Background(), "some-span")
+		s.SetAttributes("some-key", "some-value", "some-int", 99)
+		s.End()
+		s.End()
+
+		t.t.Flush()
+		Expect(t, t.spyExporter.service).To(Equal("some-service"))
+		Expect(t, t.spyExporter.spans).To(HaveLen(1))
+
+		d := t.spyExporter.spans[0]
+		Expect(t, d.Name).To(Equal("some-span"))
+		Expect(t, d.TraceID).To(HaveLen(32))
+		Expect(t, d.SpanID).To(HaveLen(16))
+		Expect(t, d.ParentSpanID).To(Equal(""))
+		Expect(t, d.End.Before(d.Start)).To(BeFalse())
+		Expect(t, d.Attributes).To(Equal([]tracing.Attribute{
+			{Key: "some-key", Value: "some-value"},
+			{Key: "some-int", Value: 99},
+		}))
+
+		t.t.Flush()
+		Expect(t, t.spyExporter.calls).To(Equal(1))
+	})
+
+	o.Spec("it does not export spans that have not ended", func(t TT) {
+		t.t.Start(context.Background(), "some-span")
+		t.t.Flush()
+		Expect(t, t.spyExporter.calls).To(Equal(0))
+	})
+
+	o.Spec("it parents spans from the context", func(t TT) {
+		ctx, parent := t.t.Start(context.Background(), "parent")
+		_, child := t.t.Start(ctx, "child")
+		child.End()
+		parent.End()
+
+		Expect(t, tracing.SpanFromContext(ctx)).To(Equal(parent))
+		Expect(t, child.TraceID()).To(Equal(parent.TraceID()))
+
+		t.t.Flush()
+		Expect(t, t.spyExporter.spans).To(HaveLen(2))
+		Expect(t, t.spyExporter.spans[0].ParentSpanID).To(Equal(t.spyExporter.spans[1].SpanID))
+	})
+
+	o.Spec("it records errors", func(t TT) {
+		_, s := t.t.Start(context.Background(), "some-span")
+		s.RecordError(nil)
+		s.RecordError(errors.New("some-error"))
+		s.End()
+
+		t.t.Flush()
+		Expect(t, t.spyExporter.spans[0].Err).To(Equal("some-error"))
+	})
+
+	o.Spec("it continues a trace from a traceparent header", func(t TT) {
+		ctx := tracing.ContextWithTraceParent(context.Background(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		_, s := t.t.Start(ctx, "some-span")
+		s.End()
+
+		t.t.Flush()
+		Expect(t, t.spyExporter.spans[0].TraceID).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+		Expect(t, t.spyExporter.spans[0].ParentSpanID).To(Equal("b7ad6b7169203331"))
+	})
+
+	o.Spec("it prefers a traceparent header to the span in the context", func(t TT) {
+		ctx, _ := t.t.Start(context.Background(), "parent")
+		ctx = tracing.ContextWithTraceParent(ctx, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		_, s := t.t.Start(ctx, "some-span")
+
+		Expect(t, s.TraceID()).To(Equal("0af7651916cd43dd8448eb211c80319c"))
+	})
+
+	o.Spec("it ignores invalid traceparent headers", func(t TT) {
+		for _, h := range []string{
+			"",
+			"invalid",
+			"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+			"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+			"00-0af7651916cd43dd8448eb211c80319c-zzzzzzzzzzzzzzzz-01",
+		} {
+			ctx := tracing.ContextWithTraceParent(context.Background(), h)
+			_, s := t.t.Start(ctx, "some-span")
+			Expect(t, s.TraceID()).To(Not(Equal("0af7651916cd43dd8448eb211c80319c")))
+		}
+	})
+
+	o.Spec("it writes a traceparent that continues the span", func(t TT) {
+		_, parent := t.t.Start(context.Background(), "parent")
+		ctx := tracing.ContextWithTraceParent(context.Background(), parent.TraceParent())
+		_, child := t.t.Start(ctx, "child")
+		child.End()
+
+		t.t.Flush()
+		Expect(t, parent.TraceParent()).To(HaveLen(55))
+		Expect(t, t.spyExporter.spans[0].TraceID).To(Equal(parent.TraceID()))
+	})
+
+	o.Spec("it exports a full batch without waiting", func(t TT) {
+		for i := 0; i < 512; i++ {
+			_, s := t.t.Start(context.Background(), "some-span")
+			s.End()
+		}
+
+		Expect(t, func() int { return t.spyExporter.Calls() }).To(ViaPolling(Equal(1)))
+	})
+
+	o.Spec("a nil tracer does nothing", func(t TT) {
+		var tracer *tracing.Tracer
+		ctx, s := tracer.Start(context.Background(), "some-span")
+		s.SetAttributes("some-key", "some-value")
+		s.RecordError(errors.New("some-error"))
+		s.End()
+		tracer.Flush()
+
+		Expect(t, tracing.SpanFromContext(ctx) == nil).To(BeTrue())
+		Expect(t, s.TraceID()).To(Equal(""))
+		Expect(t, s.TraceParent()).To(Equal(""))
+	})
+
+	o.Spec("it makes spans without an exporter", func(t TT) {
+		tracer := tracing.New("some-service", nil, 0, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+		_, s := tracer.Start(context.Background(), "some-span")
+		s.End()
+		tracer.Flush()
+
+		Expect(t, s.TraceID()).To(HaveLen(32))
+	})
+}
+
+type spyExporter struct {
+	mu      sync.Mutex
+	calls   int
+	service string
+	spans   []tracing.SpanData
+}
+
+func (s *spyExporter) Export(ctx context.Context, service string, spans []tracing.SpanData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.service = service
+	s.spans = append(s.spans, spans...)
+	return nil
+}
+
+func (s *spyExporter) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}